@@ -13,14 +13,104 @@ import (
 
 type Client interface {
 	Get(key string) (val interface{}, err error)
+	GetWithVersion(key string) (val interface{}, version uint64, err error)
 	Set(key string, val interface{}, ttl time.Duration) error
+	SetNX(key string, val interface{}, ttl time.Duration) (bool, error)
+	SetSliding(key string, val interface{}, ttl time.Duration) error
+	GetSet(key string, val interface{}, ttl time.Duration) (old interface{}, err error)
 	Update(key string, val interface{}, ttl time.Duration) error
 	Remove(key string) error
+	CompareAndSwap(key string, val interface{}, version uint64, ttl time.Duration) error
+	MGet(keys ...string) ([]interface{}, error)
+	MSet(items ...Item) error
+	MDel(keys ...string) ([]error, error)
+	TTL(key string) (time.Duration, error)
+	Expire(key string, ttl time.Duration) error
+	ExpireAt(key string, at time.Time) error
+	Persist(key string) error
+	Touch(key string) error
+	Sliding(key string) (time.Duration, error)
 	Keys() ([]string, error)
+	KeysMatching(pattern string) ([]string, error)
+	Scan(cursor uint64, pattern string, count int) (keys []string, next uint64, err error)
+	Iterate(pattern string, count int) *KeyIterator
+	Incr(key string) (int64, error)
+	Decr(key string) (int64, error)
+	IncrBy(key string, delta int64) (int64, error)
+	LPush(key string, vals ...interface{}) (int, error)
+	RPush(key string, vals ...interface{}) (int, error)
+	LPop(key string) (interface{}, error)
+	RPop(key string) (interface{}, error)
+	LRange(key string, start, stop int) ([]interface{}, error)
+	LTrim(key string, start, stop int) error
+	LLen(key string) (int, error)
+	HGet(key string, field interface{}) (interface{}, error)
+	HSet(key string, field, val interface{}) (bool, error)
+	HDel(key string, fields ...interface{}) (int, error)
+	HKeys(key string) ([]interface{}, error)
+	HLen(key string) (int, error)
+	HIncrBy(key string, field interface{}, delta int64) (int64, error)
+	Exec(fn func(tx *Tx) error) ([]interface{}, error)
+	Watch(keys []string, fn func(tx *Tx) error) error
+	Subscribe(patterns ...string) (events <-chan Event, cancel func(), err error)
+	Publish(channel string, val interface{}) (int, error)
+	NewSubscriber() (*Subscriber, error)
+	FlushDB() error
+	DBStats() (map[int]int, error)
+	Info() (*Info, error)
+	Resize(n int) error
+	Snapshot() error
 	Close()
 }
 
-func New(addr string, connPoolSize int) (Client, error) {
+// Info are metrics of the selected database, see Client.Info.
+type Info struct {
+	// Hits and Misses are the numbers of reads of present and missed keys.
+	Hits   int
+	Misses int
+	// Sets is the number of values stored.
+	Sets      int
+	Evictions int
+	// Expired is the number of expired keys reclaimed in background.
+	Expired int
+	// LazyExpired is the number of expired keys removed once they are read.
+	LazyExpired int
+	Buckets     []BucketInfo
+	// Role is "primary" or "replica" for a server which copies data of another one,
+	// "leader", "follower" or "candidate" for a node of a cluster.
+	Role string
+	// Offset is a position in the replication stream in bytes, written by a primary or applied by a replica,
+	// or the index of the last Raft entry applied by a node of a cluster.
+	Offset int
+	// Leader is an address of the leader of a cluster, empty if it is not elected yet or there is no cluster.
+	Leader string
+}
+
+// BucketInfo are metrics of a single bucket of a store.
+type BucketInfo struct {
+	Entries int
+	// Bytes is a total size of keys and values.
+	Bytes int
+}
+
+// Item is a value to be set for a key with its ttl, see MSet.
+type Item struct {
+	Key string
+	Val interface{}
+	TTL time.Duration
+}
+
+// Option configures a client.
+type Option func(c *client)
+
+// WithDB selects a database by its index for all the connections, default: 0.
+func WithDB(db int) Option {
+	return func(c *client) {
+		c.db = db
+	}
+}
+
+func New(addr string, connPoolSize int, opts ...Option) (Client, error) {
 	if connPoolSize <= 0 {
 		connPoolSize = 1
 	}
@@ -34,6 +124,9 @@ func New(addr string, connPoolSize int) (Client, error) {
 		closing:      make(chan struct{}),
 		decoder:      proto.NewDecoder(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
 
 	for i := 0; i < connPoolSize; i++ {
 		conn, err := c.makeConn(addr)
@@ -72,9 +165,43 @@ func (c *client) makeConn(addr string) (net.Conn, error) {
 	//		return nil, err
 	//	}
 
+	if c.db != 0 {
+		if err = c.selectDB(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	return conn, nil
 }
 
+// selectDB switches a connection to the database of the client.
+func (c *client) selectDB(conn net.Conn) error {
+	_, err := c.roundTrip(conn, proto.NewSelectMessage(c.db))
+	return err
+}
+
+// roundTrip sends a message b over a connection held by the caller and decodes the response,
+// which is returned as an error if it is one.
+func (c *client) roundTrip(conn net.Conn, b []byte) (val interface{}, err error) {
+	if _, err = conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	response, err := proto.NewResponseScanner(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err = c.decoder.Decode(response)
+	if err != nil {
+		return nil, err
+	}
+
+	err, _ = val.(error)
+	return
+}
+
 type client struct {
 	addr         string
 	connPoolSize int
@@ -83,6 +210,7 @@ type client struct {
 	connPoolMax  int
 	closing      chan struct{}
 	decoder      proto.Decoder
+	db           int
 }
 
 var ErrTerminated = errors.New("terminated")
@@ -150,6 +278,33 @@ func (c *client) Get(key string) (val interface{}, err error) {
 	return c.processMessage(msg)
 }
 
+// GetWithVersion returns a value along with its version to be used with CompareAndSwap.
+func (c *client) GetWithVersion(key string) (val interface{}, version uint64, err error) {
+	msg, err := proto.NewMessage(proto.CmdGetWithVersion, key, nil, 0)
+	if err != nil {
+		return
+	}
+
+	response, err := c.processMessage(msg)
+	if err != nil {
+		return
+	}
+
+	vals, ok := response.([]interface{})
+	if !ok || len(vals) != 2 {
+		log.Err("should return slice of value and version, got %T - % q", response, response)
+		return nil, 0, proto.ErrUnknown
+	}
+
+	v, ok := vals[1].(int)
+	if !ok {
+		log.Err("version should be int, got %T - % q", vals[1], vals[1])
+		return nil, 0, proto.ErrUnknown
+	}
+
+	return vals[0], uint64(v), nil
+}
+
 func (c *client) Set(key string, val interface{}, ttl time.Duration) (err error) {
 	msg, err := proto.NewMessage(proto.CmdSet, key, val, ttl)
 	if err != nil {
@@ -160,6 +315,39 @@ func (c *client) Set(key string, val interface{}, ttl time.Duration) (err error)
 	return err
 }
 
+// SetSliding sets a value for a key with ttl which is renewed every time the key is read with Get,
+// like a session expiring after ttl of inactivity.
+func (c *client) SetSliding(key string, val interface{}, ttl time.Duration) error {
+	msg, err := proto.NewMessage(proto.CmdSetSliding, key, val, ttl)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
+}
+
+// SetNX sets a value for a key only if the key is missed and reports whether it was set.
+func (c *client) SetNX(key string, val interface{}, ttl time.Duration) (bool, error) {
+	msg, err := proto.NewMessage(proto.CmdSetNX, key, val, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return n == 1, err
+}
+
+// GetSet sets a value for a key and returns the previous one, nil if the key was missed.
+func (c *client) GetSet(key string, val interface{}, ttl time.Duration) (old interface{}, err error) {
+	msg, err := proto.NewMessage(proto.CmdGetSet, key, val, ttl)
+	if err != nil {
+		return
+	}
+
+	return c.processMessage(msg)
+}
+
 func (c *client) Update(key string, val interface{}, ttl time.Duration) (err error) {
 	msg, err := proto.NewMessage(proto.CmdUpdate, key, val, ttl)
 	if err != nil {
@@ -180,8 +368,154 @@ func (c *client) Remove(key string) error {
 	return err
 }
 
+// CompareAndSwap sets a value for a key only if its version still matches one returned by GetWithVersion.
+// The "version mismatch" error is returned if the value was changed since.
+func (c *client) CompareAndSwap(key string, val interface{}, version uint64, ttl time.Duration) error {
+	msg, err := proto.NewCompareAndSwapMessage(key, val, version, ttl)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
+}
+
+// TTL returns the time left before a key expires, 0 if it never does.
+func (c *client) TTL(key string) (time.Duration, error) {
+	msg, err := proto.NewMessage(proto.CmdTTL, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return time.Duration(n), err
+}
+
+// Expire sets a key to expire in ttl without rewriting its value.
+// A ttl which is not positive removes the key right away.
+func (c *client) Expire(key string, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	_, err := c.processMessage(proto.NewExpireMessage(key, ttl))
+	return err
+}
+
+// ExpireAt sets a key to expire at a time without rewriting its value.
+// A time which is not in the future removes the key right away.
+func (c *client) ExpireAt(key string, at time.Time) error {
+	_, err := c.processMessage(proto.NewExpireAtMessage(key, at))
+	return err
+}
+
+// Persist removes ttl of a key, so it never expires.
+func (c *client) Persist(key string) error {
+	msg, err := proto.NewMessage(proto.CmdPersist, key, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
+}
+
+// Touch records a usage of a key for the eviction policy of the server without reading its value.
+func (c *client) Touch(key string) error {
+	msg, err := proto.NewMessage(proto.CmdTouch, key, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
+}
+
+// Sliding returns the ttl a key is renewed with by reads, 0 if it is not renewed.
+func (c *client) Sliding(key string) (time.Duration, error) {
+	msg, err := proto.NewMessage(proto.CmdSliding, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return time.Duration(n), err
+}
+
+// MGet returns values of keys in a single round trip in the same order.
+// A missed key gets an error in place of its value, the same one Get returns.
+func (c *client) MGet(keys ...string) ([]interface{}, error) {
+	return c.processMultiKeyMessage(proto.NewKeysMessage(proto.CmdMGet, keys), len(keys))
+}
+
+// MSet sets values of items with their own ttls in a single round trip.
+// An error of the first item failed is returned if any, the others are set anyway.
+func (c *client) MSet(items ...Item) error {
+	keys := make([]string, len(items))
+	vals := make([]interface{}, len(items))
+	ttls := make([]time.Duration, len(items))
+	for i, it := range items {
+		keys[i], vals[i], ttls[i] = it.Key, it.Val, it.TTL
+	}
+
+	msg, err := proto.NewMSetMessage(keys, vals, ttls)
+	if err != nil {
+		return err
+	}
+
+	results, err := c.processMultiKeyMessage(msg, len(items))
+	if err != nil {
+		return err
+	}
+
+	for _, res := range results {
+		if err, ok := res.(error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MDel removes keys in a single round trip and returns their errors in the same order,
+// nil for ones removed and the one Remove returns otherwise, like for a missed key.
+func (c *client) MDel(keys ...string) ([]error, error) {
+	results, err := c.processMultiKeyMessage(proto.NewKeysMessage(proto.CmdMDel, keys), len(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(results))
+	for i, res := range results {
+		errs[i], _ = res.(error)
+	}
+
+	return errs, nil
+}
+
+// processMultiKeyMessage returns a slice of results of a command run for n keys.
+func (c *client) processMultiKeyMessage(b []byte, n int) ([]interface{}, error) {
+	response, err := c.processMessage(b)
+	if err != nil {
+		return nil, err
+	}
+
+	results, ok := response.([]interface{})
+	if !ok || len(results) != n {
+		log.Err("should return slice of %d results, got %T - % q", n, response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	return results, nil
+}
+
 func (c *client) Keys() (keys []string, err error) {
-	msg, err := proto.NewMessage(proto.CmdKeys, "", nil, 0)
+	return c.KeysMatching("")
+}
+
+// KeysMatching returns keys matching a glob-style pattern, see glob package for the syntax.
+func (c *client) KeysMatching(pattern string) (keys []string, err error) {
+	msg, err := proto.NewMessage(proto.CmdKeys, pattern, nil, 0)
 	if err != nil {
 		return
 	}
@@ -191,6 +525,39 @@ func (c *client) Keys() (keys []string, err error) {
 		return
 	}
 
+	return toStrings(response)
+}
+
+// Scan returns keys matching pattern out of about count ones visited starting from cursor
+// and a cursor to continue from. Iteration starts and ends with 0 cursor.
+func (c *client) Scan(cursor uint64, pattern string, count int) (keys []string, next uint64, err error) {
+	response, err := c.processMessage(proto.NewScanMessage(cursor, pattern, count))
+	if err != nil {
+		return
+	}
+
+	vals, ok := response.([]interface{})
+	if !ok || len(vals) != 2 {
+		log.Err("scan should return slice of cursor and keys, got %T - % q", response, response)
+		return nil, 0, proto.ErrUnknown
+	}
+
+	cur, ok := vals[0].(int)
+	if !ok {
+		log.Err("scan cursor should be int, got %T - % q", vals[0], vals[0])
+		return nil, 0, proto.ErrUnknown
+	}
+
+	keys, err = toStrings(vals[1])
+	return keys, uint64(cur), err
+}
+
+// Iterate returns an iterator over keys matching pattern fetching about count keys at a time.
+func (c *client) Iterate(pattern string, count int) *KeyIterator {
+	return &KeyIterator{c: c, pattern: pattern, count: count}
+}
+
+func toStrings(response interface{}) (keys []string, err error) {
 	vals, ok := response.([]interface{})
 	if !ok {
 		log.Err("keys should return slice, got %T - % q", response, response)
@@ -207,7 +574,322 @@ func (c *client) Keys() (keys []string, err error) {
 		keys[i] = k
 	}
 
-	return keys, err
+	return keys, nil
+}
+
+// Incr atomically increments an integer value of a key by one and returns the result.
+// A missed key is considered to be 0.
+func (c *client) Incr(key string) (int64, error) {
+	msg, err := proto.NewMessage(proto.CmdIncr, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.processIntMessage(msg)
+}
+
+// Decr atomically decrements an integer value of a key by one and returns the result.
+// A missed key is considered to be 0.
+func (c *client) Decr(key string) (int64, error) {
+	msg, err := proto.NewMessage(proto.CmdDecr, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.processIntMessage(msg)
+}
+
+// IncrBy atomically adds delta to an integer value of a key and returns the result.
+// A missed key is considered to be 0.
+func (c *client) IncrBy(key string, delta int64) (int64, error) {
+	return c.processIntMessage(proto.NewIncrByMessage(key, delta))
+}
+
+func (c *client) processIntMessage(b []byte) (int64, error) {
+	response, err := c.processMessage(b)
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := response.(int)
+	if !ok {
+		log.Err("should return int, got %T - % q", response, response)
+		return 0, proto.ErrUnknown
+	}
+
+	return int64(n), nil
+}
+
+// LPush adds values to the head of a list one by one and returns its new length.
+// A missed key is considered to be an empty list.
+func (c *client) LPush(key string, vals ...interface{}) (int, error) {
+	return c.push(proto.CmdLPush, key, vals)
+}
+
+// RPush adds values to the tail of a list and returns its new length.
+// A missed key is considered to be an empty list.
+func (c *client) RPush(key string, vals ...interface{}) (int, error) {
+	return c.push(proto.CmdRPush, key, vals)
+}
+
+func (c *client) push(cmd byte, key string, vals []interface{}) (int, error) {
+	msg, err := proto.NewPushMessage(cmd, key, vals)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return int(n), err
+}
+
+// LPop removes and returns the first element of a list.
+func (c *client) LPop(key string) (interface{}, error) {
+	msg, err := proto.NewMessage(proto.CmdLPop, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.processMessage(msg)
+}
+
+// RPop removes and returns the last element of a list.
+func (c *client) RPop(key string) (interface{}, error) {
+	msg, err := proto.NewMessage(proto.CmdRPop, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.processMessage(msg)
+}
+
+// LRange returns elements of a list between start and stop inclusive.
+// Negative indexes count from the end of the list, -1 is the last element.
+func (c *client) LRange(key string, start, stop int) ([]interface{}, error) {
+	response, err := c.processMessage(proto.NewRangeMessage(proto.CmdLRange, key, start, stop))
+	if err != nil {
+		return nil, err
+	}
+
+	vals, ok := response.([]interface{})
+	if !ok {
+		log.Err("range should return slice, got %T - % q", response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	return vals, nil
+}
+
+// LTrim keeps only elements of a list between start and stop inclusive.
+func (c *client) LTrim(key string, start, stop int) error {
+	_, err := c.processMessage(proto.NewRangeMessage(proto.CmdLTrim, key, start, stop))
+	return err
+}
+
+// LLen returns the length of a list, 0 if the key is missed.
+func (c *client) LLen(key string) (int, error) {
+	msg, err := proto.NewMessage(proto.CmdLLen, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return int(n), err
+}
+
+// HGet returns a value of a map field.
+func (c *client) HGet(key string, field interface{}) (interface{}, error) {
+	msg, err := proto.NewFieldMessage(proto.CmdHGet, key, field, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.processMessage(msg)
+}
+
+// HSet sets a value of a map field and reports whether the field is new.
+// A missed key is considered to be an empty map.
+func (c *client) HSet(key string, field, val interface{}) (bool, error) {
+	msg, err := proto.NewFieldMessage(proto.CmdHSet, key, field, val)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return n == 1, err
+}
+
+// HDel removes map fields and returns the number of ones removed.
+func (c *client) HDel(key string, fields ...interface{}) (int, error) {
+	return c.push(proto.CmdHDel, key, fields)
+}
+
+// HKeys returns fields of a map.
+func (c *client) HKeys(key string) ([]interface{}, error) {
+	msg, err := proto.NewMessage(proto.CmdHKeys, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.processMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := response.([]interface{})
+	if !ok {
+		log.Err("fields should be slice, got %T - % q", response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	return fields, nil
+}
+
+// HLen returns the number of map fields, 0 if the key is missed.
+func (c *client) HLen(key string) (int, error) {
+	msg, err := proto.NewMessage(proto.CmdHLen, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return int(n), err
+}
+
+// HIncrBy atomically adds delta to an integer value of a map field and returns the result.
+// A missed field is considered to be 0.
+func (c *client) HIncrBy(key string, field interface{}, delta int64) (int64, error) {
+	msg, err := proto.NewFieldMessage(proto.CmdHIncrBy, key, field, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.processIntMessage(msg)
+}
+
+// FlushDB removes all the keys of the selected database.
+func (c *client) FlushDB() error {
+	msg, err := proto.NewMessage(proto.CmdFlushDB, "", nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
+}
+
+// Resize starts changing the number of buckets of the selected database to n, a multiple of the current one.
+// Keys are moved in background and stay available all the time.
+func (c *client) Resize(n int) error {
+	_, err := c.processMessage(proto.NewResizeMessage(n))
+	return err
+}
+
+// DBStats returns the number of keys in every database of the server by its index.
+func (c *client) DBStats() (map[int]int, error) {
+	msg, err := proto.NewMessage(proto.CmdDBStats, "", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.processMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := response.(map[interface{}]interface{})
+	if !ok {
+		log.Err("stats should be map, got %T - % q", response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	stats := make(map[int]int, len(m))
+	for k, v := range m {
+		db, ok := k.(int)
+		n, vok := v.(int)
+		if !ok || !vok {
+			log.Err("stats should be int to int map, got % q", m)
+			return nil, proto.ErrUnknown
+		}
+		stats[db] = n
+	}
+
+	return stats, nil
+}
+
+// Info returns metrics of the selected database, counters are taken since the server is started.
+func (c *client) Info() (*Info, error) {
+	msg, err := proto.NewMessage(proto.CmdInfo, "", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.processMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := response.(map[interface{}]interface{})
+	if !ok {
+		log.Err("info should be map, got %T - % q", response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	info := &Info{}
+	for k, v := range map[string]*int{
+		"hits":         &info.Hits,
+		"misses":       &info.Misses,
+		"sets":         &info.Sets,
+		"evictions":    &info.Evictions,
+		"expired":      &info.Expired,
+		"lazy_expired": &info.LazyExpired,
+		"offset":       &info.Offset,
+	} {
+		if *v, ok = m[k].(int); !ok {
+			log.Err("info should have int %q, got % q", k, m)
+			return nil, proto.ErrUnknown
+		}
+	}
+
+	if info.Role, ok = m["role"].(string); !ok {
+		log.Err("info should have role string, got % q", m)
+		return nil, proto.ErrUnknown
+	}
+
+	if info.Leader, ok = m["leader"].(string); !ok {
+		log.Err("info should have leader string, got % q", m)
+		return nil, proto.ErrUnknown
+	}
+
+	buckets, ok := m["buckets"].([]interface{})
+	if !ok {
+		log.Err("info should have buckets slice, got % q", m)
+		return nil, proto.ErrUnknown
+	}
+
+	info.Buckets = make([]BucketInfo, len(buckets))
+	for i, b := range buckets {
+		bm, _ := b.(map[interface{}]interface{})
+		entries, eok := bm["entries"].(int)
+		bytes, bok := bm["bytes"].(int)
+		if !eok || !bok {
+			log.Err("bucket info should be a map of ints, got % q", b)
+			return nil, proto.ErrUnknown
+		}
+		info.Buckets[i] = BucketInfo{Entries: entries, Bytes: bytes}
+	}
+
+	return info, nil
+}
+
+// Snapshot asks the server to save snapshots of its databases in background.
+func (c *client) Snapshot() error {
+	msg, err := proto.NewMessage(proto.CmdSnapshot, "", nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.processMessage(msg)
+	return err
 }
 
 func (c *client) Close() {
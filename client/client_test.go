@@ -1,12 +1,21 @@
 package client
 
 import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aliaksandrb/cachy/server"
 	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/store/mstore"
+	"github.com/aliaksandrb/cachy/wal"
 )
 
 func TestClient(t *testing.T) {
@@ -96,6 +105,1503 @@ func TestClientExpiration(t *testing.T) {
 	}
 }
 
+func TestClientTTL(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := []server.Option{server.WithDir(dir), server.WithWAL(wal.SyncAlways)}
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 3)
+	checkErr(t, err)
+
+	checkErr(t, session.Set("expiring", 1, 0))
+	checkErr(t, session.Set("persistent", 2, time.Hour))
+	checkErr(t, session.Set("removed", 3, 0))
+	checkErr(t, session.Set("short", 4, 0))
+
+	if ttl, err := session.TTL("expiring"); err != nil || ttl != 0 {
+		t.Errorf("key without ttl should have 0, got %v, err: %v", ttl, err)
+	}
+	if _, err := session.TTL("missed"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	checkErr(t, session.Expire("expiring", time.Hour))
+	checkErr(t, session.ExpireAt("short", time.Now().Add(30*time.Millisecond)))
+	checkErr(t, session.Persist("persistent"))
+	checkErr(t, session.Expire("removed", 0))
+	checkErr(t, session.Touch("expiring"))
+
+	if ttl, err := session.TTL("expiring"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl should be set, got %v, err: %v", ttl, err)
+	}
+	if err := session.Touch("removed"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("key should be removed, got: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := session.Get("short"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("key should be expired, got: %v", err)
+	}
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	if ttl, err := session.TTL("expiring"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl should be replayed, got %v, err: %v", ttl, err)
+	}
+	if ttl, err := session.TTL("persistent"); err != nil || ttl != 0 {
+		t.Errorf("removed ttl should be replayed, got %v, err: %v", ttl, err)
+	}
+	if keys, _ := session.Keys(); len(keys) != 2 {
+		t.Errorf("only keys not expired should be replayed, got %v", keys)
+	}
+}
+
+func TestClientSliding(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := []server.Option{server.WithDir(dir), server.WithWAL(wal.SyncAlways)}
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+
+	checkErr(t, session.SetSliding("session", "kermit", 500*time.Millisecond))
+	checkErr(t, session.Set("plain", 1, 500*time.Millisecond))
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = session.Get("session")
+	checkErr(t, err)
+	_, err = session.Get("plain")
+	checkErr(t, err)
+
+	// Both keys are past their first deadline, only the one read keeps living.
+	time.Sleep(250 * time.Millisecond)
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	if ttl, err := session.Sliding("session"); err != nil || ttl != 500*time.Millisecond {
+		t.Errorf("renewed key should be replayed with its sliding ttl, got %v, err: %v", ttl, err)
+	}
+	if _, err := session.Get("plain"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("key should be expired, got: %v", err)
+	}
+}
+
+func TestClientPersistantStore(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	srv, err := server.Run(server.PersistantStore, 0, ":3000", server.WithDir(dir))
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+
+	want := []interface{}{"hello", 42}
+	if err = session.Set("key", want, 0); err != nil {
+		t.Fatalf("unable to store new value: %+v, err: %v", want, err)
+	}
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.PersistantStore, 0, ":3000", server.WithDir(dir))
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	got, err := session.Get("key")
+	if err != nil {
+		t.Fatalf("value should survive a restart, err: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("should be equal: got %q, want %q", got, want)
+	}
+}
+
+func TestClientSnapshot(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", server.WithDir(dir))
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+
+	want := map[interface{}]interface{}{"hello": "world"}
+	checkErr(t, session.Set("key", want, 0))
+	checkErr(t, session.Snapshot())
+
+	// Snapshot is taken in background.
+	time.Sleep(50 * time.Millisecond)
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", server.WithDir(dir))
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	got, err := session.Get("key")
+	if err != nil {
+		t.Fatalf("value should be restored from a snapshot, err: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("should be equal: got %q, want %q", got, want)
+	}
+}
+
+func TestClientWAL(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", server.WithDir(dir), server.WithWAL(wal.SyncAlways))
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+
+	checkErr(t, session.Set("updated", 1, 0))
+	checkErr(t, session.Update("updated", 2, 0))
+	checkErr(t, session.Set("removed", 3, 0))
+	checkErr(t, session.Remove("removed"))
+	checkErr(t, session.Set("expired", 4, time.Millisecond))
+	for i := 0; i < 3; i++ {
+		_, err = session.Incr("counter")
+		checkErr(t, err)
+	}
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", server.WithDir(dir), server.WithWAL(wal.SyncAlways))
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	got, err := session.Get("updated")
+	if err != nil || got != 2 {
+		t.Errorf("update should be replayed, got %v, err: %v", got, err)
+	}
+
+	got, err = session.Get("counter")
+	if err != nil || got != 3 {
+		t.Errorf("increments should be replayed, got %v, err: %v", got, err)
+	}
+
+	for _, key := range []string{"removed", "expired"} {
+		if got, err = session.Get(key); !reflect.DeepEqual(err, store.ErrNotFound) {
+			t.Errorf("%s: should be error: got: %v, want: %v, err: %v", key, got, store.ErrNotFound, err)
+		}
+	}
+}
+
+func TestClientKeys(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		want = append(want, key)
+		checkErr(t, session.Set(key, i, 0))
+		checkErr(t, session.Set(fmt.Sprintf("session:%d", i), i, 0))
+	}
+	sort.Strings(want)
+
+	keys, err := session.KeysMatching("user:*")
+	checkErr(t, err)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+
+	seen := make(map[string]bool)
+	it := session.Iterate("user:*", 7)
+	for it.Next() {
+		seen[it.Key()] = true
+	}
+	checkErr(t, it.Err())
+
+	keys = keys[:0]
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected iterated keys: got %q, want %q", keys, want)
+	}
+}
+
+func TestClientCounters(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := session.Incr("counter"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := session.Get("counter")
+	if err != nil || got != 1000 {
+		t.Errorf("increments should not race, got %v, err: %v", got, err)
+	}
+
+	n, err := session.IncrBy("counter", -500)
+	if err != nil || n != 500 {
+		t.Errorf("unexpected IncrBy result, got %d, err: %v", n, err)
+	}
+
+	n, err = session.Decr("missed")
+	if err != nil || n != -1 {
+		t.Errorf("missed key should be considered 0, got %d, err: %v", n, err)
+	}
+
+	checkErr(t, session.Set("string", "value", 0))
+	if _, err = session.Incr("string"); !reflect.DeepEqual(err, store.ErrNotInteger) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotInteger)
+	}
+}
+
+func TestClientCompareAndSwap(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("counter", 0, 0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; {
+				val, version, err := session.GetWithVersion("counter")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				err = session.CompareAndSwap("counter", val.(int)+1, version, 0)
+				if reflect.DeepEqual(err, store.ErrVersionMismatch) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := session.Get("counter")
+	if err != nil || got != 100 {
+		t.Errorf("swaps should not be lost, got %v, err: %v", got, err)
+	}
+
+	if err = session.CompareAndSwap("missed", 1, 1, 0); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+}
+
+func TestClientConditionalSet(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		taken int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := session.SetNX("lock", i, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if ok {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if taken != 1 {
+		t.Errorf("lock should be taken once, got %d", taken)
+	}
+
+	old, err := session.GetSet("key", "first", 0)
+	if err != nil || old != nil {
+		t.Errorf("missed key should return nil, got %v, err: %v", old, err)
+	}
+
+	old, err = session.GetSet("key", "second", 0)
+	if err != nil || old != "first" {
+		t.Errorf("previous value should be returned, got %v, err: %v", old, err)
+	}
+}
+
+func TestClientMultiKey(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	err = session.MSet(
+		Item{Key: "first", Val: 1},
+		Item{Key: "second", Val: []interface{}{"two"}, TTL: 20 * time.Millisecond},
+		Item{Key: "third", Val: nil},
+	)
+	checkErr(t, err)
+
+	vals, err := session.MGet("first", "missed", "second", "third")
+	want := []interface{}{1, store.ErrNotFound, []interface{}{"two"}, nil}
+	if err != nil || !reflect.DeepEqual(vals, want) {
+		t.Errorf("unexpected values, got %v, want %v, err: %v", vals, want, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	vals, err = session.MGet("second")
+	if want = []interface{}{store.ErrNotFound}; err != nil || !reflect.DeepEqual(vals, want) {
+		t.Errorf("key should be expired, got %v, err: %v", vals, err)
+	}
+
+	errs, err := session.MDel("first", "missed", "third")
+	if want := []error{nil, store.ErrNotFound, nil}; err != nil || !reflect.DeepEqual(errs, want) {
+		t.Errorf("unexpected results, got %v, want %v, err: %v", errs, want, err)
+	}
+
+	vals, err = session.MGet("first", "third")
+	if want = []interface{}{store.ErrNotFound, store.ErrNotFound}; err != nil || !reflect.DeepEqual(vals, want) {
+		t.Errorf("keys should be removed, got %v, err: %v", vals, err)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	n, err := session.RPush("list", 2, "three")
+	if err != nil || n != 2 {
+		t.Errorf("unexpected push result, got %d, err: %v", n, err)
+	}
+
+	n, err = session.LPush("list", 1, []interface{}{0})
+	if err != nil || n != 4 {
+		t.Errorf("unexpected push result, got %d, err: %v", n, err)
+	}
+
+	got, err := session.LRange("list", 0, -1)
+	checkErr(t, err)
+	if want := []interface{}{[]interface{}{0}, 1, 2, "three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected range: got %v, want %v", got, want)
+	}
+
+	val, err := session.RPop("list")
+	if err != nil || val != "three" {
+		t.Errorf("unexpected pop result, got %v, err: %v", val, err)
+	}
+
+	checkErr(t, session.LTrim("list", 1, -1))
+
+	val, err = session.Get("list")
+	if want := []interface{}{1, 2}; err != nil || !reflect.DeepEqual(val, want) {
+		t.Errorf("list should be readable as a slice, got %v, want %v, err: %v", val, want, err)
+	}
+
+	if n, err = session.LLen("list"); err != nil || n != 2 {
+		t.Errorf("unexpected length, got %d, err: %v", n, err)
+	}
+
+	checkErr(t, session.Set("string", "value", 0))
+	if _, err = session.LPush("string", 1); !reflect.DeepEqual(err, store.ErrNotList) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotList)
+	}
+
+	if _, err = session.LPop("missed"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+}
+
+func TestClientHash(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	server, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer server.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("session", map[interface{}]interface{}{"name": "kermit", 1: []interface{}{"one"}}, 0))
+
+	val, err := session.HGet("session", 1)
+	if want := []interface{}{"one"}; err != nil || !reflect.DeepEqual(val, want) {
+		t.Errorf("unexpected field value, got %v, want %v, err: %v", val, want, err)
+	}
+
+	created, err := session.HSet("session", "name", "piggy")
+	if err != nil || created {
+		t.Errorf("existing field should be updated, got %v, err: %v", created, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := session.HIncrBy("session", "visits", 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := session.HDel("session", 1, "missed")
+	if err != nil || n != 1 {
+		t.Errorf("unexpected deleted number, got %d, err: %v", n, err)
+	}
+
+	fields, err := session.HKeys("session")
+	checkErr(t, err)
+	if len(fields) != 2 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	got, err := session.Get("session")
+	if want := map[interface{}]interface{}{"name": "piggy", "visits": 100}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("map should be readable as a whole, got %v, want %v, err: %v", got, want, err)
+	}
+
+	if _, err = session.HGet("session", "missed"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+}
+
+func TestClientDatabases(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := []server.Option{server.WithDir(dir), server.WithDatabases(3), server.WithWAL(wal.SyncAlways)}
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+
+	first, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+
+	second, err := New("127.0.0.1:3000", 3, WithDB(2))
+	checkErr(t, err)
+
+	checkErr(t, first.Set("key", "first", 0))
+	checkErr(t, first.Set("other", "first", 0))
+	checkErr(t, second.Set("key", "second", 0))
+
+	if got, err := first.Get("key"); err != nil || got != "first" {
+		t.Errorf("databases should be isolated, got %v, err: %v", got, err)
+	}
+	if _, err = second.Get("other"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	stats, err := second.DBStats()
+	if want := map[int]int{0: 2, 1: 0, 2: 1}; err != nil || !reflect.DeepEqual(stats, want) {
+		t.Errorf("unexpected stats, got %v, want %v, err: %v", stats, want, err)
+	}
+
+	checkErr(t, first.FlushDB())
+	checkErr(t, second.Set("flushed", "no", 0))
+
+	if _, err = New("127.0.0.1:3000", 1, WithDB(3)); !reflect.DeepEqual(err, server.ErrBadDB) {
+		t.Errorf("should be error: got: %v, want: %v", err, server.ErrBadDB)
+	}
+
+	first.Close()
+	second.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	stats, err = session.DBStats()
+	if want := map[int]int{0: 0, 1: 0, 2: 2}; err != nil || !reflect.DeepEqual(stats, want) {
+		t.Errorf("flushes and writes of all databases should be replayed, got %v, want %v, err: %v", stats, want, err)
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("key", "value", 0))
+	_, err = session.Get("key")
+	checkErr(t, err)
+	_, err = session.Get("missed")
+	if !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	info, err := session.Info()
+	checkErr(t, err)
+
+	if info.Hits != 1 || info.Misses != 1 || info.Sets != 1 || len(info.Buckets) != 5 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	entries, bytes := 0, 0
+	for _, b := range info.Buckets {
+		entries += b.Entries
+		bytes += b.Bytes
+	}
+	if entries != 1 || bytes == 0 {
+		t.Errorf("buckets should hold the key, got %d entries of %d bytes", entries, bytes)
+	}
+}
+
+func TestClientResize(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	for i := 0; i < 100; i++ {
+		checkErr(t, session.Set(fmt.Sprintf("key%d", i), i, 0))
+	}
+
+	if err = session.Resize(7); err == nil {
+		t.Error("should not resize to a number which is not a multiple of buckets")
+	}
+	checkErr(t, session.Resize(20))
+
+	for i := 0; i < 100; i++ {
+		if got, err := session.Get(fmt.Sprintf("key%d", i)); err != nil || got != i {
+			t.Errorf("[%d] key should be available while resizing, got %v, err: %v", i, got, err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		info, err := session.Info()
+		checkErr(t, err)
+
+		if len(info.Buckets) == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store should be resized, got %d buckets", len(info.Buckets))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientTransaction(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	dir, err := os.MkdirTemp("", "cachy")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := []server.Option{server.WithDir(dir), server.WithWAL(wal.SyncAlways)}
+	srv, err := server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+
+	results, err := session.Exec(func(tx *Tx) error {
+		checkErr(t, tx.Set("alice", 100, 0))
+		checkErr(t, tx.Set("bob", 0, 0))
+		checkErr(t, tx.RPush("log", "open"))
+		return tx.GetSet("bob", 10, 0)
+	})
+	if want := []interface{}{nil, nil, 1, 0}; err != nil || !reflect.DeepEqual(results, want) {
+		t.Errorf("unexpected results, got %v, want %v, err: %v", results, want, err)
+	}
+
+	_, err = session.Exec(func(tx *Tx) error {
+		checkErr(t, tx.IncrBy("alice", -50))
+		return tx.Update("missed", 1, 0)
+	})
+	if !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	if got, err := session.Get("alice"); err != nil || got != 100 {
+		t.Errorf("failed transaction should not be applied, got %v, err: %v", got, err)
+	}
+
+	// Every transaction moves 1 from alice to bob, so the total never changes.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := session.Exec(func(tx *Tx) error {
+					if err := tx.Decr("alice"); err != nil {
+						return err
+					}
+					return tx.Incr("bob")
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for j := 0; j < 10; j++ {
+		results, err := session.Exec(func(tx *Tx) error {
+			if err := tx.IncrBy("alice", 0); err != nil {
+				return err
+			}
+			return tx.IncrBy("bob", 0)
+		})
+		checkErr(t, err)
+
+		if sum := results[0].(int) + results[1].(int); sum != 110 {
+			t.Errorf("transactions should be isolated, got total %d", sum)
+		}
+	}
+	wg.Wait()
+
+	session.Close()
+	checkErr(t, srv.Stop())
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err = server.Run(server.MemoryStore, 5, ":3000", opts...)
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err = New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	alice, _ := session.Get("alice")
+	bob, _ := session.Get("bob")
+	if alice != 50 || bob != 60 {
+		t.Errorf("transactions should be replayed, got alice %v and bob %v", alice, bob)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 5)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("counter", 0, 0))
+
+	// Reads and writes are separate, only watching keeps increments from being lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := session.Watch([]string{"counter"}, func(tx *Tx) error {
+					n, err := tx.Get("counter")
+					if err != nil {
+						return err
+					}
+					return tx.Set("counter", n.(int)+1, 0)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, err := session.Get("counter"); err != nil || got != 40 {
+		t.Errorf("no increment should be lost, got %v, err: %v", got, err)
+	}
+
+	calls := 0
+	err = session.Watch([]string{"counter"}, func(tx *Tx) error {
+		calls++
+		if calls == 1 {
+			checkErr(t, session.Set("counter", 0, 0))
+		}
+
+		checkErr(t, tx.Set("counter", calls, 0))
+		if _, err := tx.Get("counter"); err != ErrReadAfterQueue {
+			t.Errorf("should be error: got: %v, want: %v", err, ErrReadAfterQueue)
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("changed key should abort the transaction once, got %d calls, err: %v", calls, err)
+	}
+
+	if got, err := session.Get("counter"); err != nil || got != 2 {
+		t.Errorf("retried transaction should be applied, got %v, err: %v", got, err)
+	}
+
+	// A missed key set and removed again is back as it was, but it is changed still.
+	calls = 0
+	err = session.Watch([]string{"fresh"}, func(tx *Tx) error {
+		calls++
+		if calls == 1 {
+			checkErr(t, session.Set("fresh", 1, 0))
+			checkErr(t, session.Remove("fresh"))
+		}
+
+		return tx.Set("fresh", calls, 0)
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("key set and removed should abort the transaction once, got %d calls, err: %v", calls, err)
+	}
+}
+
+func TestClientSubscribe(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	events, cancel, err := session.Subscribe("user:*")
+	checkErr(t, err)
+
+	start := time.Now()
+	checkErr(t, session.Set("user:1", "kermit", 0))
+	checkErr(t, session.Set("other", 1, 0))
+	checkErr(t, session.Set("user:1", "gonzo", 0))
+	checkErr(t, session.Remove("user:1"))
+
+	for i, want := range []string{"set", "update", "remove"} {
+		select {
+		case e := <-events:
+			if e.Key != "user:1" || e.Op != want || e.Time.Before(start) {
+				t.Errorf("[%d] unexpected event: got %+v, want %q of user:1", i, e, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%d] event should be received", i)
+		}
+	}
+
+	cancel()
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Errorf("channel should be closed, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("channel should be closed on cancel")
+	}
+}
+
+func TestClientPubSub(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	srv, err := server.Run(server.MemoryStore, 5, ":3000")
+	checkErr(t, err)
+	defer srv.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	news, err := session.NewSubscriber()
+	checkErr(t, err)
+	defer news.Close()
+	checkErr(t, news.Subscribe("news"))
+
+	all, err := session.NewSubscriber()
+	checkErr(t, err)
+	checkErr(t, all.PSubscribe("news.*", "sport.*"))
+
+	for i, tc := range []struct {
+		channel string
+		val     interface{}
+		want    int
+	}{
+		{channel: "news", val: "hi", want: 1},
+		{channel: "news.local", val: 1, want: 1},
+		{channel: "weather", val: nil, want: 0},
+	} {
+		if n, err := session.Publish(tc.channel, tc.val); err != nil || n != tc.want {
+			t.Errorf("[%d] should be sent to %d subscribers, got %d, err: %v", i, tc.want, n, err)
+		}
+	}
+
+	for i, tc := range []struct {
+		sub  *Subscriber
+		want Message
+	}{
+		{sub: news, want: Message{Channel: "news", Val: "hi"}},
+		{sub: all, want: Message{Channel: "news.local", Pattern: "news.*", Val: 1}},
+	} {
+		select {
+		case got := <-tc.sub.Messages():
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("[%d] unexpected message: got %+v, want %+v", i, got, tc.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%d] message should be received", i)
+		}
+	}
+
+	checkErr(t, news.Unsubscribe())
+	if n, err := session.Publish("news", "again"); err != nil || n != 0 {
+		t.Errorf("unsubscribed channel should have no subscribers, got %d, err: %v", n, err)
+	}
+
+	checkErr(t, all.Close())
+	if _, ok := <-all.Messages(); ok {
+		t.Error("messages should be closed along with the subscriber")
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing()
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		r.add(addr)
+	}
+
+	owners := make(map[string]string, 10000)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		k := fmt.Sprintf("key%d", i)
+		owners[k] = r.get(k)
+		counts[owners[k]]++
+	}
+
+	for addr, n := range counts {
+		if n < 2000 || n > 4700 {
+			t.Errorf("keys should be spread evenly, %s got %d of 10000", addr, n)
+		}
+	}
+
+	r.add("d:1")
+	moved := 0
+	for k, owner := range owners {
+		if got := r.get(k); got != owner {
+			moved++
+			if got != "d:1" {
+				t.Fatalf("keys should move only to the server added, %q moved from %s to %s", k, owner, got)
+			}
+		}
+	}
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("about a quarter of keys should move, got %d of 10000", moved)
+	}
+
+	r.remove("d:1")
+	for k, owner := range owners {
+		if got := r.get(k); got != owner {
+			t.Fatalf("keys should get back once the server is removed, %q is owned by %s, want %s", k, got, owner)
+		}
+	}
+}
+
+func TestClientSharded(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	addrs := []string{"127.0.0.1:3000", "127.0.0.1:3001", "127.0.0.1:3002"}
+	for _, addr := range addrs {
+		srv, err := server.Run(server.MemoryStore, 5, addr[len("127.0.0.1"):])
+		checkErr(t, err)
+		defer srv.Stop()
+	}
+
+	session, err := NewSharded(addrs[:2], 2)
+	checkErr(t, err)
+	defer session.Close()
+
+	keys := make([]string, 100)
+	items := make([]Item, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		items[i] = Item{Key: keys[i], Val: i}
+	}
+	checkErr(t, session.MSet(items...))
+
+	for _, addr := range addrs[:2] {
+		c, err := New(addr, 1)
+		checkErr(t, err)
+
+		got, err := c.Keys()
+		checkErr(t, err)
+		c.Close()
+
+		if len(got) == 0 || len(got) == len(keys) {
+			t.Errorf("keys should be spread over the servers, %s got %d", addr, len(got))
+		}
+		for _, k := range got {
+			if owner := session.Owner(k); owner != addr {
+				t.Errorf("%q should be stored by its owner %s, got %s", k, owner, addr)
+			}
+		}
+	}
+
+	vals, err := session.MGet(keys...)
+	checkErr(t, err)
+	for i, v := range vals {
+		if v != i {
+			t.Errorf("[%d] values should be in order of keys, got %v", i, v)
+		}
+	}
+
+	var scanned []string
+	for it := session.Iterate("", 7); it.Next(); {
+		scanned = append(scanned, it.Key())
+	}
+	sort.Strings(scanned)
+	want := append([]string(nil), keys...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(scanned, want) {
+		t.Errorf("scan should visit keys of all the servers, got %d of %d", len(scanned), len(keys))
+	}
+
+	if stats, err := session.DBStats(); err != nil || stats[0] != len(keys) {
+		t.Errorf("stats should be summed, got %v, err: %v", stats, err)
+	}
+
+	var other string
+	for _, k := range keys {
+		if session.Owner(k) != session.Owner(keys[0]) {
+			other = k
+			break
+		}
+	}
+	if err = session.Watch([]string{keys[0], other}, func(tx *Tx) error { return nil }); err != ErrCrossShard {
+		t.Errorf("should be error: got: %v, want: %v", err, ErrCrossShard)
+	}
+	err = session.Watch([]string{keys[0]}, func(tx *Tx) error {
+		if _, err := tx.Get(other); err != ErrCrossShard {
+			t.Errorf("should be error: got: %v, want: %v", err, ErrCrossShard)
+		}
+		return tx.Set(other, 0, 0)
+	})
+	if err != ErrCrossShard {
+		t.Errorf("commands of keys of another server should fail, got: %v, want: %v", err, ErrCrossShard)
+	}
+	if v, err := session.Get(other); err != nil || v == 0 {
+		t.Errorf("value of another server should be kept, got %v, err: %v", v, err)
+	}
+
+	checkErr(t, session.AddServer(addrs[2]))
+	found := 0
+	for i, k := range keys {
+		v, err := session.Get(k)
+		if err == nil && v == i {
+			found++
+			continue
+		}
+		if session.Owner(k) != addrs[2] {
+			t.Errorf("[%d] only keys owned by the server added should move, got %v, err: %v", i, v, err)
+		}
+	}
+	if found == len(keys) || found < len(keys)/2 {
+		t.Errorf("about a third of keys should move, found %d of %d", found, len(keys))
+	}
+
+	checkErr(t, session.RemoveServer(addrs[2]))
+	for i, k := range keys {
+		if v, err := session.Get(k); err != nil || v != i {
+			t.Errorf("[%d] keys should get back once the server is removed, got %v, err: %v", i, v, err)
+		}
+	}
+
+	errs, err := session.MDel(keys[0], "missed")
+	checkErr(t, err)
+	if errs[0] != nil || !reflect.DeepEqual(errs[1], store.ErrNotFound) {
+		t.Errorf("errors should be in order of keys, got %v", errs)
+	}
+}
+
+func TestClientReplication(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	primary, err := server.Run(server.MemoryStore, 5, ":3000", server.WithBacklog(1<<20), server.WithDatabases(2))
+	checkErr(t, err)
+	defer primary.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("before", 1, 0))
+	checkErr(t, session.Set("ttl", "value", time.Hour))
+
+	// The replica connects through a proxy, so its connection could be broken.
+	p := newProxy(t, "127.0.0.1:3002", "127.0.0.1:3000")
+	defer p.close()
+
+	replica, err := server.Run(server.MemoryStore, 5, ":3001", server.WithReplicaOf("127.0.0.1:3002"), server.WithDatabases(2))
+	checkErr(t, err)
+	defer replica.Stop()
+
+	rs, err := New("127.0.0.1:3001", 1)
+	checkErr(t, err)
+	defer rs.Close()
+
+	checkErr(t, session.Set("after", 2, 0))
+	checkErr(t, session.Remove("before"))
+
+	other, err := New("127.0.0.1:3000", 1, WithDB(1))
+	checkErr(t, err)
+	defer other.Close()
+	checkErr(t, other.Set("other", 3, 0))
+
+	waitSynced(t, session, rs)
+
+	if _, err = rs.Get("before"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+	if v, err := rs.Get("after"); err != nil || v != 2 {
+		t.Errorf("writes should be replicated, got %v, err: %v", v, err)
+	}
+	if ttl, err := rs.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl should be replicated, got %v, err: %v", ttl, err)
+	}
+	if keys, err := rs.Keys(); err != nil || len(keys) != 2 {
+		t.Errorf("databases should be replicated separately, got %v, err: %v", keys, err)
+	}
+
+	if err = rs.Set("key", 1, 0); !reflect.DeepEqual(err, server.ErrReadOnly) {
+		t.Errorf("should be error: got: %v, want: %v", err, server.ErrReadOnly)
+	}
+
+	info, err := rs.Info()
+	checkErr(t, err)
+	if info.Role != "replica" {
+		t.Errorf("unexpected role: %q", info.Role)
+	}
+
+	// The replica continues from its offset once it reconnects, so only the new write is applied.
+	p.breakConns()
+	checkErr(t, session.Set("reconnected", 4, 0))
+	waitSynced(t, session, rs)
+
+	got, err := rs.Info()
+	checkErr(t, err)
+	if got.Sets != info.Sets+1 {
+		t.Errorf("replica should continue without a full sync, got %d sets, want %d", got.Sets, info.Sets+1)
+	}
+	if v, err := rs.Get("reconnected"); err != nil || v != 4 {
+		t.Errorf("writes should be replicated after a reconnect, got %v, err: %v", v, err)
+	}
+}
+
+func TestClientReplicationEviction(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	primary, err := server.Run(server.MemoryStore, 1, ":3000", server.WithBacklog(1<<20),
+		server.WithMemoryStoreOptions(mstore.WithMaxEntries(2)))
+	checkErr(t, err)
+	defer primary.Stop()
+
+	session, err := New("127.0.0.1:3000", 1)
+	checkErr(t, err)
+	defer session.Close()
+
+	checkErr(t, session.Set("a", 1, 0))
+
+	replica, err := server.Run(server.MemoryStore, 1, ":3001", server.WithReplicaOf("127.0.0.1:3000"))
+	checkErr(t, err)
+	defer replica.Stop()
+
+	rs, err := New("127.0.0.1:3001", 1)
+	checkErr(t, err)
+	defer rs.Close()
+
+	// The replica is not bounded, so it removes "a" only if the primary streams its eviction.
+	checkErr(t, session.Set("b", 2, 0))
+	checkErr(t, session.Set("c", 3, 0))
+	waitSynced(t, session, rs)
+
+	if _, err = rs.Get("a"); !reflect.DeepEqual(err, store.ErrNotFound) {
+		t.Errorf("evicted key should be removed from the replica, got: %v", err)
+	}
+	if keys, err := rs.Keys(); err != nil || len(keys) != 2 {
+		t.Errorf("replica should have the keys of the primary, got %v, err: %v", keys, err)
+	}
+}
+
+func TestClientCluster(t *testing.T) {
+	skipShort(t)
+	time.Sleep(50 * time.Millisecond)
+
+	addrs := []string{"127.0.0.1:3000", "127.0.0.1:3001", "127.0.0.1:3002"}
+	servers := make([]server.Server, len(addrs))
+	sessions := make([]Client, len(addrs))
+	dirs := make([]string, len(addrs))
+
+	start := func(i int) {
+		srv, err := server.Run(server.MemoryStore, 5, addrs[i][len("127.0.0.1"):], server.WithDir(dirs[i]),
+			server.WithCluster(addrs[i], addrs...), server.WithRaftSnapshot(4))
+		checkErr(t, err)
+		servers[i] = srv
+
+		sessions[i], err = New(addrs[i], 1)
+		checkErr(t, err)
+	}
+	stop := func(i int) {
+		sessions[i].Close()
+		checkErr(t, servers[i].Stop())
+		sessions[i], servers[i] = nil, nil
+	}
+
+	for i := range addrs {
+		dir, err := os.MkdirTemp("", "cachy")
+		checkErr(t, err)
+		defer os.RemoveAll(dir)
+		dirs[i] = dir
+
+		start(i)
+	}
+	defer func() {
+		for i := range servers {
+			if servers[i] != nil {
+				stop(i)
+			}
+		}
+	}()
+
+	leader := waitLeader(t, sessions)
+	session := sessions[leader]
+
+	ok, err := session.SetNX("lock", "owner", 0)
+	if err != nil || !ok {
+		t.Fatalf("lock should be taken, got %v, err: %v", ok, err)
+	}
+	checkErr(t, session.Set("config", "v1", 0))
+	for i := 0; i < 10; i++ {
+		_, err = session.Incr("counter")
+		checkErr(t, err)
+	}
+
+	follower := (leader + 1) % len(addrs)
+	if _, err = sessions[follower].Get("config"); !reflect.DeepEqual(err, server.ErrNotLeader) {
+		t.Errorf("should be error: got: %v, want: %v", err, server.ErrNotLeader)
+	}
+	if _, err = sessions[follower].SetNX("lock", "other", 0); !reflect.DeepEqual(err, server.ErrNotLeader) {
+		t.Errorf("should be error: got: %v, want: %v", err, server.ErrNotLeader)
+	}
+
+	info, err := sessions[follower].Info()
+	checkErr(t, err)
+	if info.Role != "follower" || info.Leader != addrs[leader] {
+		t.Errorf("follower should know the leader %s, got role %q, leader %q", addrs[leader], info.Role, info.Leader)
+	}
+
+	for i := range sessions {
+		waitSynced(t, session, sessions[i])
+	}
+
+	// The leader applies its writes before their entries, still it compacts its log as the others do.
+	raftDir := filepath.Join(dirs[leader], "raft")
+	if _, err = os.Stat(filepath.Join(raftDir, "raft.snapshot")); err != nil {
+		t.Errorf("leader should take a snapshot: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(raftDir, "raft.log"))
+	checkErr(t, err)
+	if fi.Size() >= 512 {
+		t.Errorf("leader log should be compacted, got %d bytes", fi.Size())
+	}
+
+	// The others elect a new leader, which has every write committed.
+	stop(leader)
+	old := leader
+	leader = waitLeader(t, sessions)
+	session = sessions[leader]
+
+	if v, err := session.Get("config"); err != nil || v != "v1" {
+		t.Errorf("writes should survive a leader loss, got %v, err: %v", v, err)
+	}
+	if ok, err = session.SetNX("lock", "other", 0); err != nil || ok {
+		t.Errorf("lock should be held still, got %v, err: %v", ok, err)
+	}
+	if n, err := session.Incr("counter"); err != nil || n != 11 {
+		t.Errorf("unexpected counter: %d, err: %v", n, err)
+	}
+
+	// A node restarted catches up with the cluster.
+	start(old)
+	waitSynced(t, session, sessions[old])
+
+	info, err = sessions[old].Info()
+	checkErr(t, err)
+	if info.Role != "follower" || info.Leader != addrs[leader] {
+		t.Errorf("restarted node should follow %s, got role %q, leader %q", addrs[leader], info.Role, info.Leader)
+	}
+}
+
+// waitSynced waits until a replica applies all the writes of its primary.
+func waitSynced(t *testing.T, primary, replica Client) {
+	t.Helper()
+
+	for i := 0; i < 200; i++ {
+		p, err := primary.Info()
+		checkErr(t, err)
+		r, err := replica.Info()
+		checkErr(t, err)
+
+		if p.Offset > 0 && p.Offset == r.Offset {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("replica should catch up with its primary")
+}
+
+// waitLeader waits until a cluster of nodes elects a leader and returns its index, stopped nodes are nil.
+func waitLeader(t *testing.T, nodes []Client) int {
+	t.Helper()
+
+	for i := 0; i < 500; i++ {
+		for j, c := range nodes {
+			if c == nil {
+				continue
+			}
+
+			info, err := c.Info()
+			checkErr(t, err)
+			if info.Role == "leader" {
+				return j
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("cluster should elect a leader")
+	return 0
+}
+
+// proxy forwards connections to an address, so they could be broken on purpose.
+type proxy struct {
+	l     net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newProxy(t *testing.T, addr, to string) *proxy {
+	l, err := net.Listen("tcp", addr)
+	checkErr(t, err)
+
+	p := &proxy{l: l}
+	go func() {
+		for {
+			in, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			out, err := net.Dial("tcp", to)
+			if err != nil {
+				in.Close()
+				continue
+			}
+
+			p.mu.Lock()
+			p.conns = append(p.conns, in, out)
+			p.mu.Unlock()
+
+			go func() {
+				io.Copy(out, in)
+				out.Close()
+			}()
+			go func() {
+				io.Copy(in, out)
+				in.Close()
+			}()
+		}
+	}()
+
+	return p
+}
+
+func (p *proxy) breakConns() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func (p *proxy) close() {
+	p.l.Close()
+	p.breakConns()
+}
+
 func checkErr(t *testing.T, err error) {
 	t.Helper()
 
@@ -0,0 +1,48 @@
+package client
+
+// KeyIterator iterates keys using SCAN requests.
+// Keys existent during the whole iteration are returned at least once, but some might be repeated.
+//
+//	it := session.Iterate("user:*", 100)
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type KeyIterator struct {
+	c       Client
+	pattern string
+	count   int
+
+	cursor  uint64
+	started bool
+	keys    []string
+	key     string
+	err     error
+}
+
+// Next advances the iterator to the next key, it returns false once there are no more keys or on error.
+func (it *KeyIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.err != nil || (it.started && it.cursor == 0) {
+			return false
+		}
+
+		it.started = true
+		it.keys, it.cursor, it.err = it.c.Scan(it.cursor, it.pattern, it.count)
+	}
+
+	it.key, it.keys = it.keys[0], it.keys[1:]
+	return true
+}
+
+// Key returns the current key.
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+// Err returns the first error happened during iteration.
+func (it *KeyIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// Event is a change of a key, see Subscribe.
+type Event struct {
+	Key string
+	// Op is one of: "set", "update", "remove", "expire" or "evict".
+	Op   string
+	Time time.Time
+}
+
+// Subscribe streams changes of keys matching any of glob patterns, or all the keys if none given,
+// over a dedicated connection. The channel is closed once cancel is called or the connection is lost,
+// the server drops subscribers which fall too far behind.
+func (c *client) Subscribe(patterns ...string) (events <-chan Event, cancel func(), err error) {
+	conn, err := c.makeConn(c.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = conn.Write(proto.NewKeysMessage(proto.CmdKSubscribe, patterns)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// Events could come right after the response, so the same reader is used for all of them.
+	r := bufio.NewReader(conn)
+	if _, err = c.readResponse(r); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan Event, 64)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+		})
+	}
+
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		for {
+			val, err := c.readResponse(r)
+			if err != nil {
+				return
+			}
+
+			e, err := toEvent(val)
+			if err != nil {
+				return
+			}
+
+			select {
+			case ch <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return ch, cancel, nil
+}
+
+// readResponse decodes the next response read from r, which is returned as an error if it is one.
+func (c *client) readResponse(r *bufio.Reader) (val interface{}, err error) {
+	if val, err = c.readValue(r); err != nil {
+		return nil, err
+	}
+
+	err, _ = val.(error)
+	return
+}
+
+// readValue decodes the next message read from r as it is.
+func (c *client) readValue(r *bufio.Reader) (interface{}, error) {
+	b, err := r.ReadBytes(proto.CR)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decoder.Decode(proto.NewScanner(bytes.NewReader(b)))
+}
+
+func toEvent(response interface{}) (Event, error) {
+	s, ok := response.([]interface{})
+	if ok && len(s) == 3 {
+		key, kok := s[0].(string)
+		op, ook := s[1].(string)
+		ns, nok := s[2].(int)
+		if kok && ook && nok {
+			return Event{Key: key, Op: op, Time: time.Unix(0, int64(ns))}, nil
+		}
+	}
+
+	log.Err("event should be a slice of key, operation and time, got %T - % q", response, response)
+	return Event{}, proto.ErrUnknown
+}
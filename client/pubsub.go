@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/aliaksandrb/cachy/proto"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// Message is a value published to a channel, see Subscriber.
+type Message struct {
+	Channel string
+	// Pattern is a pattern the channel matched for subscriptions made with PSubscribe, empty otherwise.
+	Pattern string
+	Val     interface{}
+}
+
+// Publish sends a value to subscribers of a channel and returns how many of them it is sent to.
+func (c *client) Publish(channel string, val interface{}) (int, error) {
+	msg, err := proto.NewPublishMessage(channel, val)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.processIntMessage(msg)
+	return int(n), err
+}
+
+// Subscriber receives messages of channels over a connection of its own, which is not
+// used for anything else. Messages should be read all the time, otherwise commands of the
+// subscriber block and the server drops it once it falls too far behind.
+type Subscriber struct {
+	c    *client
+	conn net.Conn
+	msgs chan Message
+	// responses are responses to commands, there is only one command at a time.
+	responses chan interface{}
+	mu        sync.Mutex
+	// closed is closed once the connection is lost or closed.
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewSubscriber returns a subscriber which is not subscribed to anything yet.
+func (c *client) NewSubscriber() (*Subscriber, error) {
+	conn, err := c.makeConn(c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscriber{
+		c:         c,
+		conn:      conn,
+		msgs:      make(chan Message, 64),
+		responses: make(chan interface{}, 1),
+		closed:    make(chan struct{}),
+	}
+	go s.read(bufio.NewReader(conn))
+
+	return s, nil
+}
+
+// Messages returns a channel of messages, it is closed once the subscriber is closed or its connection is lost.
+func (s *Subscriber) Messages() <-chan Message {
+	return s.msgs
+}
+
+// Subscribe subscribes to channels by their names.
+func (s *Subscriber) Subscribe(channels ...string) error {
+	return s.send(proto.NewKeysMessage(proto.CmdSubscribe, channels))
+}
+
+// PSubscribe subscribes to channels matching any of glob patterns.
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	return s.send(proto.NewKeysMessage(proto.CmdPSubscribe, patterns))
+}
+
+// Unsubscribe unsubscribes from channels subscribed by their names, all of them if none given.
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	return s.send(proto.NewKeysMessage(proto.CmdUnsubscribe, channels))
+}
+
+// PUnsubscribe unsubscribes from patterns, all of them if none given.
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	return s.send(proto.NewKeysMessage(proto.CmdPUnsubscribe, patterns))
+}
+
+// Close closes the connection of the subscriber.
+func (s *Subscriber) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+
+	return err
+}
+
+// send sends a command msg and waits for its response, while messages keep coming.
+func (s *Subscriber) send(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.conn.Write(msg); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-s.responses:
+		err, _ := response.(error)
+		return err
+	case <-s.closed:
+		return ErrTerminated
+	}
+}
+
+// read passes messages to Messages and responses to commands waiting for them
+// until the connection is closed.
+func (s *Subscriber) read(r *bufio.Reader) {
+	defer close(s.msgs)
+	defer s.Close()
+
+	for {
+		val, err := s.c.readValue(r)
+		if err != nil {
+			return
+		}
+
+		if push, ok := val.(*proto.Push); ok {
+			select {
+			case s.msgs <- Message{Channel: push.Channel, Pattern: push.Pattern, Val: push.Val}:
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+
+		select {
+		case s.responses <- val:
+		default:
+			log.Err("unexpected response of a subscriber: %T - % q", val, val)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// virtualNodes is the number of points every server owns on a ring.
+const virtualNodes = 160
+
+// ring places keys on servers with consistent hashing. A key belongs to the server owning
+// the first point of the ring following its hash. Every server owns many points spread over
+// the ring, so adding or removing one moves only keys of its points, evenly from or to the others.
+type ring struct {
+	// points are sorted hashes of virtual nodes.
+	points []uint64
+	owners map[uint64]string
+	// nodes are sorted addresses of servers.
+	nodes []string
+}
+
+func newRing() *ring {
+	return &ring{owners: make(map[uint64]string)}
+}
+
+func (r *ring) add(addr string) {
+	for i := 0; i < virtualNodes; i++ {
+		p := pointHash(addr, i)
+		// Colliding points are rare enough to keep the first owner.
+		if _, ok := r.owners[p]; ok {
+			continue
+		}
+
+		r.owners[p] = addr
+		r.points = append(r.points, p)
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	r.nodes = append(r.nodes, addr)
+	sort.Strings(r.nodes)
+}
+
+func (r *ring) remove(addr string) {
+	points := r.points[:0]
+	for _, p := range r.points {
+		if r.owners[p] != addr {
+			points = append(points, p)
+			continue
+		}
+		delete(r.owners, p)
+	}
+	r.points = points
+
+	for i, n := range r.nodes {
+		if n == addr {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			break
+		}
+	}
+}
+
+// get returns an address of a server owning a key, the ring should not be empty.
+func (r *ring) get(key string) string {
+	h := murmur3.Sum64([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+func pointHash(addr string, i int) uint64 {
+	return murmur3.Sum64([]byte(addr + "#" + strconv.Itoa(i)))
+}
@@ -0,0 +1,681 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// nodeBits is the number of high bits of a Scan cursor of Sharded taken by an index of a server.
+const nodeBits = 8
+
+var (
+	// ErrNoServers returned when Sharded is left without servers.
+	ErrNoServers = errors.New("at least one server is required")
+	// ErrCrossShard returned when keys of a transaction are owned by different servers.
+	ErrCrossShard = errors.New("keys are owned by different servers")
+	// ErrShardedExec returned by Sharded.Exec, the servers owning keys of a transaction are unknown.
+	ErrShardedExec = errors.New("transaction keys are unknown, use Watch with them")
+)
+
+// Sharded is a client spreading keys over many servers, each key is owned by one of them picked
+// with consistent hashing. Servers could be added and removed while the client is used, only keys
+// owned by the server added or removed change their owners, about 1/n of all of them. Values are
+// not moved, so the keys moved are missed until they are set again, as any cache would have them.
+//
+// Commands of a single key go to the server owning it, the multi-key ones are split by servers
+// and the ones of the whole database are sent to all of them with their results merged.
+type Sharded struct {
+	connPoolSize int
+	opts         []Option
+
+	mu      sync.RWMutex
+	ring    *ring
+	clients map[string]*client
+}
+
+var _ Client = (*Sharded)(nil)
+
+// NewSharded returns a client of servers at addrs, every one of them gets a pool of connPoolSize connections.
+func NewSharded(addrs []string, connPoolSize int, opts ...Option) (*Sharded, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoServers
+	}
+
+	s := &Sharded{
+		connPoolSize: connPoolSize,
+		opts:         opts,
+		ring:         newRing(),
+		clients:      make(map[string]*client, len(addrs)),
+	}
+
+	for _, addr := range addrs {
+		if err := s.AddServer(addr); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// AddServer connects a server at addr and makes it owner of its share of keys.
+func (s *Sharded) AddServer(addr string) error {
+	s.mu.RLock()
+	_, ok := s.clients[addr]
+	s.mu.RUnlock()
+	if ok {
+		return fmt.Errorf("server %s is added already", addr)
+	}
+
+	c, err := New(addr, s.connPoolSize, s.opts...)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if _, ok = s.clients[addr]; ok {
+		s.mu.Unlock()
+		c.Close()
+		return fmt.Errorf("server %s is added already", addr)
+	}
+	s.clients[addr] = c.(*client)
+	s.ring.add(addr)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RemoveServer disconnects a server at addr, its keys are owned by the others since.
+func (s *Sharded) RemoveServer(addr string) error {
+	s.mu.Lock()
+	c, ok := s.clients[addr]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown server %s", addr)
+	}
+	if len(s.clients) == 1 {
+		s.mu.Unlock()
+		return ErrNoServers
+	}
+
+	delete(s.clients, addr)
+	s.ring.remove(addr)
+	s.mu.Unlock()
+
+	// Commands running already get their connections back before the pool is closed.
+	c.Close()
+	return nil
+}
+
+// Servers returns addresses of the servers in order Scan visits them.
+func (s *Sharded) Servers() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.ring.nodes...)
+}
+
+// Owner returns an address of the server owning a key.
+func (s *Sharded) Owner(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ring.get(key)
+}
+
+// node returns a client of the server owning a key.
+func (s *Sharded) node(key string) *client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.clients[s.ring.get(key)]
+}
+
+// nodes returns clients of all the servers sorted by their addresses.
+func (s *Sharded) nodes() []*client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make([]*client, len(s.ring.nodes))
+	for i, addr := range s.ring.nodes {
+		nodes[i] = s.clients[addr]
+	}
+
+	return nodes
+}
+
+// group splits indexes of keys by clients of the servers owning them.
+func (s *Sharded) group(n int, key func(i int) string) map[*client][]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make(map[*client][]int)
+	for i := 0; i < n; i++ {
+		c := s.clients[s.ring.get(key(i))]
+		groups[c] = append(groups[c], i)
+	}
+
+	return groups
+}
+
+// each calls fn for clients of all the servers concurrently and returns the first error if any.
+func (s *Sharded) each(fn func(c *client) error) error {
+	nodes := s.nodes()
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, c := range nodes {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			errs[i] = fn(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get implements Client.
+func (s *Sharded) Get(key string) (val interface{}, err error) {
+	return s.node(key).Get(key)
+}
+
+// GetWithVersion implements Client.
+func (s *Sharded) GetWithVersion(key string) (val interface{}, version uint64, err error) {
+	return s.node(key).GetWithVersion(key)
+}
+
+// Set implements Client.
+func (s *Sharded) Set(key string, val interface{}, ttl time.Duration) error {
+	return s.node(key).Set(key, val, ttl)
+}
+
+// SetNX implements Client.
+func (s *Sharded) SetNX(key string, val interface{}, ttl time.Duration) (bool, error) {
+	return s.node(key).SetNX(key, val, ttl)
+}
+
+// SetSliding implements Client.
+func (s *Sharded) SetSliding(key string, val interface{}, ttl time.Duration) error {
+	return s.node(key).SetSliding(key, val, ttl)
+}
+
+// GetSet implements Client.
+func (s *Sharded) GetSet(key string, val interface{}, ttl time.Duration) (old interface{}, err error) {
+	return s.node(key).GetSet(key, val, ttl)
+}
+
+// Update implements Client.
+func (s *Sharded) Update(key string, val interface{}, ttl time.Duration) error {
+	return s.node(key).Update(key, val, ttl)
+}
+
+// Remove implements Client.
+func (s *Sharded) Remove(key string) error {
+	return s.node(key).Remove(key)
+}
+
+// CompareAndSwap implements Client.
+func (s *Sharded) CompareAndSwap(key string, val interface{}, version uint64, ttl time.Duration) error {
+	return s.node(key).CompareAndSwap(key, val, version, ttl)
+}
+
+// MGet implements Client, every server gets a single round trip with its own keys.
+func (s *Sharded) MGet(keys ...string) ([]interface{}, error) {
+	vals := make([]interface{}, len(keys))
+	err := s.split(len(keys), func(i int) string { return keys[i] }, func(c *client, idx []int) error {
+		sub := make([]string, len(idx))
+		for j, i := range idx {
+			sub[j] = keys[i]
+		}
+
+		res, err := c.MGet(sub...)
+		if err != nil {
+			return err
+		}
+
+		for j, i := range idx {
+			vals[i] = res[j]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vals, nil
+}
+
+// MSet implements Client, every server gets a single round trip with its own items.
+// An error of any item failed is returned, the others are set anyway.
+func (s *Sharded) MSet(items ...Item) error {
+	return s.split(len(items), func(i int) string { return items[i].Key }, func(c *client, idx []int) error {
+		sub := make([]Item, len(idx))
+		for j, i := range idx {
+			sub[j] = items[i]
+		}
+
+		return c.MSet(sub...)
+	})
+}
+
+// MDel implements Client, every server gets a single round trip with its own keys.
+func (s *Sharded) MDel(keys ...string) ([]error, error) {
+	errs := make([]error, len(keys))
+	err := s.split(len(keys), func(i int) string { return keys[i] }, func(c *client, idx []int) error {
+		sub := make([]string, len(idx))
+		for j, i := range idx {
+			sub[j] = keys[i]
+		}
+
+		res, err := c.MDel(sub...)
+		if err != nil {
+			return err
+		}
+
+		for j, i := range idx {
+			errs[i] = res[j]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// split groups n keys by servers owning them and calls fn for every group concurrently
+// with indexes of its keys, the first error is returned if any.
+func (s *Sharded) split(n int, key func(i int) string, fn func(c *client, idx []int) error) error {
+	groups := s.group(n, key)
+	errs := make(chan error, len(groups))
+
+	for c, idx := range groups {
+		go func(c *client, idx []int) {
+			errs <- fn(c, idx)
+		}(c, idx)
+	}
+
+	var err error
+	for range groups {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// TTL implements Client.
+func (s *Sharded) TTL(key string) (time.Duration, error) {
+	return s.node(key).TTL(key)
+}
+
+// Expire implements Client.
+func (s *Sharded) Expire(key string, ttl time.Duration) error {
+	return s.node(key).Expire(key, ttl)
+}
+
+// ExpireAt implements Client.
+func (s *Sharded) ExpireAt(key string, at time.Time) error {
+	return s.node(key).ExpireAt(key, at)
+}
+
+// Persist implements Client.
+func (s *Sharded) Persist(key string) error {
+	return s.node(key).Persist(key)
+}
+
+// Touch implements Client.
+func (s *Sharded) Touch(key string) error {
+	return s.node(key).Touch(key)
+}
+
+// Sliding implements Client.
+func (s *Sharded) Sliding(key string) (time.Duration, error) {
+	return s.node(key).Sliding(key)
+}
+
+// Keys implements Client, keys of all the servers are returned.
+func (s *Sharded) Keys() ([]string, error) {
+	return s.KeysMatching("")
+}
+
+// KeysMatching implements Client, keys of all the servers are returned.
+func (s *Sharded) KeysMatching(pattern string) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+
+	err := s.each(func(c *client) error {
+		k, err := c.KeysMatching(pattern)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		keys = append(keys, k...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Scan implements Client, the servers are visited one after another in order of Servers.
+// The high bits of a cursor keep an index of the server, the rest is a cursor of its own.
+// Keys could be missed or returned twice if servers are added or removed meanwhile.
+func (s *Sharded) Scan(cursor uint64, pattern string, count int) (keys []string, next uint64, err error) {
+	nodes := s.nodes()
+	i := int(cursor >> (64 - nodeBits))
+	if i >= len(nodes) {
+		return nil, 0, nil
+	}
+
+	keys, next, err = nodes[i].Scan(cursor&(1<<(64-nodeBits)-1), pattern, count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if next == 0 {
+		if i++; i == len(nodes) {
+			return keys, 0, nil
+		}
+	}
+
+	return keys, uint64(i)<<(64-nodeBits) | next, nil
+}
+
+// Iterate implements Client.
+func (s *Sharded) Iterate(pattern string, count int) *KeyIterator {
+	return &KeyIterator{c: s, pattern: pattern, count: count}
+}
+
+// Incr implements Client.
+func (s *Sharded) Incr(key string) (int64, error) {
+	return s.node(key).Incr(key)
+}
+
+// Decr implements Client.
+func (s *Sharded) Decr(key string) (int64, error) {
+	return s.node(key).Decr(key)
+}
+
+// IncrBy implements Client.
+func (s *Sharded) IncrBy(key string, delta int64) (int64, error) {
+	return s.node(key).IncrBy(key, delta)
+}
+
+// LPush implements Client.
+func (s *Sharded) LPush(key string, vals ...interface{}) (int, error) {
+	return s.node(key).LPush(key, vals...)
+}
+
+// RPush implements Client.
+func (s *Sharded) RPush(key string, vals ...interface{}) (int, error) {
+	return s.node(key).RPush(key, vals...)
+}
+
+// LPop implements Client.
+func (s *Sharded) LPop(key string) (interface{}, error) {
+	return s.node(key).LPop(key)
+}
+
+// RPop implements Client.
+func (s *Sharded) RPop(key string) (interface{}, error) {
+	return s.node(key).RPop(key)
+}
+
+// LRange implements Client.
+func (s *Sharded) LRange(key string, start, stop int) ([]interface{}, error) {
+	return s.node(key).LRange(key, start, stop)
+}
+
+// LTrim implements Client.
+func (s *Sharded) LTrim(key string, start, stop int) error {
+	return s.node(key).LTrim(key, start, stop)
+}
+
+// LLen implements Client.
+func (s *Sharded) LLen(key string) (int, error) {
+	return s.node(key).LLen(key)
+}
+
+// HGet implements Client.
+func (s *Sharded) HGet(key string, field interface{}) (interface{}, error) {
+	return s.node(key).HGet(key, field)
+}
+
+// HSet implements Client.
+func (s *Sharded) HSet(key string, field, val interface{}) (bool, error) {
+	return s.node(key).HSet(key, field, val)
+}
+
+// HDel implements Client.
+func (s *Sharded) HDel(key string, fields ...interface{}) (int, error) {
+	return s.node(key).HDel(key, fields...)
+}
+
+// HKeys implements Client.
+func (s *Sharded) HKeys(key string) ([]interface{}, error) {
+	return s.node(key).HKeys(key)
+}
+
+// HLen implements Client.
+func (s *Sharded) HLen(key string) (int, error) {
+	return s.node(key).HLen(key)
+}
+
+// HIncrBy implements Client.
+func (s *Sharded) HIncrBy(key string, field interface{}, delta int64) (int64, error) {
+	return s.node(key).HIncrBy(key, field, delta)
+}
+
+// Exec implements Client, it always returns ErrShardedExec as keys of fn are not known
+// before it runs, Watch should be used instead.
+func (s *Sharded) Exec(fn func(tx *Tx) error) ([]interface{}, error) {
+	return nil, ErrShardedExec
+}
+
+// Watch implements Client, all the keys should be owned by the same server, otherwise
+// ErrCrossShard is returned. Commands of fn are sent to that server, so the ones of keys
+// owned by other servers fail with ErrCrossShard as well.
+func (s *Sharded) Watch(keys []string, fn func(tx *Tx) error) error {
+	if len(keys) == 0 {
+		return ErrShardedExec
+	}
+
+	c := s.node(keys[0])
+	for _, k := range keys[1:] {
+		if s.node(k) != c {
+			return ErrCrossShard
+		}
+	}
+
+	return c.Watch(keys, func(tx *Tx) error {
+		tx.owns = func(key string) bool { return s.node(key) == c }
+		return fn(tx)
+	})
+}
+
+// Subscribe implements Client, changes of keys of all the servers are merged in one channel,
+// which is closed once cancel is called or connections to all of them are lost.
+// Servers added later are not subscribed to.
+func (s *Sharded) Subscribe(patterns ...string) (events <-chan Event, cancel func(), err error) {
+	nodes := s.nodes()
+	chs := make([]<-chan Event, 0, len(nodes))
+	cancels := make([]func(), 0, len(nodes))
+	done := make(chan struct{})
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			close(done)
+			for _, c := range cancels {
+				c()
+			}
+		})
+	}
+
+	for _, c := range nodes {
+		ch, cc, err := c.Subscribe(patterns...)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+
+		chs = append(chs, ch)
+		cancels = append(cancels, cc)
+	}
+
+	out := make(chan Event, 64)
+	var wg sync.WaitGroup
+	for _, ch := range chs {
+		wg.Add(1)
+		go func(ch <-chan Event) {
+			defer wg.Done()
+			for e := range ch {
+				select {
+				case out <- e:
+				case <-done:
+					return
+				}
+			}
+		}(ch)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, cancel, nil
+}
+
+// Publish implements Client, the value is published on every server, as subscribers
+// could be connected to any of them, and the numbers of receivers are summed.
+func (s *Sharded) Publish(channel string, val interface{}) (int, error) {
+	var (
+		mu sync.Mutex
+		n  int
+	)
+
+	err := s.each(func(c *client) error {
+		r, err := c.Publish(channel, val)
+		mu.Lock()
+		n += r
+		mu.Unlock()
+		return err
+	})
+
+	return n, err
+}
+
+// NewSubscriber implements Client, the subscriber is connected to the first of Servers,
+// it gets all the messages as Publish sends them to every server.
+func (s *Sharded) NewSubscriber() (*Subscriber, error) {
+	return s.nodes()[0].NewSubscriber()
+}
+
+// FlushDB implements Client, the database is flushed on all the servers.
+func (s *Sharded) FlushDB() error {
+	return s.each((*client).FlushDB)
+}
+
+// DBStats implements Client, the numbers of keys are summed over all the servers.
+func (s *Sharded) DBStats() (map[int]int, error) {
+	var mu sync.Mutex
+	stats := make(map[int]int)
+
+	err := s.each(func(c *client) error {
+		st, err := c.DBStats()
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		for db, n := range st {
+			stats[db] += n
+		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Info implements Client, counters are summed over all the servers
+// and buckets of all of them are listed in order of Servers, replication and cluster fields are left empty.
+func (s *Sharded) Info() (*Info, error) {
+	nodes := s.nodes()
+	infos := make([]*Info, len(nodes))
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(nodes))
+	for i, c := range nodes {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			infos[i], errs[i] = c.Info()
+		}(i, c)
+	}
+	wg.Wait()
+
+	info := &Info{}
+	for i, in := range infos {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		info.Hits += in.Hits
+		info.Misses += in.Misses
+		info.Sets += in.Sets
+		info.Evictions += in.Evictions
+		info.Expired += in.Expired
+		info.LazyExpired += in.LazyExpired
+		info.Buckets = append(info.Buckets, in.Buckets...)
+	}
+
+	return info, nil
+}
+
+// Resize implements Client, every server resizes its own store to n buckets.
+func (s *Sharded) Resize(n int) error {
+	return s.each(func(c *client) error {
+		return c.Resize(n)
+	})
+}
+
+// Snapshot implements Client, every server saves its own snapshots.
+func (s *Sharded) Snapshot() error {
+	return s.each((*client).Snapshot)
+}
+
+// Close implements Client, connections to all the servers are closed.
+func (s *Sharded) Close() {
+	s.mu.Lock()
+	clients := s.clients
+	s.clients = make(map[string]*client)
+	s.ring = newRing()
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.Close()
+	}
+}
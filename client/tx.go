@@ -0,0 +1,373 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// watchRetries limits how many times Watch runs a transaction which keeps being aborted.
+const watchRetries = 100
+
+// ErrReadAfterQueue returned when a transaction reads a value after it queued a command.
+var ErrReadAfterQueue = errors.New("transaction could not read after commands are queued")
+
+// Tx queues commands of a transaction on a single connection, see Client.Exec and Client.Watch.
+// Results of the commands are returned by Exec once they are applied.
+type Tx struct {
+	c    *client
+	conn net.Conn
+	// multi is set once the transaction is started on the server, it happens with the first command.
+	multi bool
+	// watching is set if the connection watches keys.
+	watching bool
+	// owns reports if a key is owned by the server of the transaction, it is set by Sharded.Watch.
+	owns func(key string) bool
+}
+
+// Get returns a value of a key right away, it is allowed only before any command is queued.
+func (tx *Tx) Get(key string) (interface{}, error) {
+	if err := tx.own(key); err != nil {
+		return nil, err
+	}
+
+	if tx.multi {
+		return nil, ErrReadAfterQueue
+	}
+
+	msg, err := proto.NewMessage(proto.CmdGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.c.roundTrip(tx.conn, msg)
+}
+
+// Set queues setting a value for a key.
+func (tx *Tx) Set(key string, val interface{}, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdSet, key, val, ttl))
+}
+
+// SetNX queues setting a value only if the key is missed, the result is 1 if it was set.
+func (tx *Tx) SetNX(key string, val interface{}, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdSetNX, key, val, ttl))
+}
+
+// SetSliding queues setting a value with ttl renewed by reads.
+func (tx *Tx) SetSliding(key string, val interface{}, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdSetSliding, key, val, ttl))
+}
+
+// GetSet queues setting a value, the result is the previous one.
+func (tx *Tx) GetSet(key string, val interface{}, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdGetSet, key, val, ttl))
+}
+
+// Update queues updating a value of an existing key.
+func (tx *Tx) Update(key string, val interface{}, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdUpdate, key, val, ttl))
+}
+
+// Remove queues removing a key.
+func (tx *Tx) Remove(key string) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdRemove, key, nil, 0))
+}
+
+// CompareAndSwap queues setting a value only if its version still matches.
+func (tx *Tx) CompareAndSwap(key string, val interface{}, version uint64, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewCompareAndSwapMessage(key, val, version, ttl))
+}
+
+// Expire queues setting a key to expire in ttl, a ttl which is not positive removes the key.
+func (tx *Tx) Expire(key string, ttl time.Duration) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return tx.queue(proto.NewExpireMessage(key, ttl), nil)
+}
+
+// ExpireAt queues setting a key to expire at a time.
+func (tx *Tx) ExpireAt(key string, at time.Time) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewExpireAtMessage(key, at), nil)
+}
+
+// Persist queues removing ttl of a key.
+func (tx *Tx) Persist(key string) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdPersist, key, nil, 0))
+}
+
+// Incr queues incrementing an integer value, the result is the new value.
+func (tx *Tx) Incr(key string) error {
+	return tx.IncrBy(key, 1)
+}
+
+// Decr queues decrementing an integer value, the result is the new value.
+func (tx *Tx) Decr(key string) error {
+	return tx.IncrBy(key, -1)
+}
+
+// IncrBy queues adding delta to an integer value, the result is the new value.
+func (tx *Tx) IncrBy(key string, delta int64) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewIncrByMessage(key, delta), nil)
+}
+
+// LPush queues adding values to the head of a list, the result is its new length.
+func (tx *Tx) LPush(key string, vals ...interface{}) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewPushMessage(proto.CmdLPush, key, vals))
+}
+
+// RPush queues adding values to the tail of a list, the result is its new length.
+func (tx *Tx) RPush(key string, vals ...interface{}) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewPushMessage(proto.CmdRPush, key, vals))
+}
+
+// LPop queues removing the first element of a list, the result is the element.
+func (tx *Tx) LPop(key string) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdLPop, key, nil, 0))
+}
+
+// RPop queues removing the last element of a list, the result is the element.
+func (tx *Tx) RPop(key string) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewMessage(proto.CmdRPop, key, nil, 0))
+}
+
+// LTrim queues keeping only elements of a list between start and stop inclusive.
+func (tx *Tx) LTrim(key string, start, stop int) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewRangeMessage(proto.CmdLTrim, key, start, stop), nil)
+}
+
+// HSet queues setting a value of a map field, the result is 1 if the field is new.
+func (tx *Tx) HSet(key string, field, val interface{}) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewFieldMessage(proto.CmdHSet, key, field, val))
+}
+
+// HDel queues removing map fields, the result is the number of ones removed.
+func (tx *Tx) HDel(key string, fields ...interface{}) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewPushMessage(proto.CmdHDel, key, fields))
+}
+
+// HIncrBy queues adding delta to an integer value of a map field, the result is the new value.
+func (tx *Tx) HIncrBy(key string, field interface{}, delta int64) error {
+	if err := tx.own(key); err != nil {
+		return err
+	}
+
+	return tx.queue(proto.NewFieldMessage(proto.CmdHIncrBy, key, field, delta))
+}
+
+// own returns ErrCrossShard if a key is owned by another server than the one of the transaction.
+func (tx *Tx) own(key string) error {
+	if tx.owns != nil && !tx.owns(key) {
+		return ErrCrossShard
+	}
+
+	return nil
+}
+
+// queue sends a command msg to be queued, the transaction is started first if it is not yet.
+func (tx *Tx) queue(msg []byte, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if !tx.multi {
+		if err = tx.send(proto.CmdMulti); err != nil {
+			return err
+		}
+		tx.multi = true
+	}
+
+	response, err := tx.c.roundTrip(tx.conn, msg)
+	if err != nil {
+		return err
+	}
+
+	if response != "QUEUED" {
+		log.Err("command should be queued, got %T - % q", response, response)
+		return proto.ErrUnknown
+	}
+
+	return nil
+}
+
+// send sends a command without arguments expecting nothing back but errors.
+func (tx *Tx) send(cmd byte) error {
+	msg, err := proto.NewMessage(cmd, "", nil, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.c.roundTrip(tx.conn, msg)
+	return err
+}
+
+// exec applies the queued commands and returns their results.
+func (tx *Tx) exec() ([]interface{}, error) {
+	if !tx.multi {
+		return nil, tx.unwatch()
+	}
+
+	msg, err := proto.NewMessage(proto.CmdExec, "", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := tx.c.roundTrip(tx.conn, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	results, ok := response.([]interface{})
+	if !ok {
+		log.Err("exec should return slice, got %T - % q", response, response)
+		return nil, proto.ErrUnknown
+	}
+
+	return results, nil
+}
+
+// discard drops the queued commands.
+func (tx *Tx) discard() error {
+	if !tx.multi {
+		return tx.unwatch()
+	}
+
+	return tx.send(proto.CmdDiscard)
+}
+
+// unwatch forgets watched keys, EXEC and DISCARD do the same on their own.
+func (tx *Tx) unwatch() error {
+	if !tx.watching {
+		return nil
+	}
+
+	return tx.send(proto.CmdUnwatch)
+}
+
+// Exec queues commands of a transaction with fn and applies them atomically on the server,
+// returning their results in order. Nothing is applied if fn or any of the commands fails.
+func (c *client) Exec(fn func(tx *Tx) error) ([]interface{}, error) {
+	conn, err := c.acquireConn()
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseConn(conn)
+
+	tx := &Tx{c: c, conn: conn}
+	if err = fn(tx); err != nil {
+		if derr := tx.discard(); derr != nil {
+			log.Err("unable to discard a transaction: %v", derr)
+		}
+		return nil, err
+	}
+
+	return tx.exec()
+}
+
+// Watch runs a transaction fn which is applied only if none of keys is changed since fn is called,
+// otherwise fn is called again until it succeeds, proto.ErrTxAborted is returned if it never does.
+// Values of the keys read by fn before it queues commands are the ones checked.
+func (c *client) Watch(keys []string, fn func(tx *Tx) error) error {
+	conn, err := c.acquireConn()
+	if err != nil {
+		return err
+	}
+	defer c.releaseConn(conn)
+
+	for i := 0; i < watchRetries; i++ {
+		if _, err = c.roundTrip(conn, proto.NewKeysMessage(proto.CmdWatch, keys)); err != nil {
+			return err
+		}
+
+		tx := &Tx{c: c, conn: conn, watching: true}
+		if err = fn(tx); err != nil {
+			if derr := tx.discard(); derr != nil {
+				log.Err("unable to discard a transaction: %v", derr)
+			}
+			return err
+		}
+
+		if _, err = tx.exec(); err != proto.ErrTxAborted {
+			return err
+		}
+	}
+
+	return proto.ErrTxAborted
+}
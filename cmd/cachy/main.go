@@ -2,16 +2,69 @@ package main
 
 import (
 	"flag"
+	"strings"
 
 	"github.com/aliaksandrb/cachy/server"
+	"github.com/aliaksandrb/cachy/store/mstore"
+	"github.com/aliaksandrb/cachy/wal"
 )
 
 func main() {
 	bSize := flag.Int("bsize", 32, "how many buckets to use, default: 32")
 	port := flag.String("port", "3000", "port number to run, default: 3000")
+	storeName := flag.String("store", "memory", "store type to use: memory or disk, default: memory")
+	dir := flag.String("dir", ".", "directory to keep data in for a disk store, default: .")
+	databases := flag.Int("databases", 1, "number of databases selectable by clients, default: 1")
+	maxMemory := flag.Int64("maxmemory", 0, "max size of keys and values in bytes for a memory store, 0 means unlimited, default: 0")
+	maxKeys := flag.Int("maxkeys", 0, "max number of keys for a memory store, 0 means unlimited, default: 0")
+	loadFactor := flag.Float64("loadfactor", 0, "average number of keys per bucket to double buckets of a memory store after, 0 means never, default: 0")
+	eviction := flag.String("eviction", "lru", "eviction policy for a bounded memory store: lru, lfu, wtinylfu, random or volatile-ttl, default: lru")
+	walPolicy := flag.String("wal", "", "enables write-ahead log with a sync policy: always, everysec or no, default: disabled")
+	walRewrite := flag.Int64("walrewrite", 64<<20, "size of write-ahead log in bytes to rewrite it after, default: 64MB")
+	replicaOf := flag.String("replicaof", "", "address of a primary to replicate as a read-only replica, default: disabled")
+	backlog := flag.Int("backlog", 0, "size of the latest writes in bytes kept for replicas, 0 means replicas could not connect, default: 0")
+	cluster := flag.String("cluster", "", "comma separated addresses of all the nodes of a cluster to run as a node of, default: disabled")
+	clusterAddr := flag.String("clusteraddr", "", "address of the server the other nodes of a cluster connect to, default: 127.0.0.1:port")
+	raftSnapshot := flag.Int("raftsnapshot", 1024, "number of writes to compact the Raft log of a cluster node after, default: 1024")
 	flag.Parse()
 
-	s, err := server.Run(server.MemoryStore, *bSize, ":"+*port)
+	st, err := server.ParseStoreType(*storeName)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := []server.Option{
+		server.WithDir(*dir),
+		server.WithDatabases(*databases),
+		server.WithMemoryStoreOptions(
+			mstore.WithMaxBytes(*maxMemory),
+			mstore.WithMaxEntries(*maxKeys),
+			mstore.WithEvictionPolicy(mstore.EvictionPolicy(*eviction)),
+			mstore.WithMaxLoadFactor(*loadFactor),
+		),
+	}
+
+	if *walPolicy != "" {
+		opts = append(opts, server.WithWAL(wal.SyncPolicy(*walPolicy)), server.WithWALRewriteSize(*walRewrite))
+	}
+
+	if *replicaOf != "" {
+		opts = append(opts, server.WithReplicaOf(*replicaOf))
+	}
+
+	if *backlog > 0 {
+		opts = append(opts, server.WithBacklog(*backlog))
+	}
+
+	if *cluster != "" {
+		addr := *clusterAddr
+		if addr == "" {
+			addr = "127.0.0.1:" + *port
+		}
+		opts = append(opts, server.WithCluster(addr, strings.Split(*cluster, ",")...), server.WithRaftSnapshot(*raftSnapshot))
+	}
+
+	s, err := server.Run(st, *bSize, ":"+*port, opts...)
 	if err != nil {
 		panic(err)
 	}
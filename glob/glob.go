@@ -0,0 +1,110 @@
+// Package glob implements glob-style pattern matching used to filter keys.
+//
+// Supported syntax:
+//   - "*" matches any sequence of characters, including an empty one
+//   - "?" matches any single character
+//   - "[abc]" matches one character from the set, "[^abc]" one not in the set
+//   - "[a-z]" matches one character from the range
+//   - "\x" matches character x literally
+//
+// Unlike path.Match separators are not treated specially.
+package glob
+
+// Match reports whether s matches the pattern.
+// A malformed pattern never matches.
+func Match(pattern, s string) bool {
+	// Position to get back to when a later part of the pattern fails after a star.
+	starP, starS := -1, 0
+	p, i := 0, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if n, ok := matchClass(pattern[p:], s[i]); n > 0 {
+					if ok {
+						p += n
+						i++
+						continue
+					}
+				} else {
+					return false
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		if starP < 0 {
+			return false
+		}
+
+		// Let the last star consume one more character.
+		starS++
+		p, i = starP+1, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass matches c against a character class at the start of pattern.
+// It returns the length of the class, 0 if it is malformed.
+func matchClass(pattern string, c byte) (n int, ok bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && pattern[i] == '^' {
+		negate = true
+		i++
+	}
+
+	for first := true; i < len(pattern); first = false {
+		if pattern[i] == ']' && !first {
+			return i + 1, ok != negate
+		}
+
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		i++
+
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			hi = pattern[i+1]
+			if hi == '\\' && i+2 < len(pattern) {
+				i++
+				hi = pattern[i+1]
+			}
+			i += 2
+		}
+
+		if lo <= c && c <= hi {
+			ok = true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,39 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for i, tc := range []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{pattern: "", s: "", want: true},
+		{pattern: "", s: "a", want: false},
+		{pattern: "*", s: "", want: true},
+		{pattern: "*", s: "any/thing", want: true},
+		{pattern: "user:*", s: "user:42", want: true},
+		{pattern: "user:*", s: "session:42", want: false},
+		{pattern: "*:42", s: "user:42", want: true},
+		{pattern: "a*b*c", s: "axxbyyc", want: true},
+		{pattern: "a*b*c", s: "axxbyy", want: false},
+		{pattern: "a*a", s: "aaaa", want: true},
+		{pattern: "h?llo", s: "hello", want: true},
+		{pattern: "h?llo", s: "hllo", want: false},
+		{pattern: "h[ae]llo", s: "hallo", want: true},
+		{pattern: "h[ae]llo", s: "hillo", want: false},
+		{pattern: "h[^e]llo", s: "hallo", want: true},
+		{pattern: "h[^e]llo", s: "hello", want: false},
+		{pattern: "h[a-c]llo", s: "hbllo", want: true},
+		{pattern: "h[a-c]llo", s: "hdllo", want: false},
+		{pattern: "[]]", s: "]", want: true},
+		{pattern: "h\\*llo", s: "h*llo", want: true},
+		{pattern: "h\\*llo", s: "hello", want: false},
+		{pattern: "h[a", s: "ha", want: false},
+		{pattern: "*[0-9]", s: "key7", want: true},
+	} {
+		if got := Match(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("[%d] Match(%q, %q): got %v, want %v", i, tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
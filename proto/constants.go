@@ -7,6 +7,68 @@ const (
 	CmdUpdate = '^'
 	CmdRemove = '-'
 	CmdKeys   = '~'
+
+	CmdSnapshot = '%'
+	CmdScan     = '?'
+	CmdIncr     = 'I'
+	CmdDecr     = 'D'
+	CmdIncrBy   = 'i'
+
+	CmdGetWithVersion = 'v'
+	CmdCompareAndSwap = 'C'
+	CmdSetNX          = 'N'
+	CmdGetSet         = 'G'
+
+	CmdLPush  = 'L'
+	CmdRPush  = 'R'
+	CmdLPop   = 'l'
+	CmdRPop   = 'r'
+	CmdLRange = 'a'
+	CmdLTrim  = 't'
+	CmdLLen   = 'z'
+
+	CmdHGet    = 'h'
+	CmdHSet    = 'H'
+	CmdHDel    = 'd'
+	CmdHKeys   = 'k'
+	CmdHLen    = 'n'
+	CmdHIncrBy = 'u'
+
+	CmdSelect  = 's'
+	CmdFlushDB = 'F'
+	CmdDBStats = 'B'
+	CmdInfo    = 'f'
+	CmdResize  = 'g'
+
+	CmdMulti   = 'M'
+	CmdExec    = 'E'
+	CmdDiscard = 'X'
+	CmdWatch   = 'W'
+	CmdUnwatch = 'U'
+
+	CmdMGet = 'm'
+	CmdMSet = 'S'
+	CmdMDel = 'x'
+
+	CmdTTL      = 'T'
+	CmdExpire   = 'e'
+	CmdExpireAt = 'A'
+	CmdPersist  = 'P'
+	CmdTouch    = 'o'
+
+	CmdSetSliding = 'w'
+	CmdSliding    = 'y'
+
+	CmdKSubscribe = 'K'
+
+	CmdPublish      = 'p'
+	CmdSubscribe    = 'c'
+	CmdPSubscribe   = 'q'
+	CmdUnsubscribe  = 'b'
+	CmdPUnsubscribe = 'Q'
+
+	CmdSync = 'Y'
+	CmdRaft = 'J'
 )
 
 // Supported datatypes.
@@ -17,6 +79,8 @@ const (
 	MAP    = ':'
 	NIL    = '*'
 	INT    = '&'
+	// PUSH marks messages pushed to subscribers, they never come as a response.
+	PUSH = '|'
 )
 
 // Escape chars.
@@ -41,13 +41,15 @@ func (d *decoder) Decode(s *bufio.Scanner) (obj interface{}, err error) {
 	case INT:
 		return decodeInt(b)
 	case NIL:
-		return nil, decodeNil(s)
+		return nil, nil
 	case SLICE:
 		return d.decodeSlice(b, s)
 	case MAP:
 		return d.decodeMap(b, s)
 	case ERROR:
 		return decodeErr(b)
+	case PUSH:
+		return d.decodePush(s)
 	}
 
 	log.Err("unsupported payload type: %q", b)
@@ -85,9 +87,19 @@ func (d *decoder) DecodeMessage(buf *bufio.Reader) (m interface{}, err error) {
 
 func msgKindByMarker(m byte) (mk byte, err error) {
 	switch m {
-	case CmdGet, CmdSet, CmdUpdate, CmdRemove, CmdKeys:
+	case CmdGet, CmdSet, CmdUpdate, CmdRemove, CmdKeys, CmdSnapshot, CmdScan,
+		CmdIncr, CmdDecr, CmdIncrBy, CmdGetWithVersion, CmdCompareAndSwap,
+		CmdSetNX, CmdGetSet,
+		CmdLPush, CmdRPush, CmdLPop, CmdRPop, CmdLRange, CmdLTrim, CmdLLen,
+		CmdHGet, CmdHSet, CmdHDel, CmdHKeys, CmdHLen, CmdHIncrBy,
+		CmdSelect, CmdFlushDB, CmdDBStats, CmdInfo, CmdResize,
+		CmdMulti, CmdExec, CmdDiscard, CmdWatch, CmdUnwatch,
+		CmdMGet, CmdMSet, CmdMDel,
+		CmdTTL, CmdExpire, CmdExpireAt, CmdPersist, CmdTouch, CmdSetSliding, CmdSliding,
+		CmdKSubscribe, CmdPublish, CmdSubscribe, CmdPSubscribe, CmdUnsubscribe, CmdPUnsubscribe,
+		CmdSync, CmdRaft:
 		return KindReq, nil
-	case STRING, INT, SLICE, MAP, ERROR, NIL:
+	case STRING, INT, SLICE, MAP, ERROR, NIL, PUSH:
 		return KindRes, nil
 	}
 
@@ -117,12 +129,41 @@ func decodeReq(s *bufio.Scanner, m byte) (req *Req, err error) {
 	}
 
 	switch m {
-	case CmdKeys:
+	case CmdSnapshot, CmdFlushDB, CmdDBStats, CmdInfo, CmdMulti, CmdExec, CmdDiscard, CmdUnwatch, CmdRaft:
 		return req, nil
-	case CmdGet, CmdRemove:
+	case CmdWatch, CmdMGet, CmdMDel, CmdKSubscribe, CmdSubscribe, CmdPSubscribe, CmdUnsubscribe, CmdPUnsubscribe:
+		return reqKeys(s, req)
+	case CmdMSet:
+		return reqMSet(s, req)
+	case CmdPublish:
+		return reqPublish(s, req)
+	case CmdSelect:
+		return reqSelect(s, req)
+	case CmdResize:
+		return reqResize(s, req)
+	case CmdKeys:
+		return reqWithPattern(s, req)
+	case CmdScan:
+		return reqScan(s, req)
+	case CmdSync:
+		return reqSync(s, req)
+	case CmdGet, CmdRemove, CmdIncr, CmdDecr, CmdGetWithVersion, CmdLPop, CmdRPop, CmdLLen, CmdHKeys, CmdHLen,
+		CmdTTL, CmdPersist, CmdTouch, CmdSliding:
 		return reqWithoutValue(s, req)
-	case CmdSet, CmdUpdate:
+	case CmdExpire, CmdExpireAt:
+		return reqExpire(s, req)
+	case CmdLPush, CmdRPush, CmdHDel:
+		return reqPush(s, req)
+	case CmdHGet, CmdHSet, CmdHIncrBy:
+		return reqField(s, req)
+	case CmdLRange, CmdLTrim:
+		return reqRange(s, req)
+	case CmdIncrBy:
+		return reqIncrBy(s, req)
+	case CmdSet, CmdUpdate, CmdSetNX, CmdGetSet, CmdSetSliding:
 		return reqWithValue(s, req)
+	case CmdCompareAndSwap:
+		return reqCompareAndSwap(s, req)
 	}
 
 	log.Err("that should never happen, unsupported request command: %q", m)
@@ -155,6 +196,317 @@ func assignReqKey(s *bufio.Scanner, req *Req) error {
 	return nil
 }
 
+func reqSelect(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode database: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.DB, err = decodeSize(b); err != nil {
+		return nil, err
+	}
+
+	if req.DB < 0 {
+		log.Err("database should not be negative: %d", req.DB)
+		return nil, ErrBadMsg
+	}
+
+	return req, nil
+}
+
+// reqResize reads the number of buckets to resize a store to.
+func reqResize(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode number of buckets: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Count, err = decodeSize(b); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// reqKeys reads the number of keys followed by the keys themselves.
+func reqKeys(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode number of keys: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	n, err := decodeSize(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		log.Err("number of keys should not be negative: %d", n)
+		return nil, ErrBadMsg
+	}
+
+	// The count is not trusted to preallocate keys, as the message might have less of them.
+	req.Keys = []string{}
+	for i := 0; i < n; i++ {
+		if err = assignReqKey(s, req); err != nil {
+			return nil, err
+		}
+		req.Keys = append(req.Keys, req.Key)
+	}
+	req.Key = ""
+
+	return req, nil
+}
+
+// reqMSet reads the number of keys followed by a key, a value and a ttl for each of them.
+func reqMSet(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode number of keys: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	n, err := decodeSize(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		log.Err("number of keys should not be negative: %d", n)
+		return nil, ErrBadMsg
+	}
+
+	// The count is not trusted to preallocate items, as the message might have less of them.
+	req.Keys, req.Values, req.TTLs = []string{}, [][]byte{}, []time.Duration{}
+	for i := 0; i < n; i++ {
+		if _, err = reqWithValue(s, req); err != nil {
+			return nil, err
+		}
+		req.Keys = append(req.Keys, req.Key)
+		req.Values = append(req.Values, req.Value)
+		req.TTLs = append(req.TTLs, req.TTL)
+	}
+	req.Key, req.Value, req.TTL = "", nil, 0
+
+	return req, nil
+}
+
+// reqWithPattern reads an optional key used as a pattern, empty means any.
+func reqWithPattern(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode message pattern: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	req.Key = string(b)
+
+	return req, nil
+}
+
+func reqScan(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode scan cursor: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Cursor, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+		log.Err("unable to decode scan cursor: %q, error: %v", b, err)
+		return nil, ErrBadMsg
+	}
+
+	b, err = ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode scan count: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Count, err = decodeSize(b); err != nil {
+		return nil, err
+	}
+
+	return reqWithPattern(s, req)
+}
+
+// reqSync reads an offset of the replication stream followed by its id, which is empty for a new replica.
+func reqSync(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode replication offset: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Cursor, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+		log.Err("unable to decode replication offset: %q, error: %v", b, err)
+		return nil, ErrBadMsg
+	}
+
+	return reqWithPattern(s, req)
+}
+
+// reqField reads a key and a map field followed by a value for HSET or a delta for HINCRBY.
+func reqField(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	b, err := Extract(s)
+	if err != nil {
+		return nil, err
+	}
+	req.Field = b
+
+	switch req.Cmd {
+	case CmdHSet:
+		if err = assignReqValue(s, req); err != nil {
+			return nil, err
+		}
+	case CmdHIncrBy:
+		return reqDelta(s, req)
+	}
+
+	return req, nil
+}
+
+// reqExpire reads a key followed by a ttl for EXPIRE or a deadline in unix nanoseconds for EXPIREAT.
+func reqExpire(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	if req.Cmd == CmdExpire {
+		if err := assignReqTTL(s, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode deadline: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	at, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		log.Err("unable to decode deadline: %q, error: %v", b, err)
+		return nil, ErrBadMsg
+	}
+	req.At = time.Unix(0, at)
+
+	return req, nil
+}
+
+func reqIncrBy(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	return reqDelta(s, req)
+}
+
+func reqDelta(s *bufio.Scanner, req *Req) (*Req, error) {
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode delta: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Delta, err = strconv.ParseInt(string(b), 10, 64); err != nil {
+		log.Err("unable to decode delta: %q, error: %v", b, err)
+		return nil, ErrBadMsg
+	}
+
+	return req, nil
+}
+
+// reqPush reads a key and a slice of values, like ones to push or fields to delete.
+func reqPush(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	if err := assignReqValue(s, req); err != nil {
+		return nil, err
+	}
+
+	if req.Value[0] != SLICE {
+		log.Err("values should be a slice: %q", req.Value)
+		return nil, ErrBadMsg
+	}
+
+	return req, nil
+}
+
+// reqRange reads a key followed by start and stop indexes, which might be negative.
+func reqRange(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	for _, i := range []*int{&req.Start, &req.Stop} {
+		b, err := ReadBytes(s)
+		if err != nil {
+			log.Err("unable to decode index: %v", err)
+			return nil, ErrBadMsg
+		}
+
+		if *i, err = strconv.Atoi(string(b)); err != nil {
+			log.Err("unable to decode index: %q, error: %v", b, err)
+			return nil, ErrBadMsg
+		}
+	}
+
+	return req, nil
+}
+
+// reqCompareAndSwap reads a request like SET with the expected version between key and value.
+func reqCompareAndSwap(s *bufio.Scanner, req *Req) (*Req, error) {
+	var err error
+
+	if err = assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	b, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode version: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	if req.Version, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+		log.Err("unable to decode version: %q, error: %v", b, err)
+		return nil, ErrBadMsg
+	}
+
+	if err = assignReqValue(s, req); err != nil {
+		return nil, err
+	}
+
+	if err = assignReqTTL(s, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// reqPublish reads a channel kept as a key and a value to be sent to it.
+func reqPublish(s *bufio.Scanner, req *Req) (*Req, error) {
+	if err := assignReqKey(s, req); err != nil {
+		return nil, err
+	}
+
+	if err := assignReqValue(s, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
 func reqWithValue(s *bufio.Scanner, req *Req) (*Req, error) {
 	var err error
 
@@ -209,23 +561,21 @@ func decodeString(b []byte) (s string, err error) {
 	return strconv.Unquote(string(b[1:]))
 }
 
-func decodeInt(b []byte) (i int, err error) {
-	if len(b) == 1 {
-		return
+// DecodeInt decodes a raw encoded INT value b, ErrUnsupportedType if it is of other type.
+func DecodeInt(b []byte) (int, error) {
+	if len(b) == 0 || b[0] != INT {
+		return 0, ErrUnsupportedType
 	}
 
-	return decodeSize(b[1:])
+	return decodeInt(b)
 }
 
-func decodeNil(s *bufio.Scanner) error {
-	b, err := ReadBytes(s)
-
-	if err != nil {
-		log.Err("unable to decode nil: %q, err: %v", b, err)
-		return ErrBadMsg
+func decodeInt(b []byte) (i int, err error) {
+	if len(b) == 1 {
+		return
 	}
 
-	return nil
+	return decodeSize(b[1:])
 }
 
 func decodeErr(b []byte) (error, error) {
@@ -249,6 +599,8 @@ func decodeErr(b []byte) (error, error) {
 		return ErrBadDelimiter, nil
 	case ErrUnknown.Error():
 		return ErrUnknown, nil
+	case ErrTxAborted.Error():
+		return ErrTxAborted, nil
 	}
 
 	return errors.New(str), nil
@@ -100,6 +100,14 @@ func TestDecode(t *testing.T) {
 			in:   []byte("@3\n$\"hi\"\n:\n$\"du\\t\\nde\""),
 			want: []interface{}{"hi", nullMap, "du\t\nde"},
 			desc: "few element slice",
+		}, {
+			in:   []byte("@3\n*\n&1\n*"),
+			want: []interface{}{nil, 1, nil},
+			desc: "slice with nils",
+		}, {
+			in:   []byte("@2\n*\n$\"hi\""),
+			want: []interface{}{nil, "hi"},
+			desc: "nil followed by an element",
 		}, {
 			in:   []byte(":0"),
 			want: map[interface{}]interface{}{},
@@ -113,6 +121,18 @@ func TestDecode(t *testing.T) {
 			in:   []byte(":2\n$\"hi\"\n$\"du\\nde\"\n$\"some\"\n$\"te\\tst\""),
 			want: map[interface{}]interface{}{"hi": "du\nde", "some": "te\tst"},
 			desc: "few element map",
+		}, {
+			in:   []byte(":2\n$\"hi\"\n*\n$\"some\"\n&1"),
+			want: map[interface{}]interface{}{"hi": nil, "some": 1},
+			desc: "map with nil value",
+		}, {
+			in:   []byte("|\nnews.local\nnews.*\n@2\n$\"hi\"\n&1"),
+			want: &Push{Channel: "news.local", Pattern: "news.*", Val: []interface{}{"hi", 1}},
+			desc: "push",
+		}, {
+			in:   []byte("|\nnews\n\n$\"hi\""),
+			want: &Push{Channel: "news", Val: "hi"},
+			desc: "push without pattern",
 		},
 	} {
 		r := bytes.NewReader(tc.in)
@@ -128,6 +148,22 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+// NIL has nothing following its marker, so it should not consume the next value.
+func TestDecodeNilStream(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte("*\n$\"hi\"")))
+
+	for i, want := range []interface{}{nil, "hi"} {
+		got, err := NewDecoder().Decode(s)
+		if err != nil {
+			t.Fatalf("[%d] unable to decode: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("[%d] got %q, want %q", i, got, want)
+		}
+	}
+}
+
 func TestDecodeUnsupported(t *testing.T) {
 	r := bytes.NewReader([]byte{'>'})
 	_, err := NewDecoder().Decode(NewScanner(r))
@@ -195,6 +231,271 @@ func TestDecodeReqMessage(t *testing.T) {
 				Cmd: CmdKeys,
 			},
 			desc: "keys",
+		}, {
+			in: []byte("~\nuser:*\r"),
+			want: Req{
+				Cmd: CmdKeys,
+				Key: "user:*",
+			},
+			desc: "keys matching pattern",
+		}, {
+			in: []byte("?\n4294967298\n10\nuser:*\r"),
+			want: Req{
+				Cmd:    CmdScan,
+				Key:    "user:*",
+				Cursor: 4294967298,
+				Count:  10,
+			},
+			desc: "scan",
+		}, {
+			in: []byte("?\n0\n0\n\r"),
+			want: Req{
+				Cmd: CmdScan,
+			},
+			desc: "scan without pattern",
+		}, {
+			in: []byte("%\n\r"),
+			want: Req{
+				Cmd: CmdSnapshot,
+			},
+			desc: "snapshot",
+		}, {
+			in: []byte("I\ncounter\r"),
+			want: Req{
+				Cmd: CmdIncr,
+				Key: "counter",
+			},
+			desc: "incr",
+		}, {
+			in: []byte("i\ncounter\n-5\r"),
+			want: Req{
+				Cmd:   CmdIncrBy,
+				Key:   "counter",
+				Delta: -5,
+			},
+			desc: "incrby",
+		}, {
+			in: []byte("v\nkey\r"),
+			want: Req{
+				Cmd: CmdGetWithVersion,
+				Key: "key",
+			},
+			desc: "get with version",
+		}, {
+			in: []byte("C\nkey\n42\n@1\n$\"value\"\n100\r"),
+			want: Req{
+				Cmd:     CmdCompareAndSwap,
+				Key:     "key",
+				Value:   []byte("@1\n$\"value\""),
+				TTL:     time.Duration(100),
+				Version: 42,
+			},
+			desc: "compare and swap",
+		}, {
+			in: []byte("N\nkey\n$\"value\"\n100\r"),
+			want: Req{
+				Cmd:   CmdSetNX,
+				Key:   "key",
+				Value: []byte("$\"value\""),
+				TTL:   time.Duration(100),
+			},
+			desc: "set if absent",
+		}, {
+			in: []byte("G\nkey\n$\"value\"\n0\r"),
+			want: Req{
+				Cmd:   CmdGetSet,
+				Key:   "key",
+				Value: []byte("$\"value\""),
+			},
+			desc: "get and set",
+		}, {
+			in: []byte("R\nlist\n@2\n&1\n$\"two\"\r"),
+			want: Req{
+				Cmd:   CmdRPush,
+				Key:   "list",
+				Value: []byte("@2\n&1\n$\"two\""),
+			},
+			desc: "push",
+		}, {
+			in: []byte("l\nlist\r"),
+			want: Req{
+				Cmd: CmdLPop,
+				Key: "list",
+			},
+			desc: "pop",
+		}, {
+			in: []byte("a\nlist\n1\n-1\r"),
+			want: Req{
+				Cmd:   CmdLRange,
+				Key:   "list",
+				Start: 1,
+				Stop:  -1,
+			},
+			desc: "range",
+		}, {
+			in: []byte("H\nsession\n$\"name\"\n$\"kermit\"\r"),
+			want: Req{
+				Cmd:   CmdHSet,
+				Key:   "session",
+				Field: []byte("$\"name\""),
+				Value: []byte("$\"kermit\""),
+			},
+			desc: "hset",
+		}, {
+			in: []byte("h\nsession\n@1\n&1\r"),
+			want: Req{
+				Cmd:   CmdHGet,
+				Key:   "session",
+				Field: []byte("@1\n&1"),
+			},
+			desc: "hget with slice field",
+		}, {
+			in: []byte("u\nsession\n$\"visits\"\n-1\r"),
+			want: Req{
+				Cmd:   CmdHIncrBy,
+				Key:   "session",
+				Field: []byte("$\"visits\""),
+				Delta: -1,
+			},
+			desc: "hincrby",
+		}, {
+			in: []byte("d\nsession\n@2\n$\"name\"\n&1\r"),
+			want: Req{
+				Cmd:   CmdHDel,
+				Key:   "session",
+				Value: []byte("@2\n$\"name\"\n&1"),
+			},
+			desc: "hdel",
+		}, {
+			in: []byte("s\n3\r"),
+			want: Req{
+				Cmd: CmdSelect,
+				DB:  3,
+			},
+			desc: "select",
+		}, {
+			in: []byte("F\n\r"),
+			want: Req{
+				Cmd: CmdFlushDB,
+			},
+			desc: "flushdb",
+		}, {
+			in: []byte("E\n\r"),
+			want: Req{
+				Cmd: CmdExec,
+			},
+			desc: "exec",
+		}, {
+			in: []byte("W\n2\nsome_key\nother_key\r"),
+			want: Req{
+				Cmd:  CmdWatch,
+				Keys: []string{"some_key", "other_key"},
+			},
+			desc: "watch",
+		}, {
+			in: []byte("m\n2\nsome_key\nother_key\r"),
+			want: Req{
+				Cmd:  CmdMGet,
+				Keys: []string{"some_key", "other_key"},
+			},
+			desc: "mget",
+		}, {
+			in: []byte("K\n1\nuser:*\r"),
+			want: Req{
+				Cmd:  CmdKSubscribe,
+				Keys: []string{"user:*"},
+			},
+			desc: "ksubscribe",
+		}, {
+			in: []byte("g\n64\r"),
+			want: Req{
+				Cmd:   CmdResize,
+				Count: 64,
+			},
+			desc: "resize",
+		}, {
+			in: []byte("Y\n1024\n5f3a\r"),
+			want: Req{
+				Cmd:    CmdSync,
+				Key:    "5f3a",
+				Cursor: 1024,
+			},
+			desc: "sync",
+		}, {
+			in: []byte("Y\n0\n\r"),
+			want: Req{
+				Cmd: CmdSync,
+			},
+			desc: "sync of a new replica",
+		}, {
+			in: []byte("J\n\r"),
+			want: Req{
+				Cmd: CmdRaft,
+			},
+			desc: "raft",
+		}, {
+			in: []byte("p\nnews\n$\"hi\"\r"),
+			want: Req{
+				Cmd:   CmdPublish,
+				Key:   "news",
+				Value: []byte("$\"hi\""),
+			},
+			desc: "publish",
+		}, {
+			in: []byte("q\n2\nnews.*\nsport.*\r"),
+			want: Req{
+				Cmd:  CmdPSubscribe,
+				Keys: []string{"news.*", "sport.*"},
+			},
+			desc: "psubscribe",
+		}, {
+			in: []byte("b\n0\r"),
+			want: Req{
+				Cmd:  CmdUnsubscribe,
+				Keys: []string{},
+			},
+			desc: "unsubscribe from all",
+		}, {
+			in: []byte("S\n2\nsome_key\n$\"value\"\n1000\nother_key\n&1\n0\r"),
+			want: Req{
+				Cmd:    CmdMSet,
+				Keys:   []string{"some_key", "other_key"},
+				Values: [][]byte{[]byte("$\"value\""), []byte("&1")},
+				TTLs:   []time.Duration{1000, 0},
+			},
+			desc: "mset",
+		}, {
+			in: []byte("e\nsome_key\n1000\r"),
+			want: Req{
+				Cmd: CmdExpire,
+				Key: "some_key",
+				TTL: 1000,
+			},
+			desc: "expire",
+		}, {
+			in: []byte("A\nsome_key\n1500000000000000000\r"),
+			want: Req{
+				Cmd: CmdExpireAt,
+				Key: "some_key",
+				At:  time.Unix(0, 1500000000000000000),
+			},
+			desc: "expireat",
+		}, {
+			in: []byte("P\nsome_key\r"),
+			want: Req{
+				Cmd: CmdPersist,
+				Key: "some_key",
+			},
+			desc: "persist",
+		}, {
+			in: []byte("w\nsession\n$\"kermit\"\n1000\r"),
+			want: Req{
+				Cmd:   CmdSetSliding,
+				Key:   "session",
+				Value: []byte("$\"kermit\""),
+				TTL:   1000,
+			},
+			desc: "setsliding",
 		},
 	} {
 		r := bytes.NewReader(tc.in)
@@ -260,6 +561,58 @@ func TestDecodeInvalidMessage(t *testing.T) {
 			in:   []byte("$hi\r"),
 			want: ErrBadMsg,
 			desc: "malformed value",
+		}, {
+			in:   []byte("i\ncounter\none\r"),
+			want: ErrBadMsg,
+			desc: "malformed delta",
+		}, {
+			in:   []byte("C\nkey\n-1\n$\"value\"\n0\r"),
+			want: ErrBadMsg,
+			desc: "malformed version",
+		}, {
+			in:   []byte("L\nlist\n$\"value\"\r"),
+			want: ErrBadMsg,
+			desc: "push not a slice",
+		}, {
+			in:   []byte("t\nlist\n0\r"),
+			want: ErrBadMsg,
+			desc: "trim without stop",
+		}, {
+			in:   []byte("s\n-1\r"),
+			want: ErrBadMsg,
+			desc: "negative database",
+		}, {
+			in:   []byte("W\n2\nsome_key\n\r"),
+			want: ErrBadMsg,
+			desc: "watch empty key",
+		}, {
+			in:   []byte("W\n100000000000\nsome_key\r"),
+			want: ErrBadMsg,
+			desc: "watch with more keys than sent",
+		}, {
+			in:   []byte("W\n9223372036854775807\nsome_key\r"),
+			want: ErrBadMsg,
+			desc: "watch with max number of keys",
+		}, {
+			in:   []byte("S\n2\nsome_key\n$\"value\"\n1000\r"),
+			want: ErrBadMsg,
+			desc: "mset missed key",
+		}, {
+			in:   []byte("S\n100000000000\nsome_key\n$\"value\"\n1000\r"),
+			want: ErrBadMsg,
+			desc: "mset with more keys than sent",
+		}, {
+			in:   []byte("S\n1\nsome_key\n$\"value\"\r"),
+			want: ErrBadMsg,
+			desc: "mset without ttl",
+		}, {
+			in:   []byte("e\nsome_key\n-1\r"),
+			want: ErrBadMsg,
+			desc: "expire negative ttl",
+		}, {
+			in:   []byte("A\nsome_key\r"),
+			want: ErrBadMsg,
+			desc: "expireat without deadline",
 		},
 	} {
 		r := bytes.NewReader(tc.in)
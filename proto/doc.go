@@ -4,13 +4,63 @@ incomming/outcomming messages and runtime objects according to protocol format.
 
 Protocol definition:
 
-| Command | Leading Byte |
-|---------|--------------|
-| GET     | #            |
-| SET     | +            |
-| UPDATE  | ^            |
-| REMOVE  | -            |
-| KEYS    | ~            |
+| Command      | Leading Byte |
+|--------------|--------------|
+| GET          | #            |
+| SET          | +            |
+| UPDATE       | ^            |
+| REMOVE       | -            |
+| KEYS         | ~            |
+| SNAPSHOT     | %            |
+| SCAN         | ?            |
+| INCR         | I            |
+| DECR         | D            |
+| INCRBY       | i            |
+| GETV         | v            |
+| CAS          | C            |
+| SETNX        | N            |
+| GETSET       | G            |
+| LPUSH        | L            |
+| RPUSH        | R            |
+| LPOP         | l            |
+| RPOP         | r            |
+| LRANGE       | a            |
+| LTRIM        | t            |
+| LLEN         | z            |
+| HGET         | h            |
+| HSET         | H            |
+| HDEL         | d            |
+| HKEYS        | k            |
+| HLEN         | n            |
+| HINCRBY      | u            |
+| SELECT       | s            |
+| FLUSHDB      | F            |
+| DBSTATS      | B            |
+| INFO         | f            |
+| RESIZE       | g            |
+| MULTI        | M            |
+| EXEC         | E            |
+| DISCARD      | X            |
+| WATCH        | W            |
+| UNWATCH      | U            |
+| MGET         | m            |
+| MSET         | S            |
+| MDEL         | x            |
+| TTL          | T            |
+| EXPIRE       | e            |
+| EXPIREAT     | A            |
+| PERSIST      | P            |
+| TOUCH        | o            |
+| SETSLIDE     | w            |
+| SLIDING      | y            |
+| KSUBSCRIBE   | K            |
+| PUBLISH      | p            |
+| SUBSCRIBE    | c            |
+| PSUBSCRIBE   | q            |
+| UNSUBSCRIBE  | b            |
+| PUNSUBSCRIBE | Q            |
+| SYNC         | Y            |
+| RAFT         | J            |
 
 
 |        Runtime types        | Leading Byte |
@@ -21,6 +71,7 @@ Protocol definition:
 | []interface{}               | @            |
 | map[interface{}]interface{} | :            |
 | nil                         | ~            |
+| push message                | |            |
 
 
 |  Escape chars  | Byte |
@@ -31,7 +82,7 @@ Protocol definition:
 Some simple rules to follow:
 - message parts (segments) are separated by "segment escape"
 - all incomming value strings are equoted
-- keys, ttl, map/slice sizes are not encoded, only values
+- keys, ttl, map/slice sizes and other numeric arguments are not encoded, only values
 - the size of map/slice follows its leading byte
 - elements of map/slice follows its head, one by one, separated by "segment escape"
 - messages contain only leading byte considered as nil of the type
@@ -45,6 +96,51 @@ Examples:
 | UPDATE some_key to []interface{100, "cool\tstory"} without ttl | ^\nsome_key\n@2\n&100\n$\"cool\\tstory\"\n0\r |
 | REMOVE some_key                                                | -\nsome_key\r                                 |
 | KEYS                                                           | ~\n\r                                         |
+| KEYS matching user:*                                           | ~\nuser:*\r                                   |
+| SNAPSHOT, starts a background snapshot                         | %\n\r                                         |
+| SCAN 10 keys matching user:* from cursor 0                     | ?\n0\n10\nuser:*\r                            |
+| INCR counter                                                   | I\ncounter\r                                  |
+| INCRBY counter by -5                                           | i\ncounter\n-5\r                              |
+| GETV some_key, returns a slice of the value and its version    | v\nsome_key\r                                 |
+| CAS some_key to "new" if its version is still 42, without ttl  | C\nsome_key\n42\n$\"new\"\n0\r                |
+| SETNX lock to "owner" with ttl 1000, returns &1 if it was set  | N\nlock\n$\"owner\"\n1000\r                   |
+| GETSET some_key to "new", returns the previous value           | G\nsome_key\n$\"new\"\n0\r                    |
+| RPUSH 1 and "two" to the tail of list, returns its length      | R\nlist\n@2\n&1\n$\"two\"\r                   |
+| LPOP list, returns the first element                           | l\nlist\r                                     |
+| LRANGE list from the second element to the last one            | a\nlist\n1\n-1\r                              |
+| HSET "name" field of session to "kermit", &1 if it is new      | H\nsession\n$\"name\"\n$\"kermit\"\r          |
+| HGET "name" field of session                                   | h\nsession\n$\"name\"\r                       |
+| HDEL "name" and 1 fields of session, returns a number deleted  | d\nsession\n@2\n$\"name\"\n&1\r               |
+| HINCRBY "visits" field of session by 1                         | u\nsession\n$\"visits\"\n1\r                  |
+| SELECT database 3 for the following commands of a connection   | s\n3\r                                        |
+| FLUSHDB, removes all the keys of the selected database         | F\n\r                                         |
+| DBSTATS, returns a map of databases to their number of keys    | B\n\r                                         |
+| INFO, returns a map of metrics of the selected database        | f\n\r                                         |
+| RESIZE the store of the selected database to 64 buckets        | g\n64\r                                       |
+| MULTI, starts queuing the following commands of a connection   | M\n\r                                         |
+| EXEC, applies queued commands atomically, returns results      | E\n\r                                         |
+| DISCARD, drops queued commands                                 | X\n\r                                         |
+| WATCH some_key and other_key for changes until EXEC            | W\n2\nsome_key\nother_key\r                   |
+| UNWATCH, forgets all the watched keys                          | U\n\r                                         |
+| MGET some_key and other_key, returns values or errors in order | m\n2\nsome_key\nother_key\r                   |
+| MSET some_key to 1 with ttl 1000 and other_key to 2            | S\n2\nsome_key\n&1\n1000\nother_key\n&2\n0\r  |
+| MDEL some_key and other_key, returns nils or errors in order   | x\n2\nsome_key\nother_key\r                   |
+| TTL some_key, returns the time left in nanoseconds, &0 if none | T\nsome_key\r                                 |
+| EXPIRE some_key in 1000, 0 removes it right away               | e\nsome_key\n1000\r                           |
+| EXPIREAT some_key at 1500000000000000000 unix nanoseconds      | A\nsome_key\n1500000000000000000\r            |
+| PERSIST some_key, removes its ttl                              | P\nsome_key\r                                 |
+| TOUCH some_key, records a usage without reading the value      | o\nsome_key\r                                 |
+| SETSLIDE session to "kermit" with ttl 1000 renewed by GET      | w\nsession\n$\"kermit\"\n1000\r               |
+| SLIDING session, returns its renewal ttl, &0 if none           | y\nsession\r                                  |
+| KSUBSCRIBE to changes of keys matching user:*, streams events  | K\n1\nuser:*\r                                |
+| PUBLISH "hi" to news, returns the number of receivers          | p\nnews\n$\"hi\"\r                            |
+| SUBSCRIBE to news and sport, returns number of subscriptions   | c\n2\nnews\nsport\r                           |
+| PSUBSCRIBE to channels matching news.*                         | q\n1\nnews.*\r                                |
+| UNSUBSCRIBE from all the channels subscribed by name           | b\n0\r                                        |
+| PUNSUBSCRIBE from news.*                                       | Q\n1\nnews.*\r                                |
+| SYNC a replica of stream 5f3a from 1024, streams writes        | Y\n1024\n5f3a\r                               |
+| RAFT, switches a connection of a cluster node to Raft messages | J\n\r                                         |
+| push of "hi" sent to news.local, matched by news.*             | |\nnews.local\nnews.*\n$\"hi\"\r              |
 
 More examples could be found in decoder_test.go and encoder_test.go.
 */
@@ -123,6 +123,30 @@ func encodeSlice(in []interface{}) ([]byte, error) {
 	return b, nil
 }
 
+// EncodeRawSlice encodes a slice of values which are encoded already.
+func EncodeRawSlice(in ...[]byte) []byte {
+	b := append([]byte{SLICE}, IntToBytes(int64(len(in)))...)
+	for _, v := range in {
+		b = append(b, NL)
+		b = append(b, v...)
+	}
+
+	return b
+}
+
+// EncodeRawMap encodes a map of keys and values which are encoded already.
+func EncodeRawMap(keys, vals [][]byte) []byte {
+	b := append([]byte{MAP}, IntToBytes(int64(len(keys)))...)
+	for i := range keys {
+		b = append(b, NL)
+		b = append(b, keys[i]...)
+		b = append(b, NL)
+		b = append(b, vals[i]...)
+	}
+
+	return b
+}
+
 func encodeStringSlice(in []string) ([]byte, error) {
 	slice := make([]interface{}, len(in))
 	for i, v := range in {
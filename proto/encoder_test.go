@@ -157,6 +157,25 @@ func TestPrepareMessage(t *testing.T) {
 	}
 }
 
+func TestEncodeRawSlice(t *testing.T) {
+	b := EncodeRawSlice([]byte("$\"value\""), []byte("&42"))
+	if want := []byte("@2\n$\"value\"\n&42"); !bytes.Equal(b, want) {
+		t.Errorf("should match format, got %q, want %q", b, want)
+	}
+
+	got, err := NewDecoder().Decode(NewScanner(bytes.NewReader(b)))
+	if err != nil || !reflect.DeepEqual(got, []interface{}{"value", 42}) {
+		t.Errorf("should be decodable, got %q, error: %v", got, err)
+	}
+}
+
+func TestEncodePush(t *testing.T) {
+	b := EncodePush("news.local", "news.*", []byte("$\"hi\""))
+	if want := []byte("|\nnews.local\nnews.*\n$\"hi\""); !bytes.Equal(b, want) {
+		t.Errorf("should match format, got %q, want %q", b, want)
+	}
+}
+
 func TestEncodeDecode(t *testing.T) {
 	var (
 		nullInterface interface{}
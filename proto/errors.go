@@ -8,4 +8,5 @@ var (
 	ErrBadMsg          = errors.New("malformed message")
 	ErrBadDelimiter    = errors.New("bad delimiter")
 	ErrUnknown         = errors.New("unknown error")
+	ErrTxAborted       = errors.New("transaction aborted")
 )
@@ -2,6 +2,7 @@ package proto
 
 import (
 	"bufio"
+	"bytes"
 
 	log "github.com/aliaksandrb/cachy/logger"
 )
@@ -100,3 +101,56 @@ func extractMap(head []byte, s *bufio.Scanner) ([]byte, error) {
 
 	return head, nil
 }
+
+// SplitSlice splits a raw encoded slice b into raw encoded elements.
+// ErrUnsupportedType if b is not a slice.
+func SplitSlice(b []byte) ([][]byte, error) {
+	return split(b, SLICE, 1)
+}
+
+// SplitMap splits a raw encoded map b into raw encoded keys and values.
+// ErrUnsupportedType if b is not a map.
+func SplitMap(b []byte) (keys, vals [][]byte, err error) {
+	items, err := split(b, MAP, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := 0; i < len(items); i += 2 {
+		keys = append(keys, items[i])
+		vals = append(vals, items[i+1])
+	}
+
+	return keys, vals, nil
+}
+
+// split splits a raw encoded slice or map b into raw encoded items, there are n of them per element.
+func split(b []byte, kind byte, n int) ([][]byte, error) {
+	if len(b) == 0 || b[0] != kind {
+		return nil, ErrUnsupportedType
+	}
+
+	s := NewScanner(bytes.NewReader(b))
+	head, err := ReadBytes(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(head) == 1 {
+		return nil, nil
+	}
+
+	size, err := decodeSize(head[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, size*n)
+	for i := range items {
+		if items[i], err = Extract(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
@@ -2,6 +2,7 @@ package proto
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -117,3 +118,53 @@ func TestExtractUnsupported(t *testing.T) {
 		t.Errorf("should be unsupported, got %q, want %q", err, ErrBadMsg)
 	}
 }
+
+func TestSplitSlice(t *testing.T) {
+	for i, tc := range []struct {
+		in   []byte
+		want [][]byte
+		err  error
+	}{
+		{in: []byte("@"), want: nil},
+		{in: []byte("@0"), want: [][]byte{}},
+		{in: []byte("@1\n$\"kermit\""), want: [][]byte{[]byte("$\"kermit\"")}},
+		{
+			in:   []byte("@3\n&1\n@2\n$\"hi\"\n:\n$\"du\\nde\""),
+			want: [][]byte{[]byte("&1"), []byte("@2\n$\"hi\"\n:"), []byte("$\"du\\nde\"")},
+		},
+		{in: []byte("$\"kermit\""), err: ErrUnsupportedType},
+	} {
+		got, err := SplitSlice(tc.in)
+		if err != tc.err {
+			t.Errorf("[%d] unexpected error: got %v, want %v", i, err, tc.err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("[%d] got %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestSplitMap(t *testing.T) {
+	keys, vals, err := SplitMap([]byte(":2\n$\"name\"\n$\"kermit\"\n&1\n@1\n&2"))
+	if err != nil {
+		t.Fatalf("unable to split: %v", err)
+	}
+
+	if want := [][]byte{[]byte("$\"name\""), []byte("&1")}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+
+	if want := [][]byte{[]byte("$\"kermit\""), []byte("@1\n&2")}; !reflect.DeepEqual(vals, want) {
+		t.Errorf("unexpected values: got %q, want %q", vals, want)
+	}
+
+	if got := EncodeRawMap(keys, vals); !bytes.Equal(got, []byte(":2\n$\"name\"\n$\"kermit\"\n&1\n@1\n&2")) {
+		t.Errorf("should be encoded back, got %q", got)
+	}
+
+	if _, _, err = SplitMap([]byte("@0")); err != ErrUnsupportedType {
+		t.Errorf("should be unsupported, got %v", err)
+	}
+}
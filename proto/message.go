@@ -1,19 +1,23 @@
 package proto
 
 import (
+	"strconv"
 	"time"
 )
 
 func NewMessage(cmd byte, key string, value interface{}, ttl time.Duration) (b []byte, err error) {
 	b = []byte{cmd, NL}
 
-	if cmd == CmdKeys {
+	switch cmd {
+	case CmdSnapshot, CmdFlushDB, CmdDBStats, CmdInfo, CmdMulti, CmdExec, CmdDiscard, CmdUnwatch, CmdRaft:
 		return append(b, CR), nil
 	}
 
 	b = append(b, []byte(key)...)
 
-	if cmd == CmdGet || cmd == CmdRemove {
+	switch cmd {
+	case CmdGet, CmdRemove, CmdKeys, CmdIncr, CmdDecr, CmdGetWithVersion, CmdLPop, CmdRPop, CmdLLen,
+		CmdHKeys, CmdHLen, CmdTTL, CmdPersist, CmdTouch, CmdSliding:
 		return append(b, CR), nil
 	}
 
@@ -29,3 +33,204 @@ func NewMessage(cmd byte, key string, value interface{}, ttl time.Duration) (b [
 
 	return append(b, CR), nil
 }
+
+// NewScanMessage returns SCAN request for count keys matching pattern starting from cursor.
+func NewScanMessage(cursor uint64, pattern string, count int) []byte {
+	b := []byte{CmdScan, NL}
+	b = strconv.AppendUint(b, cursor, 10)
+	b = append(b, NL)
+	b = append(b, IntToBytes(int64(count))...)
+	b = append(b, NL)
+	b = append(b, pattern...)
+
+	return append(b, CR)
+}
+
+// NewKeysMessage returns a request of a command working with many keys at once, like WATCH.
+func NewKeysMessage(cmd byte, keys []string) []byte {
+	b := []byte{cmd, NL}
+	b = append(b, IntToBytes(int64(len(keys)))...)
+	for _, k := range keys {
+		b = append(b, NL)
+		b = append(b, k...)
+	}
+
+	return append(b, CR)
+}
+
+// NewMSetMessage returns MSET request setting values with their ttls for keys, all given in the same order.
+func NewMSetMessage(keys []string, values []interface{}, ttls []time.Duration) ([]byte, error) {
+	if len(values) != len(keys) || len(ttls) != len(keys) {
+		return nil, ErrBadMsg
+	}
+
+	b := []byte{CmdMSet, NL}
+	b = append(b, IntToBytes(int64(len(keys)))...)
+	for i, k := range keys {
+		valueEnc, err := Encode(values[i])
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, NL)
+		b = append(b, k...)
+		b = append(b, NL)
+		b = append(b, valueEnc...)
+		b = append(b, NL)
+		b = append(b, IntToBytes(int64(ttls[i]))...)
+	}
+
+	return append(b, CR), nil
+}
+
+// NewIncrByMessage returns INCRBY request adding delta to a key.
+func NewIncrByMessage(key string, delta int64) []byte {
+	b := []byte{CmdIncrBy, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(delta)...)
+
+	return append(b, CR)
+}
+
+// NewExpireMessage returns EXPIRE request setting a key to expire in ttl.
+func NewExpireMessage(key string, ttl time.Duration) []byte {
+	b := []byte{CmdExpire, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(int64(ttl))...)
+
+	return append(b, CR)
+}
+
+// NewExpireAtMessage returns EXPIREAT request setting a key to expire at a time.
+func NewExpireAtMessage(key string, at time.Time) []byte {
+	b := []byte{CmdExpireAt, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(at.UnixNano())...)
+
+	return append(b, CR)
+}
+
+// NewCompareAndSwapMessage returns CAS request setting a value for a key if its version still matches.
+func NewCompareAndSwapMessage(key string, value interface{}, version uint64, ttl time.Duration) ([]byte, error) {
+	valueEnc, err := Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{CmdCompareAndSwap, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = strconv.AppendUint(b, version, 10)
+	b = append(b, NL)
+	b = append(b, valueEnc...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(int64(ttl))...)
+
+	return append(b, CR), nil
+}
+
+// NewPushMessage returns LPUSH or RPUSH request adding values to a list,
+// it is used by HDEL to pass fields to delete as well.
+func NewPushMessage(cmd byte, key string, values []interface{}) ([]byte, error) {
+	valuesEnc, err := Encode(values)
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{cmd, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, valuesEnc...)
+
+	return append(b, CR), nil
+}
+
+// NewRangeMessage returns LRANGE or LTRIM request for list elements between start and stop.
+func NewRangeMessage(cmd byte, key string, start, stop int) []byte {
+	b := []byte{cmd, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(int64(start))...)
+	b = append(b, NL)
+	b = append(b, IntToBytes(int64(stop))...)
+
+	return append(b, CR)
+}
+
+// NewFieldMessage returns HGET, HSET or HINCRBY request for a field of a map.
+// The value is used by HSET only, while HINCRBY expects an int64 delta.
+func NewFieldMessage(cmd byte, key string, field, value interface{}) ([]byte, error) {
+	fieldEnc, err := Encode(field)
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{cmd, NL}
+	b = append(b, key...)
+	b = append(b, NL)
+	b = append(b, fieldEnc...)
+
+	switch cmd {
+	case CmdHSet:
+		valueEnc, err := Encode(value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, NL)
+		b = append(b, valueEnc...)
+	case CmdHIncrBy:
+		delta, ok := value.(int64)
+		if !ok {
+			return nil, ErrUnsupportedType
+		}
+		b = append(b, NL)
+		b = append(b, IntToBytes(delta)...)
+	}
+
+	return append(b, CR), nil
+}
+
+// NewPublishMessage returns PUBLISH request sending a value to subscribers of a channel.
+func NewPublishMessage(channel string, value interface{}) ([]byte, error) {
+	valueEnc, err := Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{CmdPublish, NL}
+	b = append(b, channel...)
+	b = append(b, NL)
+	b = append(b, valueEnc...)
+
+	return append(b, CR), nil
+}
+
+// NewSyncMessage returns SYNC request of a replica which has applied the replication stream id
+// up to offset, an empty id asks for a full copy of the data.
+func NewSyncMessage(id string, offset uint64) []byte {
+	b := []byte{CmdSync, NL}
+	b = strconv.AppendUint(b, offset, 10)
+	b = append(b, NL)
+	b = append(b, id...)
+
+	return append(b, CR)
+}
+
+// NewResizeMessage returns RESIZE request changing the number of buckets of a store to n.
+func NewResizeMessage(n int) []byte {
+	b := []byte{CmdResize, NL}
+	b = append(b, IntToBytes(int64(n))...)
+
+	return append(b, CR)
+}
+
+// NewSelectMessage returns SELECT request switching a connection to a database.
+func NewSelectMessage(db int) []byte {
+	b := []byte{CmdSelect, NL}
+	b = append(b, IntToBytes(int64(db))...)
+
+	return append(b, CR)
+}
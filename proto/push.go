@@ -0,0 +1,48 @@
+package proto
+
+import (
+	"bufio"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// Push is a message the server sends to a subscribed connection on its own, outside of request/response flow.
+type Push struct {
+	Channel string
+	// Pattern is a pattern the channel matched for subscriptions made with PSUBSCRIBE, empty otherwise.
+	Pattern string
+	Val     interface{}
+}
+
+// EncodePush encodes a message of a channel matched by pattern, val is encoded already.
+func EncodePush(channel, pattern string, val []byte) []byte {
+	b := make([]byte, 0, len(channel)+len(pattern)+len(val)+4)
+	b = append(b, PUSH, NL)
+	b = append(b, channel...)
+	b = append(b, NL)
+	b = append(b, pattern...)
+	b = append(b, NL)
+
+	return append(b, val...)
+}
+
+func (d *decoder) decodePush(s *bufio.Scanner) (*Push, error) {
+	channel, err := ReadBytes(s)
+	if err != nil || len(channel) == 0 {
+		log.Err("unable to decode push channel: %q, err: %v", channel, err)
+		return nil, ErrBadMsg
+	}
+
+	pattern, err := ReadBytes(s)
+	if err != nil {
+		log.Err("unable to decode push pattern: %v", err)
+		return nil, ErrBadMsg
+	}
+
+	val, err := d.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Push{Channel: string(channel), Pattern: string(pattern), Val: val}, nil
+}
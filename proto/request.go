@@ -7,4 +7,25 @@ type Req struct {
 	Key   string
 	Value []byte
 	TTL   time.Duration
+	// At is a deadline used by EXPIREAT.
+	At time.Time
+	// Cursor and Count are used by SCAN, while Key keeps its pattern. RESIZE uses Count as well.
+	// SYNC keeps an offset of the replication stream in Cursor and its id in Key.
+	Cursor uint64
+	Count  int
+	// Delta is used by INCRBY.
+	Delta int64
+	// Version is used by CAS.
+	Version uint64
+	// Start and Stop are indexes used by list commands.
+	Start, Stop int
+	// Field is a raw encoded map key used by hash commands.
+	Field []byte
+	// DB is an index of a database used by SELECT.
+	DB int
+	// Keys are used by commands working with many keys at once, like WATCH.
+	Keys []string
+	// Values and TTLs go along with Keys one by one for MSET.
+	Values [][]byte
+	TTLs   []time.Duration
 }
@@ -0,0 +1,638 @@
+// Package raft implements the Raft consensus algorithm. Nodes elect a leader, which appends entries
+// to its log and replicates them to the others. An entry is committed once the majority of nodes
+// has it and then it is applied to a state machine on every node in the same order, so the machines
+// stay the same as long as the majority is alive. The log is compacted with snapshots of the machine,
+// a node which falls behind the log kept gets the snapshot instead.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"time"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+const (
+	defaultHeartbeatInterval = 50 * time.Millisecond
+	defaultElectionTimeout   = 300 * time.Millisecond
+	defaultSnapshotThreshold = 1024
+
+	// maxAppendEntries limits the number of entries sent to a follower in a single request.
+	maxAppendEntries = 256
+	// snapshotChunk is the size of chunks a snapshot is sent to a follower in.
+	snapshotChunk = 256 << 10
+	// confirmPoll is how often a leader confirming its leadership checks the replies of its peers.
+	confirmPoll = time.Millisecond
+)
+
+var (
+	// ErrNotLeader returned when an entry is proposed to a node which is not the leader
+	// or is not ready to accept proposals yet.
+	ErrNotLeader = errors.New("not a leader")
+	// ErrLeadershipLost returned when a proposed entry is dropped as the leader is replaced.
+	// It might be committed by the new leader still.
+	ErrLeadershipLost = errors.New("leadership lost before the entry is applied")
+	// ErrStopped returned once a node is stopped.
+	ErrStopped = errors.New("raft node is stopped")
+)
+
+// Role is a role of a node in the cluster.
+type Role int
+
+// Supported roles.
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// Entry is a single entry of the log.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	// Data is nil for an entry a leader appends once elected, such entries are not applied.
+	Data []byte
+}
+
+// FSM is a state machine the log is applied to.
+type FSM interface {
+	// Apply applies a committed entry.
+	Apply(e *Entry)
+	// Snapshot returns the state as of the last entry applied. An error means the state
+	// could not be taken now, it is tried again after the following entries or by Node.Compact.
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with a snapshot.
+	Restore(data []byte) error
+}
+
+// Config configures a node.
+type Config struct {
+	// ID is an address of the node, peers send their requests to it.
+	ID string
+	// Peers are addresses of all the nodes of the cluster, it could include ID or not.
+	Peers []string
+	// Dir is where the node keeps its state between restarts, it is kept in memory only if empty.
+	Dir string
+	// HeartbeatInterval is how often a leader sends requests to followers with nothing else to send, default: 50ms.
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the minimum time a follower waits for a leader before starting an election,
+	// the actual one is random up to twice as long, default: 300ms.
+	ElectionTimeout time.Duration
+	// SnapshotThreshold is the number of entries applied after which the log is compacted, default: 1024.
+	SnapshotThreshold int
+	FSM               FSM
+	Transport         Transport
+}
+
+// Status is a state of a node.
+type Status struct {
+	Role Role
+	Term uint64
+	// Leader is an address of the leader known, empty during elections.
+	Leader  string
+	Commit  uint64
+	Applied uint64
+}
+
+// waiter is a proposal waiting for its entry to be applied.
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// Node is a member of a Raft cluster.
+type Node struct {
+	id    string
+	peers []string
+	cfg   Config
+	rpc   *rpc.Server
+
+	mu     sync.Mutex
+	role   Role
+	term   uint64
+	vote   string
+	leader string
+	log    *storage
+	commit uint64
+	// applied is the index of the last entry applied to the machine.
+	applied uint64
+	// deadline is the time an election starts at unless the leader is heard from before.
+	deadline time.Time
+	// next and match are kept by a leader for every peer: the index of the next entry to send
+	// and the index of the last entry known to be replicated.
+	next  map[string]uint64
+	match map[string]uint64
+	// contact is kept by a leader for every peer: the time it sent the last request the peer replied to.
+	contact map[string]time.Time
+	// ready is the index of the entry appended by a leader once elected, it accepts proposals
+	// only once the entry is applied, so everything committed before is applied as well.
+	ready uint64
+	// restoring is a snapshot waiting to be restored to the machine.
+	restoring *snapshot
+	// receiving is a snapshot a follower gets chunks of.
+	receiving *snapshot
+	waiters   map[uint64]*waiter
+	// applyMu is held while the machine is changed or its snapshot is taken, so the snapshot
+	// has exactly the entries up to applied.
+	applyMu sync.Mutex
+
+	applyc     chan struct{}
+	replicatec map[string]chan struct{}
+	quit       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Start starts a node with its state restored from cfg.Dir if there is any.
+func Start(cfg Config) (*Node, error) {
+	if cfg.FSM == nil || cfg.Transport == nil {
+		return nil, errors.New("raft: state machine and transport are required")
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = defaultElectionTimeout
+	}
+	if cfg.SnapshotThreshold <= 0 {
+		cfg.SnapshotThreshold = defaultSnapshotThreshold
+	}
+
+	st, term, vote, err := openStorage(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		id:         cfg.ID,
+		cfg:        cfg,
+		rpc:        rpc.NewServer(),
+		term:       term,
+		vote:       vote,
+		log:        st,
+		commit:     st.snap.index,
+		next:       make(map[string]uint64),
+		match:      make(map[string]uint64),
+		contact:    make(map[string]time.Time),
+		waiters:    make(map[uint64]*waiter),
+		applyc:     make(chan struct{}, 1),
+		replicatec: make(map[string]chan struct{}),
+		quit:       make(chan struct{}),
+	}
+
+	for _, p := range cfg.Peers {
+		if _, ok := n.replicatec[p]; ok || p == cfg.ID {
+			continue
+		}
+		n.peers = append(n.peers, p)
+		n.replicatec[p] = make(chan struct{}, 1)
+	}
+
+	if st.snap.index > 0 {
+		snap := st.snap
+		n.restoring = &snap
+		n.signalApply()
+	}
+
+	if err = n.rpc.RegisterName("Raft", &service{n: n}); err != nil {
+		st.close()
+		return nil, err
+	}
+
+	n.resetDeadline()
+
+	n.wg.Add(2 + len(n.peers))
+	go n.run()
+	go n.applier()
+	for _, p := range n.peers {
+		go n.replicator(p)
+	}
+
+	return n, nil
+}
+
+// Stop stops the node, proposals waiting for their entries get ErrStopped.
+func (n *Node) Stop() error {
+	close(n.quit)
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.failWaiters(ErrStopped)
+	return n.log.close()
+}
+
+// Status returns the current state of the node.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return Status{Role: n.role, Term: n.term, Leader: n.leader, Commit: n.commit, Applied: n.applied}
+}
+
+// Ready reports if the node is a leader which has applied every entry of its log,
+// so its machine has everything committed before.
+func (n *Node) Ready() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.role == Leader && n.applied >= n.ready && n.applied == n.log.lastIndex()
+}
+
+// ConfirmLeader waits until the majority of the cluster replies to requests the node sends as the leader
+// after the call, so no other leader could have entries committed before it. ErrNotLeader is returned
+// if the node is not the leader or doesn't hear from the majority within the election timeout.
+func (n *Node) ConfirmLeader() error {
+	start := time.Now()
+
+	n.mu.Lock()
+	term := n.term
+	n.replicateAll()
+	n.mu.Unlock()
+
+	for {
+		n.mu.Lock()
+		leader := n.role == Leader && n.term == term
+		confirmed := leader && n.inContact(start)
+		n.mu.Unlock()
+
+		if confirmed {
+			return nil
+		}
+		if !leader || time.Since(start) > n.cfg.ElectionTimeout {
+			return ErrNotLeader
+		}
+
+		select {
+		case <-n.quit:
+			return ErrStopped
+		case <-time.After(confirmPoll):
+		}
+	}
+}
+
+// Propose appends an entry with data to the log of a leader and returns its index along with
+// a channel receiving nil once it is applied or ErrLeadershipLost if another entry takes its place.
+func (n *Node) Propose(data []byte) (index uint64, done <-chan error, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role != Leader || n.applied < n.ready {
+		return 0, nil, ErrNotLeader
+	}
+
+	e := Entry{Index: n.log.lastIndex() + 1, Term: n.term, Data: data}
+	if err = n.log.append(e); err != nil {
+		log.Err("unable to append to raft log: %v", err)
+		return 0, nil, err
+	}
+
+	w := &waiter{term: e.Term, done: make(chan error, 1)}
+	n.waiters[e.Index] = w
+
+	n.advanceCommit()
+	n.replicateAll()
+
+	return e.Index, w.done, nil
+}
+
+// StepDown makes a leader a follower, so another node could be elected. It is meant for a leader
+// which entries are not committed in time, as it might not reach the majority anymore.
+func (n *Node) StepDown() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.role == Leader {
+		n.stepDown(n.term)
+		n.leader = ""
+	}
+}
+
+// run starts an election every time the leader is not heard from for too long. A leader which
+// doesn't hear from the majority for as long steps down, as another one might be elected already.
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	t := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-t.C:
+		}
+
+		n.mu.Lock()
+		switch {
+		case n.role != Leader && time.Now().After(n.deadline):
+			n.campaign()
+		case n.role == Leader && !n.inContact(time.Now().Add(-n.cfg.ElectionTimeout)):
+			log.Info("raft node %s lost contact with the majority", n.id)
+			n.stepDown(n.term)
+			n.leader = ""
+		}
+		n.mu.Unlock()
+	}
+}
+
+// campaign starts an election in the next term, the node votes for itself and asks the peers for votes.
+// It is called with mu held.
+func (n *Node) campaign() {
+	n.role = Candidate
+	n.term++
+	n.vote = n.id
+	n.leader = ""
+	n.saveState()
+	n.resetDeadline()
+
+	votes := 1
+	if n.quorum(votes) {
+		n.becomeLeader()
+		return
+	}
+
+	req := &VoteRequest{Term: n.term, Candidate: n.id, LastIndex: n.log.lastIndex(), LastTerm: n.log.lastTerm()}
+	for _, p := range n.peers {
+		go func(p string) {
+			resp, err := n.cfg.Transport.RequestVote(p, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+
+			if n.role != Candidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+
+			if votes++; n.quorum(votes) {
+				n.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+// becomeLeader makes a candidate the leader, it appends an empty entry of its term, as only
+// entries of the current term are committed by counting replicas. It is called with mu held.
+func (n *Node) becomeLeader() {
+	log.Info("raft node %s is the leader of term %d", n.id, n.term)
+
+	n.role = Leader
+	n.leader = n.id
+	for _, p := range n.peers {
+		n.next[p] = n.log.lastIndex() + 1
+		n.match[p] = 0
+		// The peers have just voted, so the leader has the majority to begin with.
+		n.contact[p] = time.Now()
+	}
+
+	e := Entry{Index: n.log.lastIndex() + 1, Term: n.term}
+	if err := n.log.append(e); err != nil {
+		log.Err("unable to append to raft log: %v", err)
+		n.stepDown(n.term)
+		return
+	}
+	n.ready = e.Index
+
+	n.advanceCommit()
+	n.replicateAll()
+}
+
+// stepDown makes the node a follower, of the term given if it is newer. It is called with mu held.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.vote = ""
+		n.saveState()
+	}
+
+	if n.role == Leader {
+		log.Info("raft node %s is not the leader anymore", n.id)
+		n.failWaiters(ErrLeadershipLost)
+	}
+
+	n.role = Follower
+	n.resetDeadline()
+}
+
+// inContact reports if the majority of the cluster replied to requests a leader sent after since.
+// It is called with mu held.
+func (n *Node) inContact(since time.Time) bool {
+	count := 1
+	for _, p := range n.peers {
+		if n.contact[p].After(since) {
+			count++
+		}
+	}
+
+	return n.quorum(count)
+}
+
+// quorum reports if votes are the majority of the cluster.
+func (n *Node) quorum(votes int) bool {
+	return votes > (len(n.peers)+1)/2
+}
+
+// advanceCommit commits the last entry of the current term which is replicated to the majority,
+// along with all the entries before it. It is called with mu held by a leader.
+func (n *Node) advanceCommit() {
+	for i := n.log.lastIndex(); i > n.commit; i-- {
+		if t, _ := n.log.term(i); t != n.term {
+			return
+		}
+
+		count := 1
+		for _, p := range n.peers {
+			if n.match[p] >= i {
+				count++
+			}
+		}
+
+		if n.quorum(count) {
+			n.commit = i
+			n.signalApply()
+			return
+		}
+	}
+}
+
+// saveState saves the current term and vote, it is called with mu held.
+func (n *Node) saveState() {
+	if err := n.log.saveState(n.term, n.vote); err != nil {
+		log.Err("unable to save raft state: %v", err)
+	}
+}
+
+// resetDeadline postpones the next election by a random timeout, it is called with mu held.
+func (n *Node) resetDeadline() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// failWaiters fails all the proposals waiting for their entries, it is called with mu held.
+func (n *Node) failWaiters(err error) {
+	for i, w := range n.waiters {
+		w.done <- err
+		delete(n.waiters, i)
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyc <- struct{}{}:
+	default:
+	}
+}
+
+// applier applies committed entries and snapshots received to the machine.
+func (n *Node) applier() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-n.applyc:
+		}
+
+		for n.applyNext() {
+		}
+	}
+}
+
+// applyNext restores a snapshot received or applies the entries committed since the last call,
+// it reports if there was anything to apply.
+func (n *Node) applyNext() bool {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if snap := n.restoring; snap != nil {
+		n.restoring = nil
+		n.mu.Unlock()
+
+		if err := n.cfg.FSM.Restore(snap.data); err != nil {
+			log.Err("unable to restore raft snapshot %d: %v", snap.index, err)
+			return false
+		}
+
+		n.mu.Lock()
+		if snap.index > n.applied {
+			n.applied = snap.index
+		}
+		for i, w := range n.waiters {
+			if i <= snap.index {
+				w.done <- ErrLeadershipLost
+				delete(n.waiters, i)
+			}
+		}
+		n.mu.Unlock()
+
+		return true
+	}
+
+	entries := n.log.slice(n.applied+1, n.commit+1)
+	n.mu.Unlock()
+
+	if len(entries) == 0 {
+		return false
+	}
+
+	for i := range entries {
+		if entries[i].Data != nil {
+			n.cfg.FSM.Apply(&entries[i])
+		}
+	}
+
+	// Proposals learn their entries are applied only after the snapshot is taken,
+	// so the machine has nothing else applied while it is.
+	n.maybeSnapshot(entries[len(entries)-1].Index)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, e := range entries {
+		if e.Index > n.applied {
+			n.applied = e.Index
+		}
+
+		if w, ok := n.waiters[e.Index]; ok {
+			delete(n.waiters, e.Index)
+			if w.term != e.Term {
+				w.done <- ErrLeadershipLost
+				continue
+			}
+			w.done <- nil
+		}
+	}
+
+	return true
+}
+
+// Compact compacts the log up to the entry applied last if enough entries are applied since the last
+// snapshot, as the node does after applying entries. It lets a machine which postponed its snapshot
+// have it taken as soon as it is able to.
+func (n *Node) Compact() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	applied := n.applied
+	n.mu.Unlock()
+
+	n.maybeSnapshot(applied)
+}
+
+// maybeSnapshot compacts the log up to the entry applied last if enough entries are applied since
+// the last snapshot. It is called with applyMu held.
+func (n *Node) maybeSnapshot(applied uint64) {
+	n.mu.Lock()
+	due := applied >= n.log.snap.index+uint64(n.cfg.SnapshotThreshold)
+	n.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	data, err := n.cfg.FSM.Snapshot()
+	if err != nil {
+		log.Info("raft snapshot is postponed: %v", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	term, ok := n.log.term(applied)
+	if !ok || applied <= n.log.snap.index {
+		return
+	}
+
+	if err = n.log.setSnapshot(snapshot{index: applied, term: term, data: data}); err != nil {
+		log.Err("unable to save raft snapshot: %v", err)
+	}
+}
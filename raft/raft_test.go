@@ -0,0 +1,527 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	leader := c.waitLeader(t)
+	st := leader.Status()
+
+	for _, n := range c.nodes {
+		s := n.Status()
+		if n != leader && s.Role != Follower {
+			t.Errorf("%s should be a follower, got %v", n.id, s.Role)
+		}
+		if s.Term != st.Term || s.Leader != leader.id {
+			t.Errorf("%s should follow %s in term %d, got %s in term %d", n.id, leader.id, st.Term, s.Leader, s.Term)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("entry%d", i))
+		c.propose(t, want[i])
+	}
+
+	c.waitApplied(t, want, c.nodes...)
+}
+
+func TestFailover(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	c.propose(t, "before")
+	old := c.waitLeader(t)
+	c.isolate(old.id, true)
+
+	leader := c.waitLeader(t)
+	if leader == old {
+		t.Fatal("isolated leader should be replaced")
+	}
+
+	// The old leader might not know it is replaced yet, but nothing it has could be committed.
+	if _, done, err := old.Propose([]byte("lost")); err == nil {
+		select {
+		case err = <-done:
+			if err == nil {
+				t.Fatal("isolated leader should not commit entries")
+			}
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	c.propose(t, "after")
+	c.isolate(old.id, false)
+
+	// The entry of the old leader is replaced by the ones of the new one.
+	c.waitApplied(t, []string{"before", "after"}, c.nodes...)
+	if s := old.Status(); s.Role == Leader {
+		t.Errorf("old leader should step down, got %v", s.Role)
+	}
+}
+
+func TestConfirmLeader(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	old := c.waitLeader(t)
+	checkErr(t, old.ConfirmLeader())
+
+	c.isolate(old.id, true)
+	if err := old.ConfirmLeader(); err != ErrNotLeader {
+		t.Errorf("isolated leader should not be confirmed, got: %v", err)
+	}
+
+	// It steps down by itself, as it doesn't hear from the majority.
+	for start := time.Now(); old.Status().Role == Leader; time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("isolated leader should step down")
+		}
+	}
+
+	leader := c.waitLeader(t)
+	checkErr(t, leader.ConfirmLeader())
+	if err := c.nodes[0].ConfirmLeader(); c.nodes[0] != leader && err != ErrNotLeader {
+		t.Errorf("follower should not be confirmed, got: %v", err)
+	}
+}
+
+func TestStepDown(t *testing.T) {
+	c := newCluster(t, 3, 0)
+
+	old := c.waitLeader(t)
+	old.StepDown()
+	if s := old.Status(); s.Role == Leader || s.Leader != "" {
+		t.Errorf("leader should step down, got %v following %q", s.Role, s.Leader)
+	}
+	if _, _, err := old.Propose([]byte("lost")); err != ErrNotLeader {
+		t.Errorf("proposals should be refused, got: %v", err)
+	}
+
+	c.propose(t, "after")
+	c.waitApplied(t, []string{"after"}, c.nodes...)
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	c := newCluster(t, 3, 5)
+
+	leader := c.waitLeader(t)
+	var lagging *Node
+	for _, n := range c.nodes {
+		if n != leader {
+			lagging = n
+			break
+		}
+	}
+	c.isolate(lagging.id, true)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("entry%d", i))
+		c.propose(t, want[i])
+	}
+
+	leader.mu.Lock()
+	compacted := leader.log.snap.index
+	leader.mu.Unlock()
+	if compacted == 0 {
+		t.Fatal("leader log should be compacted")
+	}
+
+	c.isolate(lagging.id, false)
+	c.waitApplied(t, want, lagging)
+
+	if c.machine(lagging.id).restored() == 0 {
+		t.Error("lagging node should get a snapshot")
+	}
+}
+
+func TestSnapshotChunks(t *testing.T) {
+	c := newCluster(t, 3, 5)
+
+	leader := c.waitLeader(t)
+	var lagging *Node
+	for _, n := range c.nodes {
+		if n != leader {
+			lagging = n
+			break
+		}
+	}
+	c.isolate(lagging.id, true)
+
+	// The snapshot of these takes a few chunks.
+	var want []string
+	for i := 0; i < 20; i++ {
+		want = append(want, fmt.Sprintf("entry%d-%s", i, strings.Repeat("a", snapshotChunk/8)))
+		c.propose(t, want[i])
+	}
+
+	c.isolate(lagging.id, false)
+	c.waitApplied(t, want, lagging)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chunks[lagging.id] < 2 {
+		t.Errorf("snapshot should be sent in chunks, got %d", c.chunks[lagging.id])
+	}
+}
+
+func TestRestart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "raft")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	c := newCluster(t, 1, 5, dir)
+
+	var want []string
+	for i := 0; i < 12; i++ {
+		want = append(want, fmt.Sprintf("entry%d", i))
+		c.propose(t, want[i])
+	}
+
+	term := c.nodes[0].Status().Term
+	c.restart(t, "node0")
+
+	c.waitApplied(t, want, c.nodes[0])
+	if n := c.machine("node0").restored(); n != 1 {
+		t.Errorf("snapshot should be restored once, got %d", n)
+	}
+	if st := c.nodes[0].Status(); st.Term <= term {
+		t.Errorf("term should be kept and advanced, got %d, was %d", st.Term, term)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "raft")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	st, _, _, err := openStorage(dir)
+	checkErr(t, err)
+
+	entries := []Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1, Data: []byte("a")}, {Index: 3, Term: 2, Data: []byte("b")}}
+	checkErr(t, st.append(entries...))
+	checkErr(t, st.truncate(3))
+	checkErr(t, st.append(Entry{Index: 3, Term: 3, Data: []byte("c")}, Entry{Index: 4, Term: 3, Data: []byte("d")}))
+	checkErr(t, st.saveState(3, "node1"))
+	checkErr(t, st.close())
+
+	// A torn tail is dropped on start.
+	f, err := os.OpenFile(dir+"/"+logName, os.O_WRONLY|os.O_APPEND, 0644)
+	checkErr(t, err)
+	_, err = f.Write((&Entry{Index: 5, Term: 3, Data: []byte("e")}).encode()[:10])
+	checkErr(t, err)
+	f.Close()
+
+	st, term, vote, err := openStorage(dir)
+	checkErr(t, err)
+
+	want := []Entry{entries[0], entries[1], {Index: 3, Term: 3, Data: []byte("c")}, {Index: 4, Term: 3, Data: []byte("d")}}
+	if !reflect.DeepEqual(st.entries, want) || term != 3 || vote != "node1" {
+		t.Fatalf("got entries %+v, term %d, vote %q, want: %+v, 3, node1", st.entries, term, vote, want)
+	}
+
+	checkErr(t, st.setSnapshot(snapshot{index: 2, term: 1, data: []byte("state")}))
+	checkErr(t, st.append(Entry{Index: 5, Term: 4, Data: []byte("e")}))
+	checkErr(t, st.close())
+
+	st, _, _, err = openStorage(dir)
+	checkErr(t, err)
+	defer st.close()
+
+	want = append(want[2:], Entry{Index: 5, Term: 4, Data: []byte("e")})
+	if !reflect.DeepEqual(st.entries, want) || st.snap.index != 2 || string(st.snap.data) != "state" {
+		t.Fatalf("got entries %+v, snapshot %+v, want: %+v after snapshot at 2", st.entries, st.snap, want)
+	}
+
+	if tm, ok := st.term(2); !ok || tm != 1 {
+		t.Errorf("term of the snapshot should be known, got %d, %v", tm, ok)
+	}
+	if _, ok := st.term(1); ok {
+		t.Error("term of a compacted entry should not be known")
+	}
+}
+
+// machine is a state machine keeping all the entries applied.
+type machine struct {
+	mu       sync.Mutex
+	entries  []string
+	restores int
+}
+
+func (m *machine) Apply(e *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, string(e.Data))
+}
+
+func (m *machine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return json.Marshal(m.entries)
+}
+
+func (m *machine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.restores++
+	m.entries = nil
+	return json.Unmarshal(data, &m.entries)
+}
+
+func (m *machine) restored() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.restores
+}
+
+func (m *machine) applied() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.entries...)
+}
+
+// cluster is a set of nodes connected in memory, a node could be isolated from the others.
+type cluster struct {
+	threshold int
+	// dirs are directories nodes keep their state in by their ids.
+	dirs map[string]string
+
+	mu       sync.Mutex
+	nodes    []*Node
+	byID     map[string]*Node
+	machines map[string]*machine
+	isolated map[string]bool
+	// chunks are numbers of snapshot chunks received by nodes.
+	chunks map[string]int
+}
+
+// newCluster starts size nodes with a snapshot threshold, the i-th one keeps its state in dirs[i] if any.
+func newCluster(t *testing.T, size, threshold int, dirs ...string) *cluster {
+	t.Helper()
+
+	c := &cluster{
+		threshold: threshold,
+		dirs:      make(map[string]string),
+		byID:      make(map[string]*Node),
+		machines:  make(map[string]*machine),
+		isolated:  make(map[string]bool),
+		chunks:    make(map[string]int),
+	}
+
+	for i, dir := range dirs {
+		c.dirs[fmt.Sprintf("node%d", i)] = dir
+	}
+
+	for i := 0; i < size; i++ {
+		c.start(t, fmt.Sprintf("node%d", i), size)
+	}
+
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+
+	return c
+}
+
+func (c *cluster) start(t *testing.T, id string, size int) *Node {
+	t.Helper()
+
+	peers := make([]string, size)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("node%d", i)
+	}
+
+	m := &machine{}
+	n, err := Start(Config{
+		ID:                id,
+		Peers:             peers,
+		Dir:               c.dirs[id],
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   50 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+		FSM:               m,
+		Transport:         &memTransport{c: c, from: id},
+	})
+	checkErr(t, err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, old := range c.nodes {
+		if old.id == id {
+			c.nodes = append(c.nodes[:i], c.nodes[i+1:]...)
+			break
+		}
+	}
+	c.nodes = append(c.nodes, n)
+	c.byID[id] = n
+	c.machines[id] = m
+
+	return n
+}
+
+// restart stops a node if it is running and starts it again with its state kept in c.dirs.
+func (c *cluster) restart(t *testing.T, id string) {
+	t.Helper()
+
+	c.mu.Lock()
+	n := c.byID[id]
+	size := len(c.nodes)
+	c.mu.Unlock()
+
+	if n != nil {
+		checkErr(t, n.Stop())
+	}
+	c.start(t, id, size)
+}
+
+func (c *cluster) machine(id string) *machine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.machines[id]
+}
+
+func (c *cluster) isolate(id string, isolated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.isolated[id] = isolated
+}
+
+func (c *cluster) peer(from, to string) (*Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isolated[from] || c.isolated[to] || c.byID[to] == nil {
+		return nil, errors.New("unreachable")
+	}
+
+	return c.byID[to], nil
+}
+
+// waitLeader returns a ready leader among the nodes which are not isolated.
+func (c *cluster) waitLeader(t *testing.T) *Node {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		c.mu.Lock()
+		nodes := append([]*Node(nil), c.nodes...)
+		isolated := make(map[string]bool, len(c.isolated))
+		for id, ok := range c.isolated {
+			isolated[id] = ok
+		}
+		c.mu.Unlock()
+
+		for _, n := range nodes {
+			if !isolated[n.id] && n.Ready() {
+				return n
+			}
+		}
+	}
+
+	t.Fatal("no leader elected")
+	return nil
+}
+
+// propose proposes an entry to the leader until it is applied.
+func (c *cluster) propose(t *testing.T, data string) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		_, done, err := c.waitLeader(t).Propose([]byte(data))
+		if err == nil {
+			if err = <-done; err == nil {
+				return
+			}
+		}
+	}
+
+	t.Fatalf("unable to commit %q", data)
+}
+
+// waitApplied waits until machines of nodes have want applied.
+func (c *cluster) waitApplied(t *testing.T, want []string, nodes ...*Node) {
+	t.Helper()
+
+	for _, n := range nodes {
+		m := c.machine(n.id)
+
+		var got []string
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+			if got = m.applied(); reflect.DeepEqual(got, want) {
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s should apply %q, got %q", n.id, want, got)
+		}
+	}
+}
+
+// memTransport delivers requests to nodes of a cluster directly.
+type memTransport struct {
+	c    *cluster
+	from string
+}
+
+func (t *memTransport) RequestVote(peer string, req *VoteRequest) (*VoteResponse, error) {
+	n, err := t.c.peer(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.RequestVote(req), nil
+}
+
+func (t *memTransport) AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error) {
+	n, err := t.c.peer(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.AppendEntries(req), nil
+}
+
+func (t *memTransport) InstallSnapshot(peer string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	n, err := t.c.peer(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.c.mu.Lock()
+	t.c.chunks[peer]++
+	t.c.mu.Unlock()
+
+	return n.InstallSnapshot(req), nil
+}
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
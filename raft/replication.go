@@ -0,0 +1,378 @@
+package raft
+
+import (
+	"time"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// VoteRequest is sent by a candidate asking for a vote.
+type VoteRequest struct {
+	Term      uint64
+	Candidate string
+	// LastIndex and LastTerm describe the last entry of the candidate log.
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+// VoteResponse is a reply to VoteRequest.
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest is sent by a leader with entries following the one at PrevIndex,
+// it has no entries when it is a heartbeat.
+type AppendRequest struct {
+	Term      uint64
+	Leader    string
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []Entry
+	// Commit is the commit index of the leader.
+	Commit uint64
+}
+
+// AppendResponse is a reply to AppendRequest. LastIndex is the last index of the follower log
+// the leader could continue from if it is not successful.
+type AppendResponse struct {
+	Term      uint64
+	Success   bool
+	LastIndex uint64
+}
+
+// SnapshotRequest is sent by a leader to a follower which needs entries compacted already.
+// The snapshot is sent in chunks, Data is the one starting at Offset, Done is set for the last one.
+type SnapshotRequest struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64
+	LastTerm  uint64
+	Offset    uint64
+	Data      []byte
+	Done      bool
+}
+
+// SnapshotResponse is a reply to SnapshotRequest, Success is not set if the chunk doesn't follow
+// the ones received before, so the leader starts over.
+type SnapshotResponse struct {
+	Term    uint64
+	Success bool
+}
+
+// replicateAll wakes up replicators of all the peers, it is called with mu held.
+func (n *Node) replicateAll() {
+	for _, p := range n.peers {
+		n.triggerReplication(p)
+	}
+}
+
+func (n *Node) triggerReplication(p string) {
+	select {
+	case n.replicatec[p] <- struct{}{}:
+	default:
+	}
+}
+
+// replicator sends entries to a peer while the node is the leader, as soon as there are new ones
+// or every heartbeat interval otherwise.
+func (n *Node) replicator(p string) {
+	defer n.wg.Done()
+
+	t := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-t.C:
+		case <-n.replicatec[p]:
+		}
+
+		n.replicate(p)
+	}
+}
+
+// replicate sends a single request with the next entries to a peer, or a snapshot
+// if the entries are compacted already.
+func (n *Node) replicate(p string) {
+	n.mu.Lock()
+	if n.role != Leader {
+		n.mu.Unlock()
+		return
+	}
+
+	term, next := n.term, n.next[p]
+	if next <= n.log.snap.index {
+		n.sendSnapshot(p)
+		return
+	}
+
+	prevTerm, _ := n.log.term(next - 1)
+	req := &AppendRequest{
+		Term:      term,
+		Leader:    n.id,
+		PrevIndex: next - 1,
+		PrevTerm:  prevTerm,
+		Entries:   n.log.slice(next, next+maxAppendEntries),
+		Commit:    n.commit,
+	}
+	sent := time.Now()
+	n.mu.Unlock()
+
+	resp, err := n.cfg.Transport.AppendEntries(p, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return
+	}
+	if n.role != Leader || n.term != term {
+		return
+	}
+	n.contact[p] = sent
+
+	if !resp.Success {
+		// The peer misses entries or has conflicting ones, the leader goes back to find where their logs match.
+		next = req.PrevIndex
+		if resp.LastIndex+1 < next {
+			next = resp.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.next[p] = next
+		n.triggerReplication(p)
+		return
+	}
+
+	if m := req.PrevIndex + uint64(len(req.Entries)); m > n.match[p] {
+		n.match[p] = m
+	}
+	n.next[p] = n.match[p] + 1
+	n.advanceCommit()
+
+	if n.next[p] <= n.log.lastIndex() {
+		n.triggerReplication(p)
+	}
+}
+
+// sendSnapshot sends the snapshot to a peer chunk by chunk, so every request is small enough
+// to be sent in time whatever the size of the snapshot. It is called with mu held and releases it.
+func (n *Node) sendSnapshot(p string) {
+	term, snap := n.term, n.log.snap
+	n.mu.Unlock()
+
+	for off := 0; ; off += snapshotChunk {
+		end := off + snapshotChunk
+		if end > len(snap.data) {
+			end = len(snap.data)
+		}
+
+		req := &SnapshotRequest{
+			Term:      term,
+			Leader:    n.id,
+			LastIndex: snap.index,
+			LastTerm:  snap.term,
+			Offset:    uint64(off),
+			Data:      snap.data[off:end],
+			Done:      end == len(snap.data),
+		}
+		sent := time.Now()
+
+		resp, err := n.cfg.Transport.InstallSnapshot(p, req)
+		if err != nil {
+			return
+		}
+
+		if !n.snapshotSent(p, term, sent, req, resp) {
+			return
+		}
+	}
+}
+
+// snapshotSent handles a reply to a chunk of the snapshot sent to a peer and reports
+// if the next one should be sent.
+func (n *Node) snapshotSent(p string, term uint64, sent time.Time, req *SnapshotRequest, resp *SnapshotResponse) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false
+	}
+	if n.role != Leader || n.term != term {
+		return false
+	}
+	n.contact[p] = sent
+
+	if !resp.Success {
+		return false
+	}
+	if !req.Done {
+		return true
+	}
+
+	if req.LastIndex > n.match[p] {
+		n.match[p] = req.LastIndex
+	}
+	n.next[p] = n.match[p] + 1
+	n.triggerReplication(p)
+
+	return false
+}
+
+// RequestVote handles a request of a candidate, the vote is granted once per term
+// to a candidate which log is at least as up to date as the one of the node.
+func (n *Node) RequestVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+
+	resp := &VoteResponse{Term: n.term}
+	if req.Term < n.term || (n.vote != "" && n.vote != req.Candidate) {
+		return resp
+	}
+
+	lastTerm := n.log.lastTerm()
+	if req.LastTerm < lastTerm || (req.LastTerm == lastTerm && req.LastIndex < n.log.lastIndex()) {
+		return resp
+	}
+
+	n.vote = req.Candidate
+	n.saveState()
+	n.resetDeadline()
+	resp.Granted = true
+
+	return resp
+}
+
+// AppendEntries handles a request of a leader, entries conflicting with the ones received
+// are replaced by them.
+func (n *Node) AppendEntries(req *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &AppendResponse{Term: n.term, LastIndex: n.log.lastIndex()}
+	}
+	n.follow(req.Term, req.Leader)
+
+	resp := &AppendResponse{Term: n.term, LastIndex: n.log.lastIndex()}
+	prev, prevTerm, entries := req.PrevIndex, req.PrevTerm, req.Entries
+
+	// Entries compacted into the snapshot are committed, so they match the ones of the leader.
+	if prev < n.log.snap.index {
+		skip := n.log.snap.index - prev
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		prev, prevTerm, entries = n.log.snap.index, n.log.snap.term, entries[skip:]
+	}
+
+	if prev > n.log.lastIndex() {
+		return resp
+	}
+	if t, _ := n.log.term(prev); t != prevTerm {
+		resp.LastIndex = prev - 1
+		return resp
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.log.lastIndex() {
+			if t, _ := n.log.term(e.Index); t == e.Term {
+				continue
+			}
+			if err := n.log.truncate(e.Index); err != nil {
+				log.Err("unable to truncate raft log: %v", err)
+				return resp
+			}
+		}
+
+		if err := n.log.append(entries[i:]...); err != nil {
+			log.Err("unable to append to raft log: %v", err)
+			return resp
+		}
+		break
+	}
+
+	resp.Success = true
+	resp.LastIndex = n.log.lastIndex()
+
+	// Entries past the ones received might be stale yet, they are not committed.
+	commit := req.Commit
+	if last := req.PrevIndex + uint64(len(req.Entries)); commit > last {
+		commit = last
+	}
+	if commit > n.commit {
+		n.commit = commit
+		n.signalApply()
+	}
+
+	return resp
+}
+
+// InstallSnapshot handles a chunk of a snapshot of a leader. Once all of them are received the snapshot
+// replaces the machine state along with the log unless the log has more entries following the snapshot.
+func (n *Node) InstallSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &SnapshotResponse{Term: n.term}
+	}
+	n.follow(req.Term, req.Leader)
+
+	if req.Offset == 0 {
+		n.receiving = &snapshot{index: req.LastIndex, term: req.LastTerm}
+	}
+
+	r := n.receiving
+	if r == nil || r.index != req.LastIndex || r.term != req.LastTerm || uint64(len(r.data)) != req.Offset {
+		n.receiving = nil
+		return &SnapshotResponse{Term: n.term}
+	}
+	r.data = append(r.data, req.Data...)
+
+	if !req.Done {
+		return &SnapshotResponse{Term: n.term, Success: true}
+	}
+	n.receiving = nil
+
+	if req.LastIndex <= n.log.snap.index || req.LastIndex <= n.applied {
+		return &SnapshotResponse{Term: n.term, Success: true}
+	}
+
+	snap := *r
+	if err := n.log.setSnapshot(snap); err != nil {
+		log.Err("unable to save raft snapshot: %v", err)
+		return &SnapshotResponse{Term: n.term}
+	}
+
+	n.restoring = &snap
+	if n.commit < snap.index {
+		n.commit = snap.index
+	}
+	n.signalApply()
+
+	return &SnapshotResponse{Term: n.term, Success: true}
+}
+
+// follow makes the node a follower of the leader of term, it is called with mu held.
+func (n *Node) follow(term uint64, leader string) {
+	if term > n.term || n.role != Follower {
+		n.stepDown(term)
+	}
+
+	n.leader = leader
+	n.resetDeadline()
+}
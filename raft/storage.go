@@ -0,0 +1,367 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+const (
+	stateName    = "raft.state"
+	logName      = "raft.log"
+	snapshotName = "raft.snapshot"
+
+	// entryHeader is the size of an entry header in the log file: crc, data length, term and index.
+	entryHeader = 24
+)
+
+/*
+Files kept in dir, numbers are big endian:
+
+	raft.state: term(8 bytes) vote(the rest, an id of the node voted for, empty for none)
+	raft.snapshot: crc32(4 bytes, of everything after it) index(8 bytes) term(8 bytes) data
+	raft.log: entries one after another, each of them is
+		crc32(4 bytes, of everything after len(data)) len(data)(4 bytes) term(8 bytes) index(8 bytes) data
+
+The state and the snapshot are replaced with a temporary file renamed over them. Entries are appended
+to the log and synced, the log is replaced the same way once it is compacted.
+*/
+
+// errBadEntry returned when an entry in the log file is malformed or corrupted.
+var errBadEntry = errors.New("bad log entry")
+
+// snapshot is a state of the machine with all the entries up to index applied, the last one of them has term.
+type snapshot struct {
+	index uint64
+	term  uint64
+	data  []byte
+}
+
+// storage keeps the state of a node which should survive its restarts: the current term, the vote
+// given in it, the log and the latest snapshot. Everything is kept in memory only if dir is empty.
+type storage struct {
+	dir  string
+	snap snapshot
+	// entries follow the snapshot, the first one has index snap.index+1.
+	entries []Entry
+	// offsets are positions of entries in the log file.
+	offsets []int64
+	f       *os.File
+	size    int64
+}
+
+// openStorage opens the storage in dir and returns the term and vote saved, or creates an empty one.
+// A torn or corrupted tail of the log left after a crash is truncated.
+func openStorage(dir string) (st *storage, term uint64, vote string, err error) {
+	st = &storage{dir: dir}
+	if dir == "" {
+		return st, 0, "", nil
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, "", err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateName))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, 0, "", err
+	case len(b) < 8:
+		return nil, 0, "", fmt.Errorf("malformed raft state in %s", dir)
+	default:
+		term, vote = binary.BigEndian.Uint64(b), string(b[8:])
+	}
+
+	if err = st.readSnapshot(); err != nil {
+		return nil, 0, "", err
+	}
+
+	if err = st.openLog(); err != nil {
+		return nil, 0, "", err
+	}
+
+	return st, term, vote, nil
+}
+
+func (s *storage) readSnapshot() error {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(b) < 20 || crc32.ChecksumIEEE(b[4:]) != binary.BigEndian.Uint32(b) {
+		return fmt.Errorf("corrupted raft snapshot in %s", s.dir)
+	}
+
+	s.snap = snapshot{
+		index: binary.BigEndian.Uint64(b[4:]),
+		term:  binary.BigEndian.Uint64(b[12:]),
+		data:  b[20:],
+	}
+
+	return nil
+}
+
+func (s *storage) openLog() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, logName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+
+	r := bufio.NewReader(f)
+	for {
+		e, n, err := readEntry(r)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			log.Err("corrupted raft log tail at %d, truncating: %v", s.size, err)
+			return f.Truncate(s.size)
+		}
+
+		// Entries up to the snapshot are left if a node stopped while compacting the log.
+		if e.Index > s.snap.index {
+			if e.Index != s.lastIndex()+1 {
+				return fmt.Errorf("raft log in %s misses entries before %d", s.dir, e.Index)
+			}
+			s.entries = append(s.entries, e)
+			s.offsets = append(s.offsets, s.size)
+		}
+
+		s.size += int64(n)
+	}
+}
+
+// readEntry reads an entry of the log file and returns it along with its size.
+func readEntry(r io.Reader) (e Entry, n int, err error) {
+	var h [entryHeader]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errBadEntry
+		}
+		return e, 0, err
+	}
+
+	// The data is read as it comes, so a corrupted length doesn't allocate more than there is to read.
+	size := int64(binary.BigEndian.Uint32(h[4:]))
+	data, err := ioutil.ReadAll(io.LimitReader(r, size))
+	if err != nil || int64(len(data)) != size {
+		return e, 0, errBadEntry
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(h[8:])
+	crc.Write(data)
+	if crc.Sum32() != binary.BigEndian.Uint32(h[:]) {
+		return e, 0, errBadEntry
+	}
+
+	e.Term = binary.BigEndian.Uint64(h[8:])
+	e.Index = binary.BigEndian.Uint64(h[16:])
+	if len(data) > 0 {
+		e.Data = data
+	}
+
+	return e, entryHeader + len(data), nil
+}
+
+// encode returns the entry in the log file format.
+func (e *Entry) encode() []byte {
+	b := make([]byte, entryHeader+len(e.Data))
+	binary.BigEndian.PutUint32(b[4:], uint32(len(e.Data)))
+	binary.BigEndian.PutUint64(b[8:], e.Term)
+	binary.BigEndian.PutUint64(b[16:], e.Index)
+	copy(b[entryHeader:], e.Data)
+	binary.BigEndian.PutUint32(b, crc32.ChecksumIEEE(b[8:]))
+
+	return b
+}
+
+// saveState saves the current term and the vote given in it.
+func (s *storage) saveState(term uint64, vote string) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	b := make([]byte, 8, 8+len(vote))
+	binary.BigEndian.PutUint64(b, term)
+
+	return writeFile(filepath.Join(s.dir, stateName), append(b, vote...))
+}
+
+func (s *storage) lastIndex() uint64 {
+	return s.snap.index + uint64(len(s.entries))
+}
+
+func (s *storage) lastTerm() uint64 {
+	if len(s.entries) == 0 {
+		return s.snap.term
+	}
+
+	return s.entries[len(s.entries)-1].Term
+}
+
+// term returns the term of the entry at index i, false if there is no such entry
+// or it is compacted into the snapshot.
+func (s *storage) term(i uint64) (uint64, bool) {
+	switch {
+	case i == s.snap.index:
+		return s.snap.term, true
+	case i < s.snap.index || i > s.lastIndex():
+		return 0, false
+	}
+
+	return s.entries[i-s.snap.index-1].Term, true
+}
+
+// slice returns a copy of the entries from index from up to to, excluding it.
+func (s *storage) slice(from, to uint64) []Entry {
+	if from <= s.snap.index {
+		from = s.snap.index + 1
+	}
+	if last := s.lastIndex(); to > last+1 {
+		to = last + 1
+	}
+	if from >= to {
+		return nil
+	}
+
+	return append([]Entry(nil), s.entries[from-s.snap.index-1:to-s.snap.index-1]...)
+}
+
+// append adds entries to the end of the log, they are synced to disk before it returns.
+func (s *storage) append(entries ...Entry) error {
+	if s.f != nil {
+		var b []byte
+		offsets := make([]int64, len(entries))
+		for i := range entries {
+			offsets[i] = s.size + int64(len(b))
+			b = append(b, entries[i].encode()...)
+		}
+
+		if _, err := s.f.Write(b); err != nil {
+			// Whatever is written partially is dropped on the next start.
+			return err
+		}
+		if err := s.f.Sync(); err != nil {
+			return err
+		}
+
+		s.size += int64(len(b))
+		s.offsets = append(s.offsets, offsets...)
+	}
+
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+// truncate removes the entries starting from index from.
+func (s *storage) truncate(from uint64) error {
+	i := from - s.snap.index - 1
+	if s.f != nil {
+		if err := s.f.Truncate(s.offsets[i]); err != nil {
+			return err
+		}
+		s.size = s.offsets[i]
+		s.offsets = s.offsets[:i]
+	}
+
+	s.entries = s.entries[:i]
+	return nil
+}
+
+// setSnapshot replaces the snapshot, the entries it covers are removed. The entries following it are kept
+// only if the log has the last entry of the snapshot, otherwise the log is left empty.
+func (s *storage) setSnapshot(snap snapshot) error {
+	var keep []Entry
+	if t, ok := s.term(snap.index); ok && t == snap.term {
+		keep = s.slice(snap.index+1, s.lastIndex()+1)
+	}
+
+	if s.dir == "" {
+		s.snap, s.entries = snap, keep
+		return nil
+	}
+
+	b := make([]byte, 20, 20+len(snap.data))
+	binary.BigEndian.PutUint64(b[4:], snap.index)
+	binary.BigEndian.PutUint64(b[12:], snap.term)
+	b = append(b, snap.data...)
+	binary.BigEndian.PutUint32(b, crc32.ChecksumIEEE(b[4:]))
+
+	if err := writeFile(filepath.Join(s.dir, snapshotName), b); err != nil {
+		return err
+	}
+	s.snap = snap
+
+	// The log is rewritten with the entries kept, the ones left there on failures are skipped on start.
+	var lb []byte
+	offsets := make([]int64, len(keep))
+	for i := range keep {
+		offsets[i] = int64(len(lb))
+		lb = append(lb, keep[i].encode()...)
+	}
+
+	path := filepath.Join(s.dir, logName)
+	if err := writeFile(path, lb); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.f.Close()
+	s.f, s.size, s.entries, s.offsets = f, int64(len(lb)), keep, offsets
+	return nil
+}
+
+func (s *storage) close() error {
+	if s.f == nil {
+		return nil
+	}
+
+	return s.f.Close()
+}
+
+// writeFile writes b into a temporary file and moves it to the path once synced,
+// so the previous file stays intact on failures.
+func writeFile(path string, b []byte) error {
+	tmpPath := path + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
@@ -0,0 +1,44 @@
+package raft
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCorruptEntryLength(t *testing.T) {
+	dir, err := os.MkdirTemp("", "raft")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	st, _, _, err := openStorage(dir)
+	checkErr(t, err)
+	checkErr(t, st.append(Entry{Index: 1, Term: 1, Data: []byte("a")}))
+	checkErr(t, st.close())
+
+	// An entry claiming almost 4 GiB of data, which is not there.
+	b := (&Entry{Index: 2, Term: 1, Data: []byte("b")}).encode()
+	binary.BigEndian.PutUint32(b[4:], 0xfffffff0)
+
+	path := filepath.Join(dir, logName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	checkErr(t, err)
+	_, err = f.Write(b)
+	checkErr(t, err)
+	f.Close()
+
+	st, _, _, err = openStorage(dir)
+	checkErr(t, err)
+	defer st.close()
+
+	if want := []Entry{{Index: 1, Term: 1, Data: []byte("a")}}; !reflect.DeepEqual(st.entries, want) {
+		t.Errorf("entry with corrupted length should be dropped, got %+v", st.entries)
+	}
+	info, err := os.Stat(path)
+	checkErr(t, err)
+	if info.Size() != st.size {
+		t.Errorf("log should be truncated to %d, got %d", st.size, info.Size())
+	}
+}
@@ -0,0 +1,164 @@
+package raft
+
+import (
+	"errors"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrTimeout returned when a peer doesn't reply in time.
+var ErrTimeout = errors.New("raft request timed out")
+
+// Transport sends requests to peers.
+type Transport interface {
+	RequestVote(peer string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(peer string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// service exposes request handlers of a node to net/rpc.
+type service struct {
+	n *Node
+}
+
+func (s *service) RequestVote(req *VoteRequest, resp *VoteResponse) error {
+	*resp = *s.n.RequestVote(req)
+	return nil
+}
+
+func (s *service) AppendEntries(req *AppendRequest, resp *AppendResponse) error {
+	*resp = *s.n.AppendEntries(req)
+	return nil
+}
+
+func (s *service) InstallSnapshot(req *SnapshotRequest, resp *SnapshotResponse) error {
+	*resp = *s.n.InstallSnapshot(req)
+	return nil
+}
+
+// ServeConn handles requests of a peer connected with TCPTransport until the connection is closed.
+func (n *Node) ServeConn(conn io.ReadWriteCloser) {
+	n.rpc.ServeConn(conn)
+}
+
+// TCPTransport sends requests with net/rpc over connections made by dial, a single one for every peer.
+type TCPTransport struct {
+	dial    func(addr string) (io.ReadWriteCloser, error)
+	timeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+	closed  bool
+}
+
+// NewTCPTransport returns a transport connecting to peers with dial, requests fail
+// with ErrTimeout if a peer doesn't reply within timeout.
+func NewTCPTransport(dial func(addr string) (io.ReadWriteCloser, error), timeout time.Duration) *TCPTransport {
+	return &TCPTransport{
+		dial:    dial,
+		timeout: timeout,
+		clients: make(map[string]*rpc.Client),
+	}
+}
+
+// RequestVote implements Transport.
+func (t *TCPTransport) RequestVote(peer string, req *VoteRequest) (*VoteResponse, error) {
+	resp := &VoteResponse{}
+	return resp, t.call(peer, "Raft.RequestVote", req, resp)
+}
+
+// AppendEntries implements Transport.
+func (t *TCPTransport) AppendEntries(peer string, req *AppendRequest) (*AppendResponse, error) {
+	resp := &AppendResponse{}
+	return resp, t.call(peer, "Raft.AppendEntries", req, resp)
+}
+
+// InstallSnapshot implements Transport.
+func (t *TCPTransport) InstallSnapshot(peer string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := &SnapshotResponse{}
+	return resp, t.call(peer, "Raft.InstallSnapshot", req, resp)
+}
+
+// Close closes connections to all the peers, requests fail afterwards.
+func (t *TCPTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for p, c := range t.clients {
+		c.Close()
+		delete(t.clients, p)
+	}
+}
+
+// call sends a request to a peer, the connection is dropped on errors and made again by the next call.
+func (t *TCPTransport) call(peer, method string, req, resp interface{}) error {
+	c, err := t.client(peer)
+	if err != nil {
+		return err
+	}
+
+	call := c.Go(method, req, resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-time.After(t.timeout):
+		err = ErrTimeout
+	}
+
+	if err != nil {
+		t.drop(peer, c)
+	}
+
+	return err
+}
+
+func (t *TCPTransport) client(peer string) (*rpc.Client, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrStopped
+	}
+	c, ok := t.clients[peer]
+	t.mu.Unlock()
+
+	if ok {
+		return c, nil
+	}
+
+	conn, err := t.dial(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		conn.Close()
+		return nil, ErrStopped
+	}
+
+	// Another call might have connected meanwhile.
+	if c, ok = t.clients[peer]; ok {
+		conn.Close()
+		return c, nil
+	}
+
+	c = rpc.NewClient(conn)
+	t.clients[peer] = c
+
+	return c, nil
+}
+
+func (t *TCPTransport) drop(peer string, c *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[peer] == c {
+		delete(t.clients, peer)
+	}
+	c.Close()
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/raft"
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+const (
+	raftDir = "raft"
+	// proposeTimeout is how long a command waits for the cluster to elect a leader or commit its entry.
+	// The leader serves no other commands meanwhile, so it bounds how long they wait for a write as well.
+	proposeTimeout = 5 * time.Second
+	// readyPoll is how often a leader is checked to be ready for commands.
+	readyPoll = 5 * time.Millisecond
+	// nodeTimeout is how long a node waits for a reply of another one, snapshots are sent in chunks to fit it.
+	nodeTimeout = time.Second
+)
+
+var (
+	// ErrNotLeader returned for commands working with data sent to a node of a cluster which is not its leader.
+	ErrNotLeader = errors.New("not a cluster leader")
+	// ErrClusterTimeout returned when a write is not committed in time. Its outcome is unknown rather than
+	// a failure, as it might be committed later still, so it should be read again to find out.
+	ErrClusterTimeout = errors.New("cluster write timed out, outcome unknown")
+	// errWriteAhead returned by a snapshot of a leader which has a write applied before its entry is.
+	errWriteAhead = errors.New("write in progress")
+)
+
+// cluster keeps databases of a server the same as the ones of other nodes with a Raft log.
+// Commands are served by the leader one by one. It runs every command in a store transaction holding
+// its keys and proposes records of its effects as a single entry, the transaction is committed once
+// the entry is applied and rolled back otherwise. So writeMu and the keys are held for a round trip
+// to the majority of nodes, up to proposeTimeout. Followers apply the records of every entry committed,
+// so each node has the same effects regardless of its clock or versions of its keys.
+type cluster struct {
+	s         *server
+	node      *raft.Node
+	transport *raft.TCPTransport
+
+	mu sync.Mutex
+	// ahead is set while the leader has a write applied before its entry, until the store transaction
+	// is committed or rolled back. proposed is set while the entry identified by nonce is not applied yet.
+	ahead    bool
+	proposed bool
+	nonce    uint64
+}
+
+// newCluster starts a Raft node at addr of a cluster of peers, its state is kept in dir.
+func newCluster(s *server, addr string, peers []string, dir string, threshold int) (*cluster, error) {
+	for _, db := range s.dbs {
+		if _, ok := db.store.(store.Transactor); !ok {
+			return nil, fmt.Errorf("cluster node requires a store with transactions: %v", proto.ErrUnsupportedCmd)
+		}
+	}
+
+	c := &cluster{s: s, transport: raft.NewTCPTransport(dialNode, nodeTimeout)}
+
+	node, err := raft.Start(raft.Config{
+		ID:                addr,
+		Peers:             peers,
+		Dir:               filepath.Join(dir, raftDir),
+		SnapshotThreshold: threshold,
+		FSM:               c,
+		Transport:         c.transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.node = node
+
+	return c, nil
+}
+
+func (c *cluster) stop() error {
+	c.transport.Close()
+	return c.node.Stop()
+}
+
+// Apply implements raft.FSM, an entry of a write applied by the leader already is skipped.
+func (c *cluster) Apply(e *raft.Entry) {
+	if len(e.Data) < 8 {
+		log.Err("malformed raft entry %d", e.Index)
+		return
+	}
+
+	c.mu.Lock()
+	own := c.proposed && c.nonce == binary.BigEndian.Uint64(e.Data)
+	if own {
+		c.proposed = false
+	}
+	c.mu.Unlock()
+
+	if own {
+		return
+	}
+
+	if err := c.s.applyStream(wal.NewDecoder(bytes.NewReader(e.Data[8:]), -1), false, nil); err != io.EOF {
+		log.Err("unable to apply raft entry %d: %v", e.Index, err)
+	}
+}
+
+// Snapshot implements raft.FSM, it is postponed while a write is applied before its entry,
+// as the store has the keys of the write held until it is committed. The leader applies all its writes
+// so, then its snapshot is taken once a write ends instead, see end.
+func (c *cluster) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ahead {
+		return nil, errWriteAhead
+	}
+
+	snaps, err := c.s.snapshotters()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err = dump(&b, snaps, nil); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Restore implements raft.FSM.
+func (c *cluster) Restore(data []byte) error {
+	if err := c.s.applyStream(wal.NewDecoder(bytes.NewReader(data), -1), false, nil); err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// waitReady waits until the node is the leader ready for commands, ErrNotLeader is returned
+// if it is not the leader or doesn't get ready in time.
+func (c *cluster) waitReady() error {
+	for start := time.Now(); !c.node.Ready(); time.Sleep(readyPoll) {
+		if c.node.Status().Role != raft.Leader || time.Since(start) > proposeTimeout {
+			return ErrNotLeader
+		}
+	}
+
+	return nil
+}
+
+// confirm makes sure the node is still the leader before it answers a command reading data, as another one
+// might be elected without it knowing yet and have writes it misses.
+func (c *cluster) confirm() error {
+	if err := c.node.ConfirmLeader(); err != raft.ErrNotLeader {
+		return err
+	}
+
+	return ErrNotLeader
+}
+
+// begin waits until the node is ready for a command, which is applied before its entry.
+// It is called with writeMu held.
+func (c *cluster) begin() error {
+	if err := c.waitReady(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ahead, c.proposed = true, false
+	return nil
+}
+
+// end marks the command started by begin as done, once the store transaction is committed or rolled back.
+// A snapshot postponed by the command is taken then. It is called with writeMu held.
+func (c *cluster) end() {
+	c.mu.Lock()
+	c.ahead, c.proposed = false, false
+	c.mu.Unlock()
+
+	c.node.Compact()
+}
+
+// replicate proposes records as an entry and waits until it is applied. The entry data is a random 8 byte
+// nonce followed by the records encoded by wal.Encoder. The records of a write applied already are not
+// applied again. If it fails the entry might be committed later by another leader still,
+// then it is applied as any other one.
+func (c *cluster) replicate(applied bool, recs ...*wal.Record) error {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	if applied {
+		c.mu.Lock()
+		c.proposed, c.nonce = true, binary.BigEndian.Uint64(nonce[:])
+		c.mu.Unlock()
+	}
+
+	_, done, err := c.node.Propose(append(nonce[:], wal.NewEncoder(-1).Encode(recs...)...))
+	if err == raft.ErrNotLeader {
+		return ErrNotLeader
+	}
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-done:
+	case <-time.After(proposeTimeout):
+		// The leader might have lost the majority, so it steps down rather than having every
+		// following command wait as long.
+		c.node.StepDown()
+		err = ErrClusterTimeout
+	}
+
+	if err == nil || !applied {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The entry is applied right now, so the write is kept.
+	if !c.proposed {
+		return nil
+	}
+
+	// Otherwise it is applied again if committed later, as the write is rolled back.
+	c.proposed = false
+	return err
+}
+
+// clusterRequest handles a command r working with data of a connection c on the leader of the cluster.
+func (s *server) clusterRequest(c *session, r *proto.Req) ([]byte, error) {
+	switch r.Cmd {
+	case proto.CmdKeys, proto.CmdScan:
+		if err := s.cluster.waitReady(); err != nil {
+			return nil, err
+		}
+
+		var v []byte
+		var err error
+		if r.Cmd == proto.CmdKeys {
+			v, err = proto.Encode(s.keys(c.db, r.Key))
+		} else {
+			v, err = s.scan(c.db, r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = s.cluster.confirm(); err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	case proto.CmdFlushDB:
+		// A flush holds every key, so it is not applied before its entry, but as the followers do.
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		if err := s.cluster.waitReady(); err != nil {
+			return nil, err
+		}
+		return nil, s.cluster.replicate(false, &wal.Record{Op: wal.OpFlush, DB: c.db.index})
+	}
+
+	results, err := s.transaction(c.db, []*proto.Req{r}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// clustered reports if a command works with data, so it is handled by the leader of a cluster.
+func clustered(cmd byte) bool {
+	switch cmd {
+	case proto.CmdMGet, proto.CmdMSet, proto.CmdMDel, proto.CmdKeys, proto.CmdScan, proto.CmdFlushDB:
+		return true
+	}
+
+	return queueable(cmd)
+}
+
+// dialNode connects to a node of the cluster at addr and switches the connection to Raft requests,
+// which are net/rpc calls once the node replies to RAFT command with no error.
+func dialNode(addr string) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", addr, nodeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := proto.NewMessage(proto.CmdRaft, "", nil, 0)
+	if err == nil {
+		_, err = conn.Write(msg)
+	}
+
+	r := bufio.NewReader(conn)
+	if err == nil {
+		err = readReply(r)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &bufConn{r: r, Conn: conn}, nil
+}
+
+// readReply reads a reply to a command, which has no result but could be an error.
+func readReply(r *bufio.Reader) error {
+	b, err := r.ReadBytes(proto.CR)
+	if err != nil {
+		return err
+	}
+
+	val, err := proto.NewDecoder().Decode(proto.NewScanner(bytes.NewReader(b)))
+	if err != nil {
+		return err
+	}
+	if err, ok := val.(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// serveNode handles Raft requests of another node of the cluster until either of them stops.
+func (s *server) serveNode(buf *bufio.Reader, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-s.closing:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	s.cluster.node.ServeConn(&bufConn{r: buf, Conn: conn})
+}
+
+// bufConn is a connection which reads through a buffer.
+type bufConn struct {
+	r *bufio.Reader
+	net.Conn
+}
+
+func (c *bufConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
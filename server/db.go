@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+)
+
+// ErrBadDB returned when a database index is out of range.
+var ErrBadDB = errors.New("database index out of range")
+
+// database is a separate keyspace with its own store.
+type database struct {
+	index int
+	store store.Store
+	// pending collects write-ahead log records of a transaction, it is set for a database used by one.
+	pending *batch
+}
+
+// session keeps the state of a client connection.
+type session struct {
+	// db is the database selected by the connection.
+	db *database
+	// multi is set once a transaction is started, the following commands are queued until EXEC.
+	multi bool
+	queue []*proto.Req
+	// watched keeps versions of watched keys as they were when WATCH was sent.
+	watched map[string]uint64
+	// events is set once the connection subscribes to changes of keys.
+	events *subscription
+	// sub is set once the connection subscribes to channels, see subscribe.
+	sub *subscriber
+	// feed is set once the connection is a replica, see sync.
+	feed *feed
+	// raft is set once the connection is made by another node of a cluster, see serveNode.
+	raft bool
+}
+
+// newDatabases returns n databases as configured by options o.
+func newDatabases(st storeType, bs int, o *options) ([]*database, error) {
+	if o.databases < 1 {
+		return nil, fmt.Errorf("at least one database is required, got %d", o.databases)
+	}
+
+	dbs := make([]*database, o.databases)
+	for i := range dbs {
+		db, err := newStore(st, bs, dbDir(o.dir, i), o)
+		if err != nil {
+			closeDatabases(dbs[:i])
+			return nil, err
+		}
+
+		dbs[i] = &database{index: i, store: db}
+	}
+
+	return dbs, nil
+}
+
+// closeDatabases closes stores of dbs which hold any resources.
+func closeDatabases(dbs []*database) error {
+	for _, db := range dbs {
+		if c, ok := db.store.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dbDir returns a directory where a persistant store of database i keeps its data,
+// the first database uses dir itself.
+func dbDir(dir string, i int) string {
+	if i == 0 {
+		return dir
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("db%d", i))
+}
+
+// selectDB switches a connection c to the database i.
+func (s *server) selectDB(c *session, i int) error {
+	if i < 0 || i >= len(s.dbs) {
+		return ErrBadDB
+	}
+
+	// Watched keys belong to the previous database.
+	c.db, c.watched = s.dbs[i], nil
+	return nil
+}
+
+// flushDB removes all the keys of a database.
+func (s *server) flushDB(db *database, r *proto.Req) error {
+	f, ok := db.store.(store.Flusher)
+	if !ok {
+		return proto.ErrUnsupportedCmd
+	}
+
+	return s.write(db, r, f.Flush)
+}
+
+// dbStats returns the number of keys in every database by its index.
+func (s *server) dbStats() ([]byte, error) {
+	stats := make(map[interface{}]interface{}, len(s.dbs))
+	for _, db := range s.dbs {
+		if sz, ok := db.store.(store.Sizer); ok {
+			stats[db.index] = sz.Size()
+			continue
+		}
+
+		stats[db.index] = len(db.store.Keys())
+	}
+
+	return proto.Encode(stats)
+}
+
+// resize starts changing the number of buckets of a database store to n.
+func (s *server) resize(db *database, n int) error {
+	r, ok := db.store.(store.Resizer)
+	if !ok {
+		return proto.ErrUnsupportedCmd
+	}
+
+	return r.Resize(n)
+}
+
+// info returns metrics of a database store as a map, buckets are a slice of maps of their own metrics.
+// The role of the server in replication and its offset in the stream are added as well,
+// along with the leader of a cluster.
+func (s *server) info(db *database) ([]byte, error) {
+	st, ok := db.store.(store.Statser)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	stats := st.Stats()
+	buckets := make([]interface{}, len(stats.Buckets))
+	for i, b := range stats.Buckets {
+		buckets[i] = map[interface{}]interface{}{
+			"entries": b.Entries,
+			"bytes":   int(b.Bytes),
+		}
+	}
+
+	return proto.Encode(map[interface{}]interface{}{
+		"hits":         int(stats.Hits),
+		"misses":       int(stats.Misses),
+		"sets":         int(stats.Sets),
+		"evictions":    int(stats.Evictions),
+		"expired":      int(stats.Expired),
+		"lazy_expired": int(stats.LazyExpired),
+		"buckets":      buckets,
+		"role":         s.role(),
+		"offset":       int(s.replOffset()),
+		"leader":       s.leader(),
+	})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/aliaksandrb/cachy/glob"
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// eventsBuffer is how many events a subscriber could fall behind before it gets disconnected.
+const eventsBuffer = 1024
+
+// subscription keeps events of keys matching any of patterns until they are sent to a subscriber.
+type subscription struct {
+	// patterns are glob patterns of keys, none means all the keys.
+	patterns []string
+	events   chan store.Event
+	// lagged is closed once an event is dropped because the subscriber is too slow.
+	lagged chan struct{}
+	once   sync.Once
+	cancel func()
+}
+
+// ksubscribe subscribes a connection c to changes of keys of its database,
+// the connection is used only to stream them afterwards, see streamEvents.
+func (s *server) ksubscribe(c *session, patterns []string) error {
+	n, ok := c.db.store.(store.Notifier)
+	if !ok {
+		return proto.ErrUnsupportedCmd
+	}
+
+	sub := &subscription{
+		patterns: patterns,
+		events:   make(chan store.Event, eventsBuffer),
+		lagged:   make(chan struct{}),
+	}
+	sub.cancel = n.Notify(sub.publish)
+	c.events = sub
+
+	return nil
+}
+
+// unsubscribe stops events of a connection c if it is subscribed.
+func (c *session) unsubscribe() {
+	if c.events != nil {
+		c.events.cancel()
+	}
+}
+
+// publish queues an event e if its key matches, it never blocks the store.
+func (sub *subscription) publish(e store.Event) {
+	if !sub.match(e.Key) {
+		return
+	}
+
+	select {
+	case sub.events <- e:
+	default:
+		sub.once.Do(func() { close(sub.lagged) })
+	}
+}
+
+func (sub *subscription) match(key string) bool {
+	if len(sub.patterns) == 0 {
+		return true
+	}
+
+	for _, p := range sub.patterns {
+		if glob.Match(p, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamEvents writes events of a subscription of a connection c until the server stops,
+// the client goes away or falls behind. Each event is a slice of a key, an operation and
+// unix nanoseconds it happened at.
+func (s *server) streamEvents(c *session, buf *bufio.Reader, conn net.Conn) error {
+	sub := c.events
+
+	// Nothing is expected from a subscriber, reading just notices when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, buf)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-s.closing:
+			return nil
+		case <-gone:
+			return io.EOF
+		case <-sub.lagged:
+			log.Info("subscriber is too slow, dropping: %+v", conn.RemoteAddr())
+			return nil
+		case e := <-sub.events:
+			b, err := proto.Encode([]interface{}{e.Key, string(e.Op), int(e.Time.UnixNano())})
+			if err != nil {
+				return err
+			}
+
+			if err = s.writer.WriteRaw(conn, b); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+const (
+	snapshotName = "cachy.snapshot"
+	walName      = "cachy.wal"
+)
+
+// ErrSnapshotInProgress returned when a snapshot is requested while another one is being taken.
+var ErrSnapshotInProgress = errors.New("snapshot in progress")
+
+// restore loads the data saved by a previous run.
+// Write-ahead log has all the writes, so a snapshot is loaded only if there is no log yet.
+func (s *server) restore(st storeType) error {
+	// A node of a cluster gets its data from the Raft log.
+	if s.cluster != nil {
+		return nil
+	}
+
+	if s.walPolicy == "" {
+		// Persistant store keeps its data on its own.
+		if st == MemoryStore {
+			return s.loadSnapshots()
+		}
+		return nil
+	}
+
+	start := time.Now()
+	l, err := wal.Open(s.walPath, s.walPolicy, s.replay)
+	if err != nil {
+		return fmt.Errorf("unable to replay write-ahead log %s: %v", s.walPath, err)
+	}
+	s.wal = l
+
+	log.Info("write-ahead log replayed from %s in %v", s.walPath, time.Since(start))
+
+	if s.wal.Size() > 0 || st != MemoryStore {
+		return nil
+	}
+
+	// The log is empty, so it starts from snapshots if there are any.
+	if err = s.loadSnapshots(); err != nil {
+		return err
+	}
+
+	return s.rewriteLog()
+}
+
+// write applies a write fn to a database and appends a record of its effect
+// to write-ahead log and the replication backlog if it succeeds. The record is made of
+// the state fn leaves, so if the log fails to append it, the previous state of the key
+// is put back. A flush is logged before it is applied instead, as it could not be undone.
+func (s *server) write(db *database, r *proto.Req, fn func() error) error {
+	if s.primary != nil {
+		return ErrReadOnly
+	}
+
+	if !s.logged() {
+		return fn()
+	}
+
+	// A transaction holds the lock already and rolls back on its own.
+	if db.pending == nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		if r.Cmd == proto.CmdFlushDB {
+			if err := s.appendLog(&wal.Record{Op: wal.OpFlush, DB: db.index}); err != nil {
+				return err
+			}
+			return fn()
+		}
+	}
+
+	var undo *wal.Record
+	if db.pending == nil && s.wal != nil {
+		var err error
+		if undo, err = s.keyRecord(db, r.Key); err != nil {
+			return err
+		}
+		undo.DB = db.index
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	rec, err := s.record(db, r)
+	if err != nil {
+		log.Err("unable to make write-ahead log record: %v", err)
+		return err
+	}
+	rec.DB = db.index
+
+	if db.pending != nil {
+		db.pending.add(rec)
+		return nil
+	}
+
+	if err = s.appendLog(rec); err != nil && undo != nil {
+		if uerr := s.replay(undo); uerr != nil {
+			log.Err("unable to undo a write which is not logged: %v", uerr)
+		}
+	}
+
+	return err
+}
+
+// logged reports if writes are recorded, either to write-ahead log, for replicas or for a cluster.
+func (s *server) logged() bool {
+	return s.wal != nil || s.backlog != nil || s.cluster != nil
+}
+
+// appendLog appends records to write-ahead log and then to the replication backlog, so replicas
+// never get writes which failed to be logged, and starts rewriting the log if it grew too much.
+// It is called with writeMu held.
+func (s *server) appendLog(recs ...*wal.Record) error {
+	if s.wal != nil {
+		if err := s.wal.Append(recs...); err != nil {
+			log.Err("unable to append to write-ahead log: %v", err)
+			return err
+		}
+	}
+
+	if s.backlog != nil {
+		s.backlog.append(recs...)
+	}
+
+	if s.wal != nil && s.wal.NeedsRewrite(s.walRewrite) {
+		go func() {
+			if err := s.rewriteLog(); err != nil && err != wal.ErrRewriteInProgress {
+				log.Err("unable to rewrite write-ahead log: %v", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// record returns a write-ahead log record describing the effect of a request r.
+// Records are idempotent, so commands like INCR are logged as the value they produced.
+// Replicas get keys evicted by a write before its record, see backlog.removed, so with a backlog
+// every record is the key as the write left it, removed if it was evicted right away.
+func (s *server) record(db *database, r *proto.Req) (*wal.Record, error) {
+	switch r.Cmd {
+	case proto.CmdRemove:
+		return &wal.Record{Op: wal.OpRemove, Key: r.Key}, nil
+	case proto.CmdFlushDB:
+		return &wal.Record{Op: wal.OpFlush}, nil
+	}
+
+	if s.backlog == nil {
+		switch r.Cmd {
+		case proto.CmdSet, proto.CmdCompareAndSwap, proto.CmdGetSet:
+			return &wal.Record{Op: wal.OpSet, Key: r.Key, Val: r.Value, TTL: deadline(r.TTL)}, nil
+		case proto.CmdSetSliding:
+			return &wal.Record{Op: wal.OpSet, Key: r.Key, Val: r.Value, TTL: deadline(r.TTL), Sliding: r.TTL}, nil
+		}
+	}
+
+	return s.keyRecord(db, r.Key)
+}
+
+// keyRecord returns a record setting a key to its current state in a database.
+// The key is read with store.Peeker, so logging doesn't count as a use of it.
+// Lists and hashes are logged whole, so every write of a single element, like LPUSH or HSET,
+// writes the whole value to the log, the backlog or the Raft log.
+func (s *server) keyRecord(db *database, key string) (*wal.Record, error) {
+	p, ok := db.store.(store.Peeker)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	val, ttl, sliding, err := p.Peek(key)
+	if err == store.ErrNotFound {
+		return &wal.Record{Op: wal.OpRemove, Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &wal.Record{Op: wal.OpSet, Key: key, Val: val, TTL: deadline(ttl), Sliding: sliding}, nil
+}
+
+// replay applies a write-ahead log record to the database it belongs to.
+func (s *server) replay(r *wal.Record) error {
+	if r.DB >= len(s.dbs) {
+		return fmt.Errorf("record of database %d, but only %d configured", r.DB, len(s.dbs))
+	}
+	db := s.dbs[r.DB]
+
+	switch r.Op {
+	case wal.OpFlush:
+		f, ok := db.store.(store.Flusher)
+		if !ok {
+			return proto.ErrUnsupportedCmd
+		}
+		return f.Flush()
+	case wal.OpRemove:
+		if err := db.store.Remove(r.Key); err != store.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	ttl := time.Duration(0)
+	if r.TTL != zeroTime {
+		if ttl = time.Until(r.TTL); ttl <= 0 {
+			// Expired already, but it still overrides a previous value.
+			if err := db.store.Remove(r.Key); err != store.ErrNotFound {
+				return err
+			}
+			return nil
+		}
+	}
+
+	if r.Sliding != 0 {
+		return replaySliding(db, r)
+	}
+
+	return db.store.Set(r.Key, r.Val, ttl)
+}
+
+// replaySliding applies a record of a key with sliding ttl keeping its deadline,
+// which might be closer than the sliding ttl.
+func replaySliding(db *database, r *wal.Record) error {
+	slider, ok := db.store.(store.Slider)
+	expirer, eok := db.store.(store.Expirer)
+	if !ok || !eok {
+		return proto.ErrUnsupportedCmd
+	}
+
+	if err := slider.SetSliding(r.Key, r.Val, r.Sliding); err != nil {
+		return err
+	}
+
+	return expirer.Expire(r.Key, r.TTL)
+}
+
+// rewriteLog rewrites write-ahead log with the current contents of all the databases.
+func (s *server) rewriteLog() error {
+	snaps, err := s.snapshotters()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = s.wal.Rewrite(func(add func(r *wal.Record) error) error {
+		for i, snap := range snaps {
+			if err := dumpLog(i, snap, add); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("write-ahead log rewritten in %v", time.Since(start))
+	return nil
+}
+
+// dumpLog adds a record for every key of database i.
+func dumpLog(i int, snap store.Snapshotter, add func(r *wal.Record) error) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		pw.CloseWithError(snap.Snapshot(pw))
+	}()
+
+	return store.ReadSnapshot(pr, func(key string, val []byte, ttl, sliding time.Duration) error {
+		return add(&wal.Record{Op: wal.OpSet, DB: i, Key: key, Val: val, TTL: deadline(ttl), Sliding: sliding})
+	})
+}
+
+// snapshotters returns snapshotters of all the databases in order.
+func (s *server) snapshotters() ([]store.Snapshotter, error) {
+	snaps := make([]store.Snapshotter, len(s.dbs))
+	for i, db := range s.dbs {
+		snap, ok := db.store.(store.Snapshotter)
+		if !ok {
+			return nil, proto.ErrUnsupportedCmd
+		}
+		snaps[i] = snap
+	}
+
+	return snaps, nil
+}
+
+var zeroTime = time.Time{}
+
+// deadline turns a relative ttl into an absolute one, 0 means no expiration.
+func deadline(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return zeroTime
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// snapshot starts taking snapshots of all the databases in background.
+func (s *server) snapshot() error {
+	snaps, err := s.snapshotters()
+	if err != nil {
+		return err
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.snapshotting, 0, 1) {
+		return ErrSnapshotInProgress
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&s.snapshotting, 0)
+
+		for i, snap := range snaps {
+			start := time.Now()
+			path := s.snapshotPath(i)
+			if err := writeSnapshot(snap, path); err != nil {
+				log.Err("unable to take a snapshot: %v", err)
+				return
+			}
+
+			log.Info("snapshot saved to %s in %v", path, time.Since(start))
+		}
+	}()
+
+	return nil
+}
+
+// snapshotPath returns a path of the snapshot of database i,
+// the first database uses the plain snapshot name.
+func (s *server) snapshotPath(i int) string {
+	if i == 0 {
+		return filepath.Join(s.dir, snapshotName)
+	}
+
+	ext := filepath.Ext(snapshotName)
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(snapshotName, ext), i, ext))
+}
+
+// writeSnapshot writes a snapshot into a temporary file and moves it to the path once done,
+// so the previous snapshot stays intact on failures.
+func writeSnapshot(snap store.Snapshotter, path string) error {
+	tmpPath := path + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = snap.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+// loadSnapshots restores every database from its snapshot if there is any.
+func (s *server) loadSnapshots() error {
+	for _, db := range s.dbs {
+		if err := s.loadSnapshot(db); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSnapshot restores a database from its snapshot if there is any.
+func (s *server) loadSnapshot(db *database) error {
+	snap, ok := db.store.(store.Snapshotter)
+	if !ok {
+		return nil
+	}
+
+	path := s.snapshotPath(db.index)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	if err = snap.Restore(f); err != nil {
+		return fmt.Errorf("unable to restore a snapshot from %s: %v", path, err)
+	}
+
+	log.Info("snapshot loaded from %s in %v", path, time.Since(start))
+	return nil
+}
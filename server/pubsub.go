@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/aliaksandrb/cachy/glob"
+	"github.com/aliaksandrb/cachy/proto"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// pushBuffer is how many messages a subscriber could fall behind before it gets disconnected.
+const pushBuffer = 1024
+
+// ErrSubscribed returned for commands other than subscribing or unsubscribing sent by a subscribed connection.
+var ErrSubscribed = errors.New("only (P)SUBSCRIBE and (P)UNSUBSCRIBE are allowed once subscribed")
+
+// registry fans messages of channels out to their subscribers, it is shared by all the databases.
+type registry struct {
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+
+	mu sync.RWMutex
+}
+
+func newRegistry() *registry {
+	return &registry{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// subscriber is a subscribed connection. Messages and responses to its commands are queued
+// and written by a single goroutine, so they never interleave, see push.
+type subscriber struct {
+	// channels and patterns the subscriber is subscribed to, they are guarded by the registry lock.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	queue chan []byte
+	// lagged is closed once a message is dropped because the subscriber is too slow.
+	lagged chan struct{}
+	once   sync.Once
+	// gone is closed once messages are not written anymore.
+	gone chan struct{}
+	// closed is closed once the connection is closed.
+	closed chan struct{}
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		queue:    make(chan []byte, pushBuffer),
+		lagged:   make(chan struct{}),
+		gone:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Write queues a response b, it implements io.Writer, so responses go through the queue as well.
+func (sub *subscriber) Write(b []byte) (int, error) {
+	select {
+	case sub.queue <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-sub.gone:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// send queues a message b without blocking, false is returned if it is dropped.
+func (sub *subscriber) send(b []byte) bool {
+	select {
+	case sub.queue <- b:
+		return true
+	default:
+		sub.once.Do(func() { close(sub.lagged) })
+		return false
+	}
+}
+
+// push writes queued messages of a subscriber to its connection until it is closed.
+// A subscriber which falls behind gets disconnected.
+func (s *server) push(sub *subscriber, conn net.Conn) {
+	defer close(sub.gone)
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-sub.closed:
+			return
+		case <-sub.lagged:
+			log.Info("subscriber is too slow, dropping: %+v", conn.RemoteAddr())
+			conn.Close()
+			return
+		case b := <-sub.queue:
+			if _, err := conn.Write(b); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// subscribe handles (P)SUBSCRIBE and (P)UNSUBSCRIBE of a connection c, which becomes a subscriber
+// with the first one. The number of channels and patterns the connection is subscribed to is returned.
+func (s *server) subscribe(c *session, r *proto.Req) ([]byte, error) {
+	if c.sub == nil {
+		if r.Cmd == proto.CmdUnsubscribe || r.Cmd == proto.CmdPUnsubscribe {
+			return proto.Encode(0)
+		}
+		c.sub = newSubscriber()
+	}
+
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+
+	switch r.Cmd {
+	case proto.CmdSubscribe:
+		addSubscriber(s.pubsub.channels, c.sub.channels, c.sub, r.Keys)
+	case proto.CmdPSubscribe:
+		addSubscriber(s.pubsub.patterns, c.sub.patterns, c.sub, r.Keys)
+	case proto.CmdUnsubscribe:
+		removeSubscriber(s.pubsub.channels, c.sub.channels, c.sub, r.Keys)
+	case proto.CmdPUnsubscribe:
+		removeSubscriber(s.pubsub.patterns, c.sub.patterns, c.sub, r.Keys)
+	}
+
+	return proto.Encode(len(c.sub.channels) + len(c.sub.patterns))
+}
+
+// addSubscriber subscribes sub to names of channels or patterns, it is called with write lock held.
+func addSubscriber(all map[string]map[*subscriber]struct{}, own map[string]struct{}, sub *subscriber, names []string) {
+	for _, name := range names {
+		subs, ok := all[name]
+		if !ok {
+			subs = make(map[*subscriber]struct{})
+			all[name] = subs
+		}
+
+		subs[sub] = struct{}{}
+		own[name] = struct{}{}
+	}
+}
+
+// removeSubscriber unsubscribes sub from names of channels or patterns, all of its own ones if none given.
+// It is called with write lock held.
+func removeSubscriber(all map[string]map[*subscriber]struct{}, own map[string]struct{}, sub *subscriber, names []string) {
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		delete(own, name)
+
+		subs := all[name]
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(all, name)
+		}
+	}
+}
+
+// leave unsubscribes a closed connection c from everything.
+func (s *server) leave(c *session) {
+	if c.sub == nil {
+		return
+	}
+
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+
+	removeSubscriber(s.pubsub.channels, c.sub.channels, c.sub, nil)
+	removeSubscriber(s.pubsub.patterns, c.sub.patterns, c.sub, nil)
+	close(c.sub.closed)
+}
+
+// publish sends an encoded value val to subscribers of a channel and ones of patterns matching it,
+// the number of subscribers it is sent to is returned.
+func (s *server) publish(channel string, val []byte) ([]byte, error) {
+	s.pubsub.mu.RLock()
+	defer s.pubsub.mu.RUnlock()
+
+	n := 0
+	if subs := s.pubsub.channels[channel]; len(subs) > 0 {
+		b := append(proto.EncodePush(channel, "", val), proto.CR)
+		for sub := range subs {
+			if sub.send(b) {
+				n++
+			}
+		}
+	}
+
+	for pattern, subs := range s.pubsub.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+
+		b := append(proto.EncodePush(channel, pattern, val), proto.CR)
+		for sub := range subs {
+			if sub.send(b) {
+				n++
+			}
+		}
+	}
+
+	return proto.Encode(n)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/wal"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// replicaRetry is a delay before a replica connects to its primary again once the connection is lost.
+const replicaRetry = 100 * time.Millisecond
+
+// ErrReadOnly returned for writes sent to a replica.
+var ErrReadOnly = errors.New("replica is read-only")
+
+// primary is a server a replica copies its data from. The replica remembers the position
+// in the replication stream it has applied, so it continues from there once it reconnects.
+type primary struct {
+	addr string
+
+	mu sync.Mutex
+	// id is the stream of the primary, empty until the first full sync.
+	id     string
+	offset int64
+	// db is the database selected by the stream at offset.
+	db   int
+	conn net.Conn
+	// closed is set once the server stops.
+	closed bool
+}
+
+func (p *primary) position() (id string, offset int64, db int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.id, p.offset, p.db
+}
+
+func (p *primary) advance(id string, offset int64, db int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.id, p.offset, p.db = id, offset, db
+}
+
+// connect connects to the primary, the connection is closed once the server stops.
+func (p *primary) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		conn.Close()
+		return nil, io.EOF
+	}
+	p.conn = conn
+
+	return conn, nil
+}
+
+func (p *primary) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// replicate keeps the databases of a replica in sync with its primary until the server stops.
+func (s *server) replicate() {
+	for {
+		err := s.syncPrimary()
+
+		select {
+		case <-s.closing:
+			return
+		default:
+		}
+
+		log.Err("replication from %s is interrupted: %v", s.primary.addr, err)
+
+		select {
+		case <-s.closing:
+			return
+		case <-time.After(replicaRetry):
+		}
+	}
+}
+
+// syncPrimary connects to the primary, catches up with it and applies its writes until the connection is lost.
+func (s *server) syncPrimary() error {
+	conn, err := s.primary.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	known, offset, db := s.primary.position()
+	if _, err = conn.Write(proto.NewSyncMessage(known, uint64(offset))); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	mode, _ := header[0].(string)
+	id, _ := header[1].(string)
+	off, _ := header[2].(int)
+
+	switch {
+	case mode == "full" && len(header) == 4:
+		db, _ = header[3].(int)
+
+		// A dump loaded partially is not a position to continue from.
+		s.primary.advance("", 0, 0)
+
+		start := time.Now()
+		dump := &chunkReader{r: r}
+		if err = s.applyStream(wal.NewDecoder(dump, -1), true, nil); err != io.EOF || !dump.done {
+			return fmt.Errorf("unable to load a dump: %v", err)
+		}
+
+		log.Info("full sync with %s done in %v", s.primary.addr, time.Since(start))
+	case mode == "continue" && id == known && int64(off) == offset:
+		log.Info("sync with %s continues from %d", s.primary.addr, off)
+	default:
+		return fmt.Errorf("unexpected sync response: % q", header)
+	}
+
+	offset = int64(off)
+	s.primary.advance(id, offset, db)
+
+	return s.applyStream(wal.NewDecoder(r, db), true, func(n int, db int) {
+		offset += int64(n)
+		s.primary.advance(id, offset, db)
+	})
+}
+
+// applyStream applies records of a stream one by one calling applied for every one with its size
+// and the database selected after it. Records of the replication stream have the time remaining
+// instead of deadlines, see streamed.
+func (s *server) applyStream(d *wal.Decoder, replicated bool, applied func(n int, db int)) error {
+	for {
+		rec, n, err := d.Decode()
+		if err != nil {
+			return err
+		}
+
+		if replicated {
+			unstreamed(rec)
+		}
+		if err = s.replay(rec); err != nil {
+			return err
+		}
+
+		if applied != nil {
+			applied(n, d.DB())
+		}
+	}
+}
+
+// readHeader reads a response to SYNC, see sync.
+func readHeader(r *bufio.Reader) ([]interface{}, error) {
+	b, err := r.ReadBytes(proto.CR)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := proto.NewDecoder().Decode(proto.NewScanner(bytes.NewReader(b)))
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := val.(error); ok {
+		return nil, err
+	}
+
+	header, ok := val.([]interface{})
+	if !ok || len(header) < 3 {
+		return nil, fmt.Errorf("unexpected sync response: % q", val)
+	}
+
+	return header, nil
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// dumpChunk is the size of chunks a dump of a full sync is sent in.
+const dumpChunk = 64 << 10
+
+var (
+	// ErrNoBacklog returned when a replica asks to sync with a server which keeps no backlog.
+	ErrNoBacklog = errors.New("replication backlog is disabled")
+	// ErrLagged returned when a replica asks for a part of the stream which is not kept anymore.
+	ErrLagged = errors.New("replica is too far behind")
+)
+
+/*
+Replication protocol. A replica asks a primary for the stream with SYNC:
+
+	Y\n<offset>\n<stream id>\r
+
+an empty id asks for a full copy. The reply is a slice, either ["continue", id, offset], or
+["full", id, offset, db] followed by a dump, which is records flushing every database and setting its keys.
+The dump is taken as it is sent, in chunks of a 4 byte big endian length and as many bytes, and it ends
+with a chunk of zero length. The stream follows from offset as writes happen, along with keys evicted
+or expired on the primary, which are sent as removals. The dump and the stream are records in the format
+of wal package, encoded by wal.Encoder, so a record is preceded by one selecting its database when it changes.
+The ttl of a record is the time remaining in nanoseconds rather than a deadline, so clocks of replicas
+don't have to match the one of the primary. db is the database selected at offset, a replica keeps it
+along with the offset to continue from. The id is random and changes on every start of a primary,
+so a replica of a restarted primary always gets a full copy.
+*/
+
+// backlog keeps the latest part of the replication stream, which has every write of the server
+// as write-ahead log records. The stream is identified by a random id generated on start
+// and a position in it is its offset in bytes.
+type backlog struct {
+	id   string
+	size int
+
+	mu sync.Mutex
+	// buf keeps the latest bytes of the stream starting at offset start, at least size of them.
+	buf   []byte
+	start int64
+	enc   *wal.Encoder
+	// appended is closed and replaced every time records are appended.
+	appended chan struct{}
+}
+
+func newBacklog(size int) (*backlog, error) {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &backlog{
+		id:       hex.EncodeToString(id),
+		size:     size,
+		enc:      wal.NewEncoder(-1),
+		appended: make(chan struct{}),
+	}, nil
+}
+
+// append adds records to the stream and wakes up replicas waiting for them.
+func (b *backlog) append(recs ...*wal.Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, r := range recs {
+		b.buf = append(b.buf, b.enc.Encode(streamed(r))...)
+	}
+
+	// The oldest bytes are dropped only once there are twice as many as needed, so they are not copied
+	// on every append. A new slice is made, as replicas might be still sending the old one.
+	if len(b.buf) > 2*b.size {
+		drop := len(b.buf) - b.size
+		b.start += int64(drop)
+		b.buf = append([]byte(nil), b.buf[drop:]...)
+	}
+
+	close(b.appended)
+	b.appended = make(chan struct{})
+}
+
+// position returns the current offset of the stream and the database selected at it.
+func (b *backlog) position() (offset int64, db int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.start + int64(len(b.buf)), b.enc.DB()
+}
+
+// offset returns the current offset of the stream.
+func (b *backlog) offset() int64 {
+	off, _ := b.position()
+	return off
+}
+
+// read returns the stream from offset to its end, and a channel closed once anything is appended
+// if there is nothing to return yet. ErrLagged is returned if offset is not kept anymore.
+func (b *backlog) read(offset int64) ([]byte, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset < b.start || offset > b.start+int64(len(b.buf)) {
+		return nil, nil, ErrLagged
+	}
+
+	return b.buf[offset-b.start:], b.appended, nil
+}
+
+// removed returns a function appending removals of keys evicted or expired in a database db,
+// see store.Notifier. Replicas expire keys on their own as well, but a primary could do it earlier
+// if it is read first, and they never evict keys the primary evicts.
+func (b *backlog) removed(db int) func(e store.Event) {
+	return func(e store.Event) {
+		if e.Op == store.EventEvict || e.Op == store.EventExpire {
+			b.append(&wal.Record{Op: wal.OpRemove, DB: db, Key: e.Key})
+		}
+	}
+}
+
+// streamed returns a record as it is sent to replicas, with the time remaining instead of a deadline,
+// see the replication protocol. A value expired already is removed instead.
+func streamed(r *wal.Record) *wal.Record {
+	if r.Op != wal.OpSet || r.TTL == zeroTime {
+		return r
+	}
+
+	ttl := time.Until(r.TTL)
+	if ttl <= 0 {
+		return &wal.Record{Op: wal.OpRemove, DB: r.DB, Key: r.Key}
+	}
+
+	sr := *r
+	sr.TTL = time.Unix(0, int64(ttl))
+	return &sr
+}
+
+// unstreamed turns the time remaining of a streamed record back into a deadline, see streamed.
+func unstreamed(r *wal.Record) {
+	if r.Op == wal.OpSet && r.TTL != zeroTime {
+		r.TTL = deadline(time.Duration(r.TTL.UnixNano()))
+	}
+}
+
+// feed is a position of a replica connection in the replication stream.
+type feed struct {
+	// snaps are dumped before the stream on a full sync.
+	snaps  []store.Snapshotter
+	offset int64
+}
+
+// sync handles SYNC of a replica which has applied the stream r.Key up to the offset r.Cursor.
+// The replica continues from its offset if the backlog still has it, the response is a slice of
+// "continue", the stream id and the offset. Otherwise the response is a slice of "full", the stream id,
+// the offset and the database selected at it, a dump of all the databases follows.
+// The stream is sent afterwards, see feedReplica.
+func (s *server) sync(c *session, r *proto.Req) ([]byte, error) {
+	if s.backlog == nil {
+		return nil, ErrNoBacklog
+	}
+
+	if r.Key == s.backlog.id {
+		if _, _, err := s.backlog.read(int64(r.Cursor)); err == nil {
+			c.feed = &feed{offset: int64(r.Cursor)}
+			return proto.Encode([]interface{}{"continue", s.backlog.id, int(r.Cursor)})
+		}
+	}
+
+	snaps, err := s.snapshotters()
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes made while the dump is taken are streamed after it, records are idempotent,
+	// so values dumped already are set to the same ones again.
+	offset, db := s.backlog.position()
+	c.feed = &feed{snaps: snaps, offset: offset}
+	return proto.Encode([]interface{}{"full", s.backlog.id, int(offset), db})
+}
+
+// dump writes records replacing the contents of every database with its current keys to w,
+// passing every one through fn if it is given. Keys are read from snapshots through a pipe,
+// as rewriteLog does, so a dump sent to a replica is never kept in memory whole.
+func dump(w io.Writer, snaps []store.Snapshotter, fn func(r *wal.Record) *wal.Record) error {
+	enc := wal.NewEncoder(-1)
+	add := func(r *wal.Record) error {
+		if fn != nil {
+			r = fn(r)
+		}
+		_, err := w.Write(enc.Encode(r))
+		return err
+	}
+
+	for i, snap := range snaps {
+		if err := add(&wal.Record{Op: wal.OpFlush, DB: i}); err != nil {
+			return err
+		}
+		if err := dumpLog(i, snap, add); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendDump sends a dump to a replica in chunks, see the replication protocol.
+func sendDump(w io.Writer, snaps []store.Snapshotter) error {
+	cw := &chunkWriter{w: w}
+	bw := bufio.NewWriterSize(cw, dumpChunk)
+	if err := dump(bw, snaps, streamed); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return cw.close()
+}
+
+// chunkWriter writes every write as a chunk of a dump, see the replication protocol.
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b := make([]byte, 4, 4+len(p))
+	binary.BigEndian.PutUint32(b, uint32(len(p)))
+	if _, err := cw.w.Write(append(b, p...)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// close writes the chunk of zero length ending the dump.
+func (cw *chunkWriter) close() error {
+	_, err := cw.w.Write(make([]byte, 4))
+	return err
+}
+
+// chunkReader reads chunks of a dump until the one of zero length, then it returns io.EOF.
+type chunkReader struct {
+	r io.Reader
+	// left is the number of bytes left in the current chunk.
+	left uint32
+	// done is set once the last chunk is read.
+	done bool
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+
+	if cr.left == 0 {
+		var head [4]byte
+		if _, err := io.ReadFull(cr.r, head[:]); err != nil {
+			return 0, err
+		}
+
+		if cr.left = binary.BigEndian.Uint32(head[:]); cr.left == 0 {
+			cr.done = true
+			return 0, io.EOF
+		}
+	}
+
+	if uint32(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.left -= uint32(n)
+	return n, err
+}
+
+// feedReplica sends a dump if the replica connection c does a full sync, then the replication stream
+// until the server stops, the replica goes away or falls behind the backlog.
+func (s *server) feedReplica(c *session, buf *bufio.Reader, conn net.Conn) error {
+	if c.feed.snaps != nil {
+		if err := sendDump(conn, c.feed.snaps); err != nil {
+			return err
+		}
+	}
+
+	// Nothing is expected from a replica, reading just notices when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, buf)
+		close(gone)
+	}()
+
+	offset := c.feed.offset
+	for {
+		b, appended, err := s.backlog.read(offset)
+		if err != nil {
+			return err
+		}
+
+		if len(b) > 0 {
+			if _, err = conn.Write(b); err != nil {
+				return err
+			}
+			offset += int64(len(b))
+			continue
+		}
+
+		select {
+		case <-s.closing:
+			return nil
+		case <-gone:
+			return io.EOF
+		case <-appended:
+		}
+	}
+}
+
+// role returns "replica" for a replica of another server, the Raft role for a node of a cluster
+// and "primary" otherwise.
+func (s *server) role() string {
+	switch {
+	case s.primary != nil:
+		return "replica"
+	case s.cluster != nil:
+		return s.cluster.node.Status().Role.String()
+	}
+
+	return "primary"
+}
+
+// leader returns the address of the leader of a cluster, empty if it is not known or there is no cluster.
+func (s *server) leader() string {
+	if s.cluster == nil {
+		return ""
+	}
+
+	return s.cluster.node.Status().Leader
+}
+
+// replOffset returns the offset of the replication stream written by a primary or applied by a replica,
+// the index of the last Raft entry applied by a node of a cluster, 0 for a primary without a backlog.
+func (s *server) replOffset() int64 {
+	switch {
+	case s.cluster != nil:
+		return int64(s.cluster.node.Status().Applied)
+	case s.primary != nil:
+		_, offset, _ := s.primary.position()
+		return offset
+	case s.backlog != nil:
+		return s.backlog.offset()
+	}
+
+	return 0
+}
+
+// logClosed logs why a replica connection is closed.
+func logClosed(conn net.Conn, err error) {
+	if err == ErrLagged {
+		log.Info("replica is too slow, dropping: %+v", conn.RemoteAddr())
+		return
+	}
+
+	log.Info("closing a replica: %+v, err: %v", conn.RemoteAddr(), err)
+}
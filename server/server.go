@@ -2,17 +2,22 @@ package server
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aliaksandrb/cachy/glob"
 	"github.com/aliaksandrb/cachy/proto"
 	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/store/dstore"
 	"github.com/aliaksandrb/cachy/store/mstore"
+	"github.com/aliaksandrb/cachy/wal"
 
 	log "github.com/aliaksandrb/cachy/logger"
 )
@@ -24,17 +29,22 @@ type Server interface {
 }
 
 // Run spawns and runs a new server in background.
-func Run(s storeType, bs int, addr string) (Server, error) {
+func Run(s storeType, bs int, addr string, opts ...Option) (Server, error) {
 	listener, err := makeListener(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	server, err := New(s, bs, listener)
+	server, err := New(s, bs, listener, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = server.restore(s); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
@@ -47,6 +57,10 @@ func Run(s storeType, bs int, addr string) (Server, error) {
 	log.Info("server started on %s ...", addr)
 	go server.start()
 
+	if server.primary != nil {
+		go server.replicate()
+	}
+
 	return server, nil
 }
 
@@ -60,16 +74,36 @@ func (s *server) Stop() error {
 
 	close(s.closing)
 
+	if s.primary != nil {
+		s.primary.close()
+	}
+
 	select {
 	case <-s.syncClients():
 	case <-time.After(10 * time.Second):
 		log.Info("timeouted, killing...")
 	}
 
+	if s.cluster != nil {
+		if err := s.cluster.stop(); err != nil {
+			return err
+		}
+	}
+
 	if err := s.listener.Close(); err != nil {
 		return err
 	}
 
+	if err := closeDatabases(s.dbs); err != nil {
+		return err
+	}
+
+	if s.wal != nil {
+		if err := s.wal.Close(); err != nil {
+			return err
+		}
+	}
+
 	log.Info("stoping server, done.")
 	return nil
 }
@@ -96,38 +130,223 @@ const (
 	PersistantStore
 )
 
+// ParseStoreType returns a storeType by its name: "memory" or "disk".
+func ParseStoreType(name string) (storeType, error) {
+	switch name {
+	case "memory":
+		return MemoryStore, nil
+	case "disk":
+		return PersistantStore, nil
+	}
+
+	return 0, fmt.Errorf("%v: %q", store.ErrUnsuportedStoreType, name)
+}
+
+const (
+	defaultDir            = "."
+	defaultDatabases      = 1
+	defaultWALRewriteSize = 64 << 20
+)
+
+// Option configures optional server settings.
+type Option func(*options)
+
+type options struct {
+	dir            string
+	databases      int
+	mstoreOpts     []mstore.Option
+	walPolicy      wal.SyncPolicy
+	walRewriteSize int64
+	replicaOf      string
+	backlogSize    int
+	clusterAddr    string
+	clusterPeers   []string
+	raftSnapshot   int
+}
+
+// WithDir sets the directory where a persistant store keeps its data and snapshots are saved to.
+func WithDir(dir string) Option {
+	return func(o *options) {
+		o.dir = dir
+	}
+}
+
+// WithDatabases sets the number of databases, each one has its own store and is selected
+// by a connection with SELECT command, default: 1. Memory store limits apply to every database.
+func WithDatabases(n int) Option {
+	return func(o *options) {
+		o.databases = n
+	}
+}
+
+// WithMemoryStoreOptions sets options used to initialize a memory store.
+func WithMemoryStoreOptions(opts ...mstore.Option) Option {
+	return func(o *options) {
+		o.mstoreOpts = append(o.mstoreOpts, opts...)
+	}
+}
+
+// WithWAL enables write-ahead log synced according to policy.
+// Every write is appended to the log, which is replayed on start instead of loading a snapshot.
+func WithWAL(policy wal.SyncPolicy) Option {
+	return func(o *options) {
+		o.walPolicy = policy
+	}
+}
+
+// WithWALRewriteSize sets the size of write-ahead log in bytes after which it is rewritten
+// in background every time it doubles, default: 64MB.
+func WithWALRewriteSize(n int64) Option {
+	return func(o *options) {
+		o.walRewriteSize = n
+	}
+}
+
+// WithReplicaOf makes the server a read-only replica of a primary at addr, which should keep a backlog.
+// The replica copies all the data of the primary and applies its writes as they happen.
+func WithReplicaOf(addr string) Option {
+	return func(o *options) {
+		o.replicaOf = addr
+	}
+}
+
+// WithBacklog makes the server keep at least n bytes of its latest writes for replicas,
+// so a replica which was disconnected for a while continues from where it stopped
+// instead of copying all the data again. Replicas could connect only if it is set.
+func WithBacklog(n int) Option {
+	return func(o *options) {
+		o.backlogSize = n
+	}
+}
+
+// WithCluster makes the server a node of a cluster of peers, addr is the address of the server
+// the others connect to. Commands working with data are served by the leader elected by the nodes
+// and its writes are committed once the majority of nodes has them, so they survive a loss of any
+// minority of nodes. The other nodes reply with ErrNotLeader, INFO tells which one is the leader.
+// Commands are served one at a time and a write holds its keys until the majority has it, so every command
+// waits for the writes before it to reach the majority. A write which doesn't in 5 seconds gets ErrClusterTimeout,
+// its outcome is unknown, and the leader steps down, so the following commands get ErrNotLeader until
+// a leader is elected.
+// A node keeps its Raft log in the directory set by WithDir and could not be a replica of another server,
+// have write-ahead log or a backlog.
+func WithCluster(addr string, peers ...string) Option {
+	return func(o *options) {
+		o.clusterAddr = addr
+		o.clusterPeers = peers
+	}
+}
+
+// WithRaftSnapshot sets the number of writes after which a node of a cluster compacts its Raft log
+// with a snapshot of its databases, default: 1024.
+func WithRaftSnapshot(n int) Option {
+	return func(o *options) {
+		o.raftSnapshot = n
+	}
+}
+
 // New returns a new Server implementation.
-func New(s storeType, bs int, l *net.TCPListener) (*server, error) {
-	var db store.Store
+func New(s storeType, bs int, l *net.TCPListener, opts ...Option) (*server, error) {
+	o := &options{dir: defaultDir, databases: defaultDatabases, walRewriteSize: defaultWALRewriteSize}
+	for _, opt := range opts {
+		opt(o)
+	}
 
-	if s != MemoryStore {
-		return nil, store.ErrUnsuportedStoreType
+	if o.replicaOf != "" && (o.walPolicy != "" || o.backlogSize > 0) {
+		return nil, fmt.Errorf("replica of %s could not have write-ahead log or a backlog", o.replicaOf)
 	}
 
-	db, err := mstore.New(bs, 0)
+	if o.clusterAddr != "" && (o.walPolicy != "" || o.backlogSize > 0 || o.replicaOf != "") {
+		return nil, fmt.Errorf("cluster node %s could not have write-ahead log, a backlog or be a replica", o.clusterAddr)
+	}
+
+	dbs, err := newDatabases(s, bs, o)
 	if err != nil {
 		return nil, err
 	}
 
-	return &server{
-		store:    db,
-		listener: l,
-		closing:  make(chan struct{}),
-		done:     make(chan struct{}),
-		clients:  &sync.WaitGroup{},
-		decoder:  proto.NewDecoder(),
-		writer:   proto.NewWriter(),
-	}, nil
+	srv := &server{
+		dbs:        dbs,
+		listener:   l,
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		clients:    &sync.WaitGroup{},
+		decoder:    proto.NewDecoder(),
+		writer:     proto.NewWriter(),
+		pubsub:     newRegistry(),
+		dir:        o.dir,
+		walPath:    filepath.Join(o.dir, walName),
+		walPolicy:  o.walPolicy,
+		walRewrite: o.walRewriteSize,
+	}
+
+	if o.replicaOf != "" {
+		srv.primary = &primary{addr: o.replicaOf}
+	}
+
+	if o.backlogSize > 0 {
+		if srv.backlog, err = newBacklog(o.backlogSize); err != nil {
+			closeDatabases(dbs)
+			return nil, err
+		}
+
+		for _, db := range dbs {
+			if n, ok := db.store.(store.Notifier); ok {
+				n.Notify(srv.backlog.removed(db.index))
+			}
+		}
+	}
+
+	if o.clusterAddr != "" {
+		if srv.cluster, err = newCluster(srv, o.clusterAddr, o.clusterPeers, o.dir, o.raftSnapshot); err != nil {
+			closeDatabases(dbs)
+			return nil, err
+		}
+	}
+
+	return srv, nil
+}
+
+func newStore(s storeType, bs int, dir string, o *options) (store.Store, error) {
+	switch s {
+	case MemoryStore:
+		return mstore.New(bs, 0, o.mstoreOpts...)
+	case PersistantStore:
+		return dstore.New(dir)
+	}
+
+	return nil, store.ErrUnsuportedStoreType
 }
 
 type server struct {
-	store    store.Store
+	// dbs are databases selected by index, a connection starts with the first one.
+	dbs      []*database
 	closing  chan struct{}
 	done     chan struct{}
 	clients  *sync.WaitGroup
 	listener *net.TCPListener
 	decoder  MessageDecoder
 	writer   Writer
+	// pubsub keeps subscribers of channels.
+	pubsub *registry
+
+	// dir is where snapshots are saved to.
+	dir string
+	// snapshotting is set to 1 while a snapshot is being taken.
+	snapshotting int32
+
+	wal        *wal.Log
+	walPath    string
+	walPolicy  wal.SyncPolicy
+	walRewrite int64
+	// writeMu serializes writes when they are logged, so the log keeps the order they were applied in.
+	writeMu sync.Mutex
+
+	// backlog is set if replicas could connect to the server.
+	backlog *backlog
+	// primary is set if the server is a replica of another one.
+	primary *primary
+	// cluster is set if the server is a node of a cluster.
+	cluster *cluster
 }
 
 // MessageDecoder used to decode incomming TCP messages on a server side.
@@ -180,21 +399,52 @@ func (s *server) handleClient(conn net.Conn) {
 
 	var err error
 	reader := bufio.NewReader(conn)
+	c := &session{db: s.dbs[0]}
+	defer c.unsubscribe()
+	defer s.leave(c)
 
 	for {
 		select {
 		case <-s.closing:
 			return
 		default:
-			if err = s.handleMessage(reader, conn); err != nil {
+			subscribed := c.sub != nil
+
+			var w io.Writer = conn
+			if subscribed {
+				w = c.sub
+			}
+
+			if err = s.handleMessage(c, reader, w); err != nil {
 				log.Info("closing a client: %+v", conn.RemoteAddr())
 				return
 			}
+
+			if c.events != nil {
+				err = s.streamEvents(c, reader, conn)
+				log.Info("closing a subscriber: %+v, err: %v", conn.RemoteAddr(), err)
+				return
+			}
+
+			if c.feed != nil {
+				logClosed(conn, s.feedReplica(c, reader, conn))
+				return
+			}
+
+			if c.raft {
+				s.serveNode(reader, conn)
+				log.Info("closing a cluster node: %+v", conn.RemoteAddr())
+				return
+			}
+
+			if !subscribed && c.sub != nil {
+				go s.push(c.sub, conn)
+			}
 			reader.Reset(conn)
 		}
 	}
 }
-func (s *server) handleMessage(buf *bufio.Reader, w io.Writer) error {
+func (s *server) handleMessage(c *session, buf *bufio.Reader, w io.Writer) error {
 	msg, err := s.decoder.DecodeMessage(buf)
 	if err == io.EOF {
 		return err
@@ -210,7 +460,7 @@ func (s *server) handleMessage(buf *bufio.Reader, w io.Writer) error {
 		return s.writer.WriteUnknownErr(w)
 	}
 
-	result, err := s.processRequest(req)
+	result, err := s.processRequest(c, req)
 	if err != nil {
 		return s.writer.Write(w, err)
 	}
@@ -218,23 +468,396 @@ func (s *server) handleMessage(buf *bufio.Reader, w io.Writer) error {
 	return s.writer.WriteRaw(w, result)
 }
 
-func (s *server) processRequest(r *proto.Req) (v []byte, err error) {
+func (s *server) processRequest(c *session, r *proto.Req) (v []byte, err error) {
+	if c.sub != nil {
+		switch r.Cmd {
+		case proto.CmdSubscribe, proto.CmdPSubscribe, proto.CmdUnsubscribe, proto.CmdPUnsubscribe:
+		default:
+			return nil, ErrSubscribed
+		}
+	}
+
+	if c.multi {
+		return s.processTx(c, r)
+	}
+
+	db := c.db
+
+	// A transaction of a cluster has its commands routed already.
+	if s.cluster != nil && db.pending == nil && clustered(r.Cmd) {
+		return s.clusterRequest(c, r)
+	}
+
 	switch r.Cmd {
 	case proto.CmdGet:
-		return s.store.Get(r.Key)
+		return s.get(db, r)
 	case proto.CmdSet:
-		return nil, s.store.Set(r.Key, r.Value, r.TTL)
+		return nil, s.write(db, r, func() error { return db.store.Set(r.Key, r.Value, r.TTL) })
+	case proto.CmdSetNX:
+		return s.setNX(db, r)
+	case proto.CmdGetSet:
+		return s.getSet(db, r)
 	case proto.CmdUpdate:
-		return nil, s.store.Update(r.Key, r.Value, r.TTL)
+		return nil, s.write(db, r, func() error { return db.store.Update(r.Key, r.Value, r.TTL) })
 	case proto.CmdRemove:
-		return nil, s.store.Remove(r.Key)
+		return nil, s.write(db, r, func() error { return db.store.Remove(r.Key) })
+	case proto.CmdGetWithVersion:
+		return s.getWithVersion(db, r.Key)
+	case proto.CmdCompareAndSwap:
+		return nil, s.write(db, r, func() error { return db.store.CompareAndSwap(r.Key, r.Value, r.Version, r.TTL) })
+	case proto.CmdMGet, proto.CmdMSet, proto.CmdMDel:
+		return s.multiKey(c, r)
 	case proto.CmdKeys:
-		return proto.Encode(s.store.Keys())
+		return proto.Encode(s.keys(db, r.Key))
+	case proto.CmdScan:
+		return s.scan(db, r)
+	case proto.CmdIncr, proto.CmdDecr, proto.CmdIncrBy:
+		return s.incrBy(db, r)
+	case proto.CmdSetSliding, proto.CmdSliding:
+		return s.sliding(db, r)
+	case proto.CmdTTL, proto.CmdExpire, proto.CmdExpireAt, proto.CmdPersist, proto.CmdTouch:
+		return s.expire(db, r)
+	case proto.CmdLPush, proto.CmdRPush, proto.CmdLPop, proto.CmdRPop, proto.CmdLRange, proto.CmdLTrim, proto.CmdLLen:
+		return s.list(db, r)
+	case proto.CmdHGet, proto.CmdHSet, proto.CmdHDel, proto.CmdHKeys, proto.CmdHLen, proto.CmdHIncrBy:
+		return s.hash(db, r)
+	case proto.CmdSelect:
+		return nil, s.selectDB(c, r.DB)
+	case proto.CmdFlushDB:
+		return nil, s.flushDB(db, r)
+	case proto.CmdDBStats:
+		return s.dbStats()
+	case proto.CmdInfo:
+		return s.info(db)
+	case proto.CmdResize:
+		return nil, s.resize(db, r.Count)
+	case proto.CmdMulti:
+		c.multi = true
+		return nil, nil
+	case proto.CmdExec, proto.CmdDiscard:
+		return nil, ErrNoMulti
+	case proto.CmdWatch:
+		return nil, s.watch(c, r.Keys)
+	case proto.CmdUnwatch:
+		c.watched = nil
+		return nil, nil
+	case proto.CmdPublish:
+		return s.publish(r.Key, r.Value)
+	case proto.CmdSubscribe, proto.CmdPSubscribe, proto.CmdUnsubscribe, proto.CmdPUnsubscribe:
+		return s.subscribe(c, r)
+	case proto.CmdKSubscribe:
+		return nil, s.ksubscribe(c, r.Keys)
+	case proto.CmdSnapshot:
+		return nil, s.snapshot()
+	case proto.CmdSync:
+		return s.sync(c, r)
+	case proto.CmdRaft:
+		if s.cluster == nil {
+			return nil, proto.ErrUnsupportedCmd
+		}
+		c.raft = true
+		return nil, nil
 	}
 
 	return nil, proto.ErrUnknown
 }
 
+// get returns a value of a key. Reads renewing sliding ttl are logged as writes,
+// so the key doesn't expire earlier after a restart.
+func (s *server) get(db *database, r *proto.Req) (val []byte, err error) {
+	slider, ok := db.store.(store.Slider)
+	if !ok || !s.logged() {
+		return db.store.Get(r.Key)
+	}
+
+	if sliding, err := slider.Sliding(r.Key); err != nil || sliding == 0 {
+		return db.store.Get(r.Key)
+	}
+
+	err = s.write(db, r, func() (err error) {
+		val, err = db.store.Get(r.Key)
+		return err
+	})
+
+	return val, err
+}
+
+// sliding runs commands working with keys which ttl is renewed by reads.
+func (s *server) sliding(db *database, r *proto.Req) ([]byte, error) {
+	slider, ok := db.store.(store.Slider)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	if r.Cmd == proto.CmdSliding {
+		ttl, err := slider.Sliding(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Encode(int(ttl))
+	}
+
+	return nil, s.write(db, r, func() error { return slider.SetSliding(r.Key, r.Value, r.TTL) })
+}
+
+// setNX sets a value if the key is missed and returns 1 if it was set, 0 otherwise.
+func (s *server) setNX(db *database, r *proto.Req) ([]byte, error) {
+	var ok bool
+	err := s.write(db, r, func() (err error) {
+		ok, err = db.store.SetNX(r.Key, r.Value, r.TTL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		return proto.Encode(1)
+	}
+
+	return proto.Encode(0)
+}
+
+// getSet sets a value and returns the previous one, nil if the key was missed.
+func (s *server) getSet(db *database, r *proto.Req) (old []byte, err error) {
+	err = s.write(db, r, func() (err error) {
+		old, err = db.store.GetSet(r.Key, r.Value, r.TTL)
+		return err
+	})
+
+	return old, err
+}
+
+// multiKey runs MGET, MSET or MDEL as GET, SET or REMOVE of every key and returns a slice of their results
+// in the same order. A key which fails gets its error in place, like "not found", the others are run anyway.
+func (s *server) multiKey(c *session, r *proto.Req) ([]byte, error) {
+	results := make([][]byte, len(r.Keys))
+	for i, k := range r.Keys {
+		kr := &proto.Req{Cmd: proto.CmdGet, Key: k}
+		switch r.Cmd {
+		case proto.CmdMSet:
+			kr.Cmd, kr.Value, kr.TTL = proto.CmdSet, r.Values[i], r.TTLs[i]
+		case proto.CmdMDel:
+			kr.Cmd = proto.CmdRemove
+		}
+
+		v, err := s.processRequest(c, kr)
+		if err != nil {
+			v, err = proto.Encode(err)
+		} else if len(v) == 0 {
+			v, err = proto.Encode(nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = v
+	}
+
+	return proto.EncodeRawSlice(results...), nil
+}
+
+// getWithVersion returns a slice of the value and its version.
+func (s *server) getWithVersion(db *database, key string) ([]byte, error) {
+	val, version, err := db.store.GetWithVersion(key)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := proto.Encode(int(version))
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.EncodeRawSlice(val, v), nil
+}
+
+// keys returns all the keys matching pattern, empty pattern matches any key.
+func (s *server) keys(db *database, pattern string) []string {
+	keys := db.store.Keys()
+	if pattern == "" {
+		return keys
+	}
+
+	matched := keys[:0]
+	for _, k := range keys {
+		if glob.Match(pattern, k) {
+			matched = append(matched, k)
+		}
+	}
+
+	return matched
+}
+
+// scan returns a slice of the next cursor and a slice of keys found.
+func (s *server) scan(db *database, r *proto.Req) ([]byte, error) {
+	scanner, ok := db.store.(store.Scanner)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	keys, next := scanner.Scan(r.Cursor, r.Key, r.Count)
+
+	return proto.Encode([]interface{}{int(next), keys})
+}
+
+// incrBy changes an integer value and returns the result.
+func (s *server) incrBy(db *database, r *proto.Req) ([]byte, error) {
+	counter, ok := db.store.(store.Counter)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	delta := r.Delta
+	switch r.Cmd {
+	case proto.CmdIncr:
+		delta = 1
+	case proto.CmdDecr:
+		delta = -1
+	}
+
+	var n int64
+	err := s.write(db, r, func() (err error) {
+		n, err = counter.IncrBy(r.Key, delta)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Encode(int(n))
+}
+
+// expire runs commands inspecting and changing ttl of keys.
+func (s *server) expire(db *database, r *proto.Req) ([]byte, error) {
+	expirer, ok := db.store.(store.Expirer)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	switch r.Cmd {
+	case proto.CmdTTL:
+		ttl, err := expirer.TTL(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Encode(int(ttl))
+	case proto.CmdTouch:
+		return nil, expirer.Touch(r.Key)
+	case proto.CmdPersist:
+		return nil, s.write(db, r, func() error { return expirer.Persist(r.Key) })
+	}
+
+	at := r.At
+	if r.Cmd == proto.CmdExpire {
+		at = time.Now().Add(r.TTL)
+	}
+
+	return nil, s.write(db, r, func() error { return expirer.Expire(r.Key, at) })
+}
+
+// list runs list commands.
+func (s *server) list(db *database, r *proto.Req) (res []byte, err error) {
+	lister, ok := db.store.(store.Lister)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	switch r.Cmd {
+	case proto.CmdLRange:
+		vals, err := lister.Range(r.Key, r.Start, r.Stop)
+		if err != nil {
+			return nil, err
+		}
+		return proto.EncodeRawSlice(vals...), nil
+	case proto.CmdLLen:
+		n, err := lister.Len(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Encode(n)
+	case proto.CmdLPush, proto.CmdRPush:
+		vals, err := proto.SplitSlice(r.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		var n int
+		err = s.write(db, r, func() (err error) {
+			n, err = lister.Push(r.Key, r.Cmd == proto.CmdLPush, vals...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return proto.Encode(n)
+	case proto.CmdLPop, proto.CmdRPop:
+		err = s.write(db, r, func() (err error) {
+			res, err = lister.Pop(r.Key, r.Cmd == proto.CmdLPop)
+			return err
+		})
+		return res, err
+	}
+
+	return nil, s.write(db, r, func() error { return lister.Trim(r.Key, r.Start, r.Stop) })
+}
+
+// hash runs hash commands.
+func (s *server) hash(db *database, r *proto.Req) ([]byte, error) {
+	hasher, ok := db.store.(store.Hasher)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	switch r.Cmd {
+	case proto.CmdHGet:
+		return hasher.HGet(r.Key, r.Field)
+	case proto.CmdHKeys:
+		fields, err := hasher.HKeys(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		return proto.EncodeRawSlice(fields...), nil
+	case proto.CmdHLen:
+		n, err := hasher.HLen(r.Key)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Encode(n)
+	}
+
+	var fields [][]byte
+	if r.Cmd == proto.CmdHDel {
+		var err error
+		if fields, err = proto.SplitSlice(r.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	var n int64
+	err := s.write(db, r, func() (err error) {
+		switch r.Cmd {
+		case proto.CmdHSet:
+			var created bool
+			if created, err = hasher.HSet(r.Key, r.Field, r.Value); created {
+				n = 1
+			}
+		case proto.CmdHDel:
+			var deleted int
+			deleted, err = hasher.HDel(r.Key, fields...)
+			n = int64(deleted)
+		case proto.CmdHIncrBy:
+			n, err = hasher.HIncrBy(r.Key, r.Field, r.Delta)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Encode(int(n))
+}
+
 func makeListener(addr string) (*net.TCPListener, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
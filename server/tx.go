@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+)
+
+var (
+	// ErrNestedMulti returned when MULTI is sent while a transaction is started already.
+	ErrNestedMulti = errors.New("transaction already started")
+	// ErrNoMulti returned when EXEC or DISCARD is sent without MULTI.
+	ErrNoMulti = errors.New("no transaction started")
+	// ErrNotQueueable returned for commands which could not be a part of a transaction.
+	ErrNotQueueable = errors.New("command is not allowed in a transaction")
+)
+
+// batch collects write-ahead log records of a transaction until it commits.
+type batch struct {
+	records []*wal.Record
+}
+
+func (b *batch) add(r *wal.Record) {
+	b.records = append(b.records, r)
+}
+
+// processTx handles a request r of a connection c with a started transaction.
+func (s *server) processTx(c *session, r *proto.Req) ([]byte, error) {
+	switch r.Cmd {
+	case proto.CmdExec:
+		return s.exec(c)
+	case proto.CmdDiscard:
+		c.multi, c.queue, c.watched = false, nil, nil
+		return nil, nil
+	case proto.CmdMulti:
+		return nil, ErrNestedMulti
+	}
+
+	if !queueable(r.Cmd) {
+		return nil, ErrNotQueueable
+	}
+
+	c.queue = append(c.queue, r)
+	return proto.Encode("QUEUED")
+}
+
+// queueable reports if a command could be a part of a transaction, these are the commands of a single key.
+func queueable(cmd byte) bool {
+	switch cmd {
+	case proto.CmdGet, proto.CmdSet, proto.CmdSetNX, proto.CmdGetSet, proto.CmdUpdate, proto.CmdRemove,
+		proto.CmdGetWithVersion, proto.CmdCompareAndSwap, proto.CmdIncr, proto.CmdDecr, proto.CmdIncrBy,
+		proto.CmdLPush, proto.CmdRPush, proto.CmdLPop, proto.CmdRPop, proto.CmdLRange, proto.CmdLTrim, proto.CmdLLen,
+		proto.CmdHGet, proto.CmdHSet, proto.CmdHDel, proto.CmdHKeys, proto.CmdHLen, proto.CmdHIncrBy,
+		proto.CmdTTL, proto.CmdExpire, proto.CmdExpireAt, proto.CmdPersist, proto.CmdTouch,
+		proto.CmdSetSliding, proto.CmdSliding:
+		return true
+	}
+
+	return false
+}
+
+// watch remembers versions of keys, so EXEC could check they are not changed since.
+// A key watched already keeps its first version.
+func (s *server) watch(c *session, keys []string) error {
+	v, ok := c.db.store.(store.Versioner)
+	if !ok {
+		return proto.ErrUnsupportedCmd
+	}
+
+	if c.watched == nil {
+		c.watched = make(map[string]uint64, len(keys))
+	}
+
+	for _, k := range keys {
+		if _, ok := c.watched[k]; !ok {
+			c.watched[k] = v.Version(k)
+		}
+	}
+
+	return nil
+}
+
+// exec applies queued requests of a connection c atomically and returns a slice of their results,
+// see transaction.
+func (s *server) exec(c *session) ([]byte, error) {
+	queue, watched := c.queue, c.watched
+	c.multi, c.queue, c.watched = false, nil, nil
+
+	results, err := s.transaction(c.db, queue, watched)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.EncodeRawSlice(results...), nil
+}
+
+// transaction applies requests to a database atomically and returns their results.
+// All the keys involved are held by the store until every request is applied, nothing is applied
+// if any of them fails and the error is returned instead. The same happens with proto.ErrTxAborted
+// if any of watched keys is changed. A leader of a cluster holds the keys until the cluster commits the writes,
+// and if there are none, it confirms it is still the leader before returning the results.
+func (s *server) transaction(db *database, queue []*proto.Req, watched map[string]uint64) ([][]byte, error) {
+	tr, ok := db.store.(store.Transactor)
+	if !ok {
+		return nil, proto.ErrUnsupportedCmd
+	}
+
+	keys := make([]string, 0, len(queue)+len(watched))
+	for _, r := range queue {
+		if len(r.Keys) > 0 {
+			keys = append(keys, r.Keys...)
+			continue
+		}
+		keys = append(keys, r.Key)
+	}
+	for k := range watched {
+		keys = append(keys, k)
+	}
+
+	txdb := &database{index: db.index, pending: &batch{}}
+	results := make([][]byte, len(queue))
+
+	err := s.commit(func() error {
+		if s.cluster != nil {
+			if err := s.cluster.begin(); err != nil {
+				return err
+			}
+			defer s.cluster.end()
+		}
+
+		return tr.Transaction(keys, func(tx store.Store) error {
+			txdb.store = tx
+			txc := &session{db: txdb}
+
+			for k, v := range watched {
+				if tx.(store.Versioner).Version(k) != v {
+					return proto.ErrTxAborted
+				}
+			}
+
+			for i, r := range queue {
+				v, err := s.processRequest(txc, r)
+				if err != nil {
+					return err
+				}
+
+				if len(v) == 0 {
+					if v, err = proto.Encode(nil); err != nil {
+						return err
+					}
+				}
+				results[i] = v
+			}
+
+			if len(txdb.pending.records) == 0 {
+				return nil
+			}
+			if s.cluster != nil {
+				return s.cluster.replicate(true, txdb.pending.records...)
+			}
+
+			// The writes are logged while the keys are held, so they are rolled back if logging fails.
+			return s.appendLog(txdb.pending.records...)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cluster != nil && len(txdb.pending.records) == 0 {
+		if err = s.cluster.confirm(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// commit applies a transaction with fn, which appends records of all its writes at once to write-ahead log
+// and the replication backlog, or has them replicated by a cluster, so nothing else gets logged in between.
+func (s *server) commit(fn func() error) error {
+	if !s.logged() {
+		return fn()
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return fn()
+}
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"math"
+
+	"github.com/aliaksandrb/cachy/proto"
+)
+
+// Incr adds delta to a raw encoded integer value val, nil is considered to be 0.
+// It returns the result along with its encoding, so stores could implement Counter.
+func Incr(val []byte, delta int64) (n int64, encoded []byte, err error) {
+	if val != nil {
+		i, err := proto.DecodeInt(val)
+		if err != nil {
+			return 0, nil, ErrNotInteger
+		}
+		n = int64(i)
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, nil, ErrOverflow
+	}
+	n += delta
+
+	encoded, err = proto.Encode(int(n))
+	return n, encoded, err
+}
@@ -0,0 +1,668 @@
+package dstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+const logName = "cachy.log"
+
+// minCompactSize is the amount of stale bytes in the log below which compaction never happens.
+const minCompactSize int64 = 4 << 20
+
+// purgeInterval is how often writes scan the index for expired keys.
+const purgeInterval = time.Second
+
+var zeroTime = time.Time{}
+
+// New returns disk-backed implementation of store.Store.
+// All the writes are appended to a log file inside dir in the record format of wal package,
+// while an in-memory index keeps offsets of the latest values, so the data survives a restart.
+func New(dir string) (store.Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &dStore{
+		path:  filepath.Join(dir, logName),
+		index: make(map[string]*item),
+		// Versions are not persisted, they start from the current time,
+		// so ones handed out before a restart never match again.
+		version: uint64(time.Now().UnixNano()),
+	}
+
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// dStore implements store.Store.
+type dStore struct {
+	path  string
+	f     *os.File
+	size  int64
+	stale int64
+	index map[string]*item
+	// version is the last version given to an item.
+	version uint64
+	// purged is when expired keys were dropped from the index last time.
+	purged time.Time
+	// failed is set once a failed append leaves a part of its record in the log, see append.
+	failed bool
+
+	mu sync.RWMutex
+}
+
+// item points to a value stored in the log.
+type item struct {
+	off     int64
+	size    int
+	ttl     time.Time
+	version uint64
+}
+
+// remaining returns the time left before expiration, 0 if there is no ttl.
+func (i *item) remaining() time.Duration {
+	if i.ttl == zeroTime {
+		return 0
+	}
+
+	if d := time.Until(i.ttl); d > 0 {
+		return d
+	}
+
+	return 1
+}
+
+func (i *item) expired() bool {
+	return i.ttl != zeroTime && time.Now().After(i.ttl)
+}
+
+func (d *dStore) open() error {
+	f, err := os.OpenFile(d.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, err := d.load(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	d.f = f
+	d.size = size
+
+	return nil
+}
+
+// load replays the log building the index and returns the offset of the last valid record.
+// A torn or corrupted tail is truncated.
+func (d *dStore) load(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+
+	var off int64
+	for {
+		rec, n, err := wal.ReadRecord(r)
+		if err == io.EOF {
+			break
+		}
+
+		if err == nil && rec.Op != wal.OpSet && rec.Op != wal.OpRemove {
+			err = fmt.Errorf("%v: unexpected op %d", wal.ErrBadRecord, rec.Op)
+		}
+
+		if err != nil {
+			log.Err("corrupted log tail at %d, truncating: %v", off, err)
+			if err = f.Truncate(off); err != nil {
+				return 0, err
+			}
+			break
+		}
+
+		if old, ok := d.index[rec.Key]; ok {
+			d.stale += int64(wal.HeaderSize + len(rec.Key) + old.size)
+			delete(d.index, rec.Key)
+		}
+
+		if rec.Op == wal.OpSet && (rec.TTL == zeroTime || time.Now().Before(rec.TTL)) {
+			d.version++
+			d.index[rec.Key] = &item{
+				off:     off + int64(n-len(rec.Val)),
+				size:    len(rec.Val),
+				ttl:     rec.TTL,
+				version: d.version,
+			}
+		} else {
+			d.stale += int64(n)
+		}
+
+		off += int64(n)
+	}
+
+	return off, nil
+}
+
+// Close syncs and closes the underlying log file.
+func (d *dStore) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.f.Sync(); err != nil {
+		return err
+	}
+
+	return d.f.Close()
+}
+
+func getTTL(t time.Duration) time.Time {
+	if t == 0 {
+		return zeroTime
+	}
+
+	return time.Now().Add(t)
+}
+
+// Get implements store.Store.
+func (d *dStore) Get(key string) (val []byte, err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return nil, store.ErrNotFound
+	}
+
+	return d.read(key, i)
+}
+
+// GetWithVersion implements store.Store.
+func (d *dStore) GetWithVersion(key string) (val []byte, version uint64, err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return nil, 0, store.ErrNotFound
+	}
+
+	if val, err = d.read(key, i); err != nil {
+		return nil, 0, err
+	}
+
+	return val, i.version, nil
+}
+
+// Peek implements store.Peeker, sliding ttl is not supported, so it is always 0.
+func (d *dStore) Peek(key string) (val []byte, ttl, sliding time.Duration, err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return nil, 0, 0, store.ErrNotFound
+	}
+
+	if val, err = d.read(key, i); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return val, i.remaining(), 0, nil
+}
+
+// read reads a value pointed by an item i of a key.
+func (d *dStore) read(key string, i *item) ([]byte, error) {
+	val := make([]byte, i.size)
+	if _, err := d.f.ReadAt(val, i.off); err != nil {
+		log.Err("unable to read value for %q: %v", key, err)
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// Set implements store.Store.
+func (d *dStore) Set(key string, val []byte, t time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.set(key, val, getTTL(t))
+}
+
+// SetNX implements store.Store.
+func (d *dStore) SetNX(key string, val []byte, t time.Duration) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i, ok := d.index[key]; ok && !i.expired() {
+		return false, nil
+	}
+
+	return true, d.set(key, val, getTTL(t))
+}
+
+// GetSet implements store.Store.
+func (d *dStore) GetSet(key string, val []byte, t time.Duration) (old []byte, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i, ok := d.index[key]; ok && !i.expired() {
+		if old, err = d.read(key, i); err != nil {
+			return nil, err
+		}
+	}
+
+	return old, d.set(key, val, getTTL(t))
+}
+
+// Update implements store.Store.
+func (d *dStore) Update(key string, val []byte, t time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return store.ErrNotFound
+	}
+
+	return d.set(key, val, getTTL(t))
+}
+
+// Remove implements store.Store.
+func (d *dStore) Remove(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[key]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	return d.remove(key, i)
+}
+
+// remove removes a key pointed by an item i.
+func (d *dStore) remove(key string, i *item) error {
+	n, err := d.append(&wal.Record{Op: wal.OpRemove, Key: key})
+	if err != nil {
+		return err
+	}
+
+	delete(d.index, key)
+	d.stale += int64(wal.HeaderSize+len(key)+i.size) + int64(n)
+
+	return d.maybeCompact()
+}
+
+// CompareAndSwap implements store.Store.
+func (d *dStore) CompareAndSwap(key string, val []byte, version uint64, t time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return store.ErrNotFound
+	}
+
+	if i.version != version {
+		return store.ErrVersionMismatch
+	}
+
+	return d.set(key, val, getTTL(t))
+}
+
+// IncrBy implements store.Counter.
+func (d *dStore) IncrBy(key string, delta int64) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var val []byte
+	ttl := zeroTime
+
+	if i, ok := d.index[key]; ok && !i.expired() {
+		var err error
+		if val, err = d.read(key, i); err != nil {
+			return 0, err
+		}
+		ttl = i.ttl
+	}
+
+	n, val, err := store.Incr(val, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, d.set(key, val, ttl)
+}
+
+// TTL implements store.Expirer.
+func (d *dStore) TTL(key string) (time.Duration, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return 0, store.ErrNotFound
+	}
+
+	return i.remaining(), nil
+}
+
+// Expire implements store.Expirer, the value is appended again with the new ttl.
+func (d *dStore) Expire(key string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return store.ErrNotFound
+	}
+
+	if !at.After(time.Now()) {
+		return d.remove(key, i)
+	}
+
+	return d.retime(key, i, at)
+}
+
+// Persist implements store.Expirer, the value is appended again without ttl.
+func (d *dStore) Persist(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.index[key]
+	if !ok || i.expired() {
+		return store.ErrNotFound
+	}
+
+	if i.ttl == zeroTime {
+		return nil
+	}
+
+	return d.retime(key, i, zeroTime)
+}
+
+// retime sets a new ttl of a key pointed by an item i.
+func (d *dStore) retime(key string, i *item, ttl time.Time) error {
+	val, err := d.read(key, i)
+	if err != nil {
+		return err
+	}
+
+	return d.set(key, val, ttl)
+}
+
+// Touch implements store.Expirer, there is no eviction, so it only checks the key exists.
+func (d *dStore) Touch(key string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if i, ok := d.index[key]; !ok || i.expired() {
+		return store.ErrNotFound
+	}
+
+	return nil
+}
+
+// Keys implements store.Store.
+func (d *dStore) Keys() (keys []string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for k, i := range d.index {
+		if i.expired() {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	return
+}
+
+// Flush implements store.Flusher.
+func (d *dStore) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := d.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	d.index = make(map[string]*item)
+	d.size = 0
+	d.stale = 0
+	d.failed = false
+
+	return d.f.Sync()
+}
+
+// Size implements store.Sizer, expired keys are never counted.
+func (d *dStore) Size() (n int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, i := range d.index {
+		if !i.expired() {
+			n++
+		}
+	}
+
+	return
+}
+
+// Snapshot implements store.Snapshotter.
+func (d *dStore) Snapshot(w io.Writer) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	sw, err := store.NewSnapshotWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for k, i := range d.index {
+		if i.expired() {
+			continue
+		}
+
+		val := make([]byte, i.size)
+		if _, err = d.f.ReadAt(val, i.off); err != nil {
+			return err
+		}
+
+		if err = sw.Write(k, val, i.remaining(), 0); err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// Restore implements store.Snapshotter, sliding ttl is not supported, so keys get their remaining ttl only.
+func (d *dStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, func(key string, val []byte, ttl, _ time.Duration) error {
+		return d.Set(key, val, ttl)
+	})
+}
+
+func (d *dStore) set(key string, val []byte, ttl time.Time) error {
+	off := d.size
+
+	n, err := d.append(&wal.Record{Op: wal.OpSet, Key: key, Val: val, TTL: ttl})
+	if err != nil {
+		return err
+	}
+
+	if old, ok := d.index[key]; ok {
+		d.stale += int64(wal.HeaderSize + len(key) + old.size)
+	}
+
+	d.version++
+	d.index[key] = &item{
+		off:     off + int64(n-len(val)),
+		size:    len(val),
+		ttl:     ttl,
+		version: d.version,
+	}
+
+	return d.maybeCompact()
+}
+
+// append writes a record at the end of the log. If the write fails, the log is truncated back,
+// so the next records are not written after a torn one, wal.ErrLogFailed once it could not be.
+func (d *dStore) append(rec *wal.Record) (int, error) {
+	if d.failed {
+		return 0, wal.ErrLogFailed
+	}
+
+	b := rec.Encode()
+
+	n, err := d.f.Write(b)
+	if err != nil {
+		log.Err("unable to append to log: %v", err)
+		d.truncate()
+		return 0, err
+	}
+
+	d.size += int64(n)
+	return n, nil
+}
+
+// truncate cuts off whatever a failed append left after the last record, or marks the log failed.
+func (d *dStore) truncate() {
+	err := d.f.Truncate(d.size)
+	if err == nil {
+		_, err = d.f.Seek(d.size, io.SeekStart)
+	}
+	if err != nil {
+		log.Err("unable to truncate log after a failed append: %v", err)
+		d.failed = true
+	}
+}
+
+func (d *dStore) maybeCompact() error {
+	d.purgeExpired()
+
+	if d.stale < minCompactSize || d.stale < d.size/2 {
+		return nil
+	}
+
+	return d.compact()
+}
+
+// purgeExpired drops expired keys from the index counting their records as stale,
+// so they are reclaimed by the next compaction. The index is scanned once per purgeInterval at most.
+// It is called with write lock held.
+func (d *dStore) purgeExpired() {
+	now := time.Now()
+	if now.Sub(d.purged) < purgeInterval {
+		return
+	}
+	d.purged = now
+
+	for k, i := range d.index {
+		if i.expired() {
+			d.stale += int64(wal.HeaderSize + len(k) + i.size)
+			delete(d.index, k)
+		}
+	}
+}
+
+// compact rewrites the log keeping only live values.
+// It is called with write lock held.
+func (d *dStore) compact() error {
+	tmpPath := d.path + ".compact"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	index, size, err := d.writeLive(tmp)
+	if err == nil {
+		err = os.Rename(tmpPath, d.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	d.f.Close()
+	d.f = tmp
+	d.size = size
+	d.stale = 0
+	d.index = index
+	d.failed = false
+
+	// The rename is durable only once the directory is synced, the old log is gone anyway.
+	if err = syncDir(filepath.Dir(d.path)); err != nil {
+		return err
+	}
+
+	log.Info("log compacted, size: %d", size)
+	return nil
+}
+
+// syncDir syncs a directory, so the entries renamed in it survive a crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+// writeLive writes all the live values into f and returns a new index for it.
+func (d *dStore) writeLive(f *os.File) (index map[string]*item, off int64, err error) {
+	w := bufio.NewWriter(f)
+	index = make(map[string]*item, len(d.index))
+
+	for k, i := range d.index {
+		if i.expired() {
+			continue
+		}
+
+		val := make([]byte, i.size)
+		if _, err = d.f.ReadAt(val, i.off); err != nil {
+			return nil, 0, err
+		}
+
+		b := (&wal.Record{Op: wal.OpSet, Key: k, Val: val, TTL: i.ttl}).Encode()
+		if _, err = w.Write(b); err != nil {
+			return nil, 0, err
+		}
+
+		index[k] = &item{off: off + int64(len(b)-len(val)), size: i.size, ttl: i.ttl, version: i.version}
+		off += int64(len(b))
+	}
+
+	if err = w.Flush(); err != nil {
+		return nil, 0, err
+	}
+
+	return index, off, f.Sync()
+}
@@ -0,0 +1,305 @@
+package dstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+	"github.com/aliaksandrb/cachy/wal"
+)
+
+func TestPersistence(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+
+	checkErr(t, db.Set("key", []byte("$\"value\""), 0))
+	checkErr(t, db.Set("removed", []byte("&1"), 0))
+	checkErr(t, db.Set("updated", []byte("&1"), 0))
+	checkErr(t, db.Update("updated", []byte("&2"), 0))
+	checkErr(t, db.Set("expired", []byte("&3"), time.Millisecond))
+	checkErr(t, db.Remove("removed"))
+	checkErr(t, db.(*dStore).Close())
+
+	time.Sleep(5 * time.Millisecond)
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	for i, tc := range []struct {
+		key  string
+		want []byte
+		err  error
+	}{
+		{key: "key", want: []byte("$\"value\"")},
+		{key: "updated", want: []byte("&2")},
+		{key: "removed", err: store.ErrNotFound},
+		{key: "expired", err: store.ErrNotFound},
+	} {
+		got, err := db.Get(tc.key)
+		if err != tc.err {
+			t.Errorf("[%d] %s: got error %v, want %v", i, tc.key, err, tc.err)
+			continue
+		}
+
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("[%d] %s: got %q, want %q", i, tc.key, got, tc.want)
+		}
+	}
+
+	keys := db.Keys()
+	sort.Strings(keys)
+	if want := []string{"key", "updated"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+
+	checkErr(t, db.Set("key", []byte("&1"), 0))
+	_, version, err := db.GetWithVersion("key")
+	checkErr(t, err)
+
+	checkErr(t, db.CompareAndSwap("key", []byte("&2"), version, 0))
+	if err = db.CompareAndSwap("key", []byte("&3"), version, 0); err != store.ErrVersionMismatch {
+		t.Errorf("stale version should not be swapped, got error: %v", err)
+	}
+	checkErr(t, db.(*dStore).Close())
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	val, reopened, err := db.GetWithVersion("key")
+	checkErr(t, err)
+	if !bytes.Equal(val, []byte("&2")) || reopened <= version {
+		t.Errorf("versions should keep growing after reopening, got %d after %d, value %q", reopened, version, val)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	e := db.(store.Expirer)
+
+	checkErr(t, db.Set("expiring", []byte("&1"), 0))
+	checkErr(t, db.Set("persistent", []byte("&2"), time.Hour))
+	checkErr(t, db.Set("removed", []byte("&3"), 0))
+
+	checkErr(t, e.Expire("expiring", time.Now().Add(time.Hour)))
+	checkErr(t, e.Persist("persistent"))
+	checkErr(t, e.Expire("removed", time.Now().Add(-time.Second)))
+	checkErr(t, db.(*dStore).Close())
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+	e = db.(store.Expirer)
+
+	if ttl, err := e.TTL("expiring"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl should be kept after reopening, got %v, err: %v", ttl, err)
+	}
+	if val, _ := db.Get("expiring"); !bytes.Equal(val, []byte("&1")) {
+		t.Errorf("value should be kept, got %q", val)
+	}
+	if ttl, err := e.TTL("persistent"); err != nil || ttl != 0 {
+		t.Errorf("ttl should be removed, got %v, err: %v", ttl, err)
+	}
+	if err = e.Touch("removed"); err != store.ErrNotFound {
+		t.Errorf("key should be removed by ttl in the past, got: %v", err)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	checkErr(t, db.Set("key", []byte("&1"), 0))
+	checkErr(t, db.Set("torn", []byte("&2"), 0))
+	checkErr(t, db.(*dStore).Close())
+
+	path := filepath.Join(dir, logName)
+	info, err := os.Stat(path)
+	checkErr(t, err)
+	checkErr(t, os.Truncate(path, info.Size()-1))
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	if _, err = db.Get("key"); err != nil {
+		t.Errorf("intact record should survive, got error: %v", err)
+	}
+
+	if _, err = db.Get("torn"); err != store.ErrNotFound {
+		t.Errorf("torn record should be dropped, got error: %v", err)
+	}
+
+	checkErr(t, db.Set("next", []byte("&3"), 0))
+	if got, err := db.Get("next"); err != nil || !bytes.Equal(got, []byte("&3")) {
+		t.Errorf("should append after truncated tail, got %q, error: %v", got, err)
+	}
+}
+
+func TestFailedAppend(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	checkErr(t, db.Set("key", []byte("&1"), 0))
+
+	// A read-only file fails both the append and the truncation after it.
+	d := db.(*dStore)
+	f := d.f
+	d.f, err = os.Open(d.path)
+	checkErr(t, err)
+
+	if err = db.Set("failed", []byte("&2"), 0); err == nil {
+		t.Fatal("append to a read-only file should fail")
+	}
+	if err = db.Set("next", []byte("&3"), 0); err != wal.ErrLogFailed {
+		t.Errorf("should be error: got: %v, want: %v", err, wal.ErrLogFailed)
+	}
+
+	d.f.Close()
+	d.f = f
+	checkErr(t, d.Close())
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	if got := db.Keys(); !reflect.DeepEqual(got, []string{"key"}) {
+		t.Errorf("only appended records should be replayed, got %q", got)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	checkErr(t, db.Set("key", []byte("&1"), 0))
+	checkErr(t, db.Set("other", []byte("&2"), 0))
+	checkErr(t, db.(*dStore).Flush())
+	checkErr(t, db.Set("next", []byte("&3"), 0))
+	checkErr(t, db.(*dStore).Close())
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	if n := db.(*dStore).Size(); n != 1 {
+		t.Errorf("only keys written after flush should survive, got %d keys", n)
+	}
+
+	if got, err := db.Get("next"); err != nil || !bytes.Equal(got, []byte("&3")) {
+		t.Errorf("should append after flush, got %q, error: %v", got, err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	d := db.(*dStore)
+
+	for i := 0; i < 10; i++ {
+		checkErr(t, db.Set("key", bytes.Repeat([]byte{'a'}, 100), 0))
+	}
+	checkErr(t, db.Set("gone", []byte("&1"), 0))
+	checkErr(t, db.Remove("gone"))
+
+	d.mu.Lock()
+	err = d.compact()
+	d.mu.Unlock()
+	checkErr(t, err)
+	checkErr(t, d.Close())
+
+	info, err := os.Stat(filepath.Join(dir, logName))
+	checkErr(t, err)
+	if want := int64(wal.HeaderSize + len("key") + 100); info.Size() != want {
+		t.Errorf("compacted log should keep only live values, got size %d, want %d", info.Size(), want)
+	}
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+
+	if got, err := db.Get("key"); err != nil || len(got) != 100 {
+		t.Errorf("value should survive compaction, got %q, error: %v", got, err)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	dir := tempDir(t)
+
+	db, err := New(dir)
+	checkErr(t, err)
+	d := db.(*dStore)
+
+	checkErr(t, db.Set("key", []byte("&1"), 0))
+	checkErr(t, db.Set("expired", bytes.Repeat([]byte{'a'}, 100), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	if n := d.Size(); n != 1 {
+		t.Errorf("expired key should not be counted, got size %d", n)
+	}
+
+	d.mu.Lock()
+	d.purged = zeroTime
+	d.purgeExpired()
+	_, indexed := d.index["expired"]
+	stale := d.stale
+	d.mu.Unlock()
+
+	if indexed {
+		t.Error("expired key should be dropped from the index")
+	}
+	if want := int64(wal.HeaderSize + len("expired") + 100); stale != want {
+		t.Errorf("expired record should be counted as stale, got %d, want %d", stale, want)
+	}
+	checkErr(t, d.Close())
+
+	db, err = New(dir)
+	checkErr(t, err)
+	defer db.(*dStore).Close()
+	d = db.(*dStore)
+
+	if _, ok := d.index["expired"]; ok || d.stale != stale {
+		t.Errorf("expired record should be stale after reopening, got stale %d, want %d", d.stale, stale)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "dstore")
+	checkErr(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
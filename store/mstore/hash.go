@@ -0,0 +1,291 @@
+package mstore
+
+import (
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+)
+
+// hash is a map of raw encoded fields to raw encoded values. A map value is turned into it
+// by the first hash command, so following ones do not parse the value again.
+type hash struct {
+	fields map[string][]byte
+	// bytes is a total size of fields and values.
+	bytes int64
+}
+
+func newHash(val []byte) (*hash, error) {
+	keys, vals, err := proto.SplitMap(val)
+	if err != nil {
+		return nil, store.ErrNotMap
+	}
+
+	h := &hash{fields: make(map[string][]byte, len(keys))}
+	for i := range keys {
+		h.set(keys[i], vals[i])
+	}
+
+	return h, nil
+}
+
+func (h *hash) get(field []byte) ([]byte, bool) {
+	v, ok := h.fields[string(field)]
+	return v, ok
+}
+
+// set sets a value of a field and reports whether the field is new.
+func (h *hash) set(field, val []byte) bool {
+	old, ok := h.fields[string(field)]
+	if ok {
+		h.bytes -= int64(len(old))
+	} else {
+		h.bytes += int64(len(field))
+	}
+
+	h.fields[string(field)] = val
+	h.bytes += int64(len(val))
+
+	return !ok
+}
+
+func (h *hash) del(field []byte) bool {
+	v, ok := h.fields[string(field)]
+	if !ok {
+		return false
+	}
+
+	delete(h.fields, string(field))
+	h.bytes -= int64(len(field) + len(v))
+
+	return true
+}
+
+func (h *hash) len() int {
+	return len(h.fields)
+}
+
+func (h *hash) size() int64 {
+	return h.bytes
+}
+
+func (h *hash) encode() []byte {
+	keys := make([][]byte, 0, len(h.fields))
+	vals := make([][]byte, 0, len(h.fields))
+	for k, v := range h.fields {
+		keys = append(keys, []byte(k))
+		vals = append(vals, v)
+	}
+
+	return proto.EncodeRawMap(keys, vals)
+}
+
+// hashEntry returns a live entry of a key with its value turned into a map and the map itself,
+// nil if the key is missed. It is called with write lock held.
+func (b *bucket) hashEntry(key string) (*entry, *hash, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return nil, nil, nil
+	}
+
+	if e.obj == nil {
+		h, err := newHash(e.val)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.convert(key, e, h)
+	}
+
+	h, ok := e.obj.(*hash)
+	if !ok {
+		return nil, nil, store.ErrNotMap
+	}
+
+	return e, h, nil
+}
+
+// readHash returns a map of a live entry of a key, nil if the key is missed.
+// A value which is not a map yet is parsed without being stored, as only read lock is held.
+func (b *bucket) readHash(key string) (*hash, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return nil, nil
+	}
+
+	if e.obj == nil {
+		return newHash(e.val)
+	}
+
+	h, ok := e.obj.(*hash)
+	if !ok {
+		return nil, store.ErrNotMap
+	}
+
+	return h, nil
+}
+
+// HGet implements store.Hasher.
+func (m *mStore) HGet(key string, field []byte) ([]byte, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.hget(b, key, field)
+}
+
+// hget is HGet working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) hget(b *bucket, key string, field []byte) ([]byte, error) {
+	h, err := b.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if h == nil {
+		return nil, store.ErrNotFound
+	}
+
+	v, ok := h.get(field)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	b.touch(key)
+
+	return v, nil
+}
+
+// HSet implements store.Hasher.
+func (m *mStore) HSet(key string, field, val []byte) (created bool, err error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.hset(b, key, field, val)
+}
+
+// hset is HSet working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) hset(b *bucket, key string, field, val []byte) (created bool, err error) {
+	e, h, err := b.hashEntry(key)
+	if err != nil {
+		return false, err
+	}
+
+	if e == nil {
+		h = &hash{fields: make(map[string][]byte)}
+		h.set(field, val)
+		b.put(key, m.newObjectEntry(h))
+
+		return true, nil
+	}
+
+	m.modify(b, key, e, func() { created = h.set(field, val) })
+
+	return created, nil
+}
+
+// HDel implements store.Hasher.
+func (m *mStore) HDel(key string, fields ...[]byte) (n int, err error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.hdel(b, key, fields...)
+}
+
+// hdel is HDel working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) hdel(b *bucket, key string, fields ...[]byte) (n int, err error) {
+	e, h, err := b.hashEntry(key)
+	if err != nil || e == nil {
+		return 0, err
+	}
+
+	m.modify(b, key, e, func() {
+		for _, f := range fields {
+			if h.del(f) {
+				n++
+			}
+		}
+	})
+
+	if h.len() == 0 {
+		b.delete(key)
+	}
+
+	return n, nil
+}
+
+// HKeys implements store.Hasher.
+func (m *mStore) HKeys(key string) ([][]byte, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.hkeys(b, key)
+}
+
+// hkeys is HKeys working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) hkeys(b *bucket, key string) ([][]byte, error) {
+	h, err := b.readHash(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+
+	b.touch(key)
+
+	fields := make([][]byte, 0, h.len())
+	for f := range h.fields {
+		fields = append(fields, []byte(f))
+	}
+
+	return fields, nil
+}
+
+// HLen implements store.Hasher.
+func (m *mStore) HLen(key string) (int, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.hlen(b, key)
+}
+
+// hlen is HLen working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) hlen(b *bucket, key string) (int, error) {
+	h, err := b.readHash(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+
+	return h.len(), nil
+}
+
+// HIncrBy implements store.Hasher.
+func (m *mStore) HIncrBy(key string, field []byte, delta int64) (int64, error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.hincrBy(b, key, field, delta)
+}
+
+// hincrBy is HIncrBy working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) hincrBy(b *bucket, key string, field []byte, delta int64) (int64, error) {
+	e, h, err := b.hashEntry(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var old []byte
+	if h != nil {
+		old, _ = h.get(field)
+	}
+
+	n, val, err := store.Incr(old, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	if e == nil {
+		h = &hash{fields: make(map[string][]byte)}
+		h.set(field, val)
+		b.put(key, m.newObjectEntry(h))
+
+		return n, nil
+	}
+
+	m.modify(b, key, e, func() { h.set(field, val) })
+
+	return n, nil
+}
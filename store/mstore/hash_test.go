@@ -0,0 +1,102 @@
+package mstore
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+)
+
+func TestHash(t *testing.T) {
+	s, _ := New(3, 1)
+	h := s.(store.Hasher)
+
+	s.Set("session", []byte(":2\n$\"name\"\n$\"kermit\"\n$\"visits\"\n&1"), 0)
+
+	for i, tc := range []struct {
+		desc string
+		run  func() (interface{}, error)
+		want interface{}
+		err  error
+	}{
+		{
+			desc: "get a field of a stored map",
+			run:  func() (interface{}, error) { return h.HGet("session", []byte("$\"name\"")) },
+			want: []byte("$\"kermit\""),
+		}, {
+			desc: "set a new field",
+			run:  func() (interface{}, error) { return h.HSet("session", []byte("&1"), []byte("@1\n&2")) },
+			want: true,
+		}, {
+			desc: "set an existing field",
+			run:  func() (interface{}, error) { return h.HSet("session", []byte("$\"name\""), []byte("$\"piggy\"")) },
+			want: false,
+		}, {
+			desc: "incr a field",
+			run:  func() (interface{}, error) { return h.HIncrBy("session", []byte("$\"visits\""), 2) },
+			want: int64(3),
+		}, {
+			desc: "incr a missed field",
+			run:  func() (interface{}, error) { return h.HIncrBy("session", []byte("$\"likes\""), -1) },
+			want: int64(-1),
+		}, {
+			desc: "incr a string",
+			run:  func() (interface{}, error) { return h.HIncrBy("session", []byte("$\"name\""), 1) },
+			err:  store.ErrNotInteger,
+		}, {
+			desc: "len",
+			run:  func() (interface{}, error) { return h.HLen("session") },
+			want: 4,
+		}, {
+			desc: "del",
+			run:  func() (interface{}, error) { return h.HDel("session", []byte("&1"), []byte("$\"missed\"")) },
+			want: 1,
+		}, {
+			desc: "get a missed field",
+			run:  func() (interface{}, error) { return h.HGet("session", []byte("&1")) },
+			err:  store.ErrNotFound,
+		}, {
+			desc: "get a missed key",
+			run:  func() (interface{}, error) { return h.HGet("missed", []byte("&1")) },
+			err:  store.ErrNotFound,
+		}, {
+			desc: "set a field of a slice",
+			run: func() (interface{}, error) {
+				s.Set("slice", []byte("@0"), 0)
+				return h.HSet("slice", []byte("&1"), []byte("&1"))
+			},
+			err: store.ErrNotMap,
+		},
+	} {
+		got, err := tc.run()
+		if err != tc.err {
+			t.Errorf("[%d] %s: unexpected error: got %v, want %v", i, tc.desc, err, tc.err)
+			continue
+		}
+
+		if tc.err == nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("[%d] %s: got %q, want %q", i, tc.desc, got, tc.want)
+		}
+	}
+
+	fields, _ := h.HKeys("session")
+	sort.Slice(fields, func(i, j int) bool { return bytes.Compare(fields[i], fields[j]) < 0 })
+	if want := [][]byte{[]byte("$\"likes\""), []byte("$\"name\""), []byte("$\"visits\"")}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("unexpected fields: got %q, want %q", fields, want)
+	}
+
+	val, _ := s.Get("session")
+	got, err := proto.NewDecoder().Decode(proto.NewScanner(bytes.NewReader(val)))
+	want := map[interface{}]interface{}{"likes": -1, "name": "piggy", "visits": 3}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("map should be encoded back, got %v, want %v, error: %v", got, want, err)
+	}
+
+	h.HDel("session", []byte("$\"likes\""), []byte("$\"name\""), []byte("$\"visits\""))
+	if _, err = s.Get("session"); err != store.ErrNotFound {
+		t.Errorf("empty map should be removed, got error: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package mstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lfu keeps keys grouped by their usage count.
+// Keys used equally often are evicted in least recently used order.
+type lfu struct {
+	items map[string]*lfuItem
+	freqs map[int]*list.List
+	min   int
+
+	mu sync.Mutex
+}
+
+type lfuItem struct {
+	freq int
+	el   *list.Element
+}
+
+func newLFU() *lfu {
+	return &lfu{
+		items: make(map[string]*lfuItem),
+		freqs: make(map[int]*list.List),
+	}
+}
+
+// add implements policy.
+func (l *lfu) add(key string, _ *entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if it, ok := l.items[key]; ok {
+		l.inc(key, it)
+		return
+	}
+
+	l.items[key] = &lfuItem{freq: 1, el: l.list(1).PushFront(key)}
+	l.min = 1
+}
+
+// access implements policy.
+func (l *lfu) access(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if it, ok := l.items[key]; ok {
+		l.inc(key, it)
+	}
+}
+
+// retime implements policy, ttl doesn't matter to it.
+func (l *lfu) retime(string, *entry) {}
+
+// remove implements policy.
+func (l *lfu) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	it, ok := l.items[key]
+	if !ok {
+		return
+	}
+
+	delete(l.items, key)
+	l.unlink(it)
+
+	if _, ok = l.freqs[l.min]; !ok {
+		l.min = l.minFreq()
+	}
+}
+
+// victim implements policy.
+func (l *lfu) victim() (key string, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ll, ok := l.freqs[l.min]
+	if !ok {
+		return "", false
+	}
+
+	return ll.Back().Value.(string), true
+}
+
+func (l *lfu) list(freq int) *list.List {
+	ll, ok := l.freqs[freq]
+	if !ok {
+		ll = list.New()
+		l.freqs[freq] = ll
+	}
+
+	return ll
+}
+
+func (l *lfu) unlink(it *lfuItem) {
+	ll := l.freqs[it.freq]
+	ll.Remove(it.el)
+
+	if ll.Len() == 0 {
+		delete(l.freqs, it.freq)
+	}
+}
+
+func (l *lfu) inc(key string, it *lfuItem) {
+	l.unlink(it)
+
+	if _, ok := l.freqs[it.freq]; !ok && l.min == it.freq {
+		l.min = it.freq + 1
+	}
+
+	it.freq++
+	it.el = l.list(it.freq).PushFront(key)
+}
+
+func (l *lfu) minFreq() int {
+	min := 0
+	for f := range l.freqs {
+		if min == 0 || f < min {
+			min = f
+		}
+	}
+
+	return min
+}
@@ -0,0 +1,303 @@
+package mstore
+
+import (
+	"github.com/aliaksandrb/cachy/proto"
+	"github.com/aliaksandrb/cachy/store"
+)
+
+// deque is a list of raw encoded elements. A slice value is turned into it by the first list command,
+// so following ones do not parse the value again.
+type deque struct {
+	// items[head:] are the elements, the room before head is used to push to the front.
+	items [][]byte
+	head  int
+	// bytes is a total size of elements.
+	bytes int64
+}
+
+func newDeque(val []byte) (*deque, error) {
+	items, err := proto.SplitSlice(val)
+	if err != nil {
+		return nil, store.ErrNotList
+	}
+
+	l := &deque{items: items}
+	for _, v := range items {
+		l.bytes += int64(len(v))
+	}
+
+	return l, nil
+}
+
+func (l *deque) len() int {
+	return len(l.items) - l.head
+}
+
+// pushFront adds vals to the front one by one, so the last one becomes the first element.
+func (l *deque) pushFront(vals ...[]byte) {
+	if l.head < len(vals) {
+		n := l.len()
+		room := n + len(vals)
+		items := make([][]byte, room+n)
+		copy(items[room:], l.items[l.head:])
+		l.items, l.head = items, room
+	}
+
+	for _, v := range vals {
+		l.head--
+		l.items[l.head] = v
+		l.bytes += int64(len(v))
+	}
+}
+
+func (l *deque) pushBack(vals ...[]byte) {
+	l.items = append(l.items, vals...)
+	for _, v := range vals {
+		l.bytes += int64(len(v))
+	}
+}
+
+func (l *deque) popFront() []byte {
+	v := l.items[l.head]
+	l.items[l.head] = nil
+	l.head++
+	l.bytes -= int64(len(v))
+
+	return v
+}
+
+func (l *deque) popBack() []byte {
+	last := len(l.items) - 1
+	v := l.items[last]
+	l.items[last] = nil
+	l.items = l.items[:last]
+	l.bytes -= int64(len(v))
+
+	return v
+}
+
+// span converts inclusive start and stop indexes, which might be negative, into a half-open range.
+func (l *deque) span(start, stop int) (from, to int) {
+	n := l.len()
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// slice returns elements between start and stop inclusive.
+func (l *deque) slice(start, stop int) [][]byte {
+	from, to := l.span(start, stop)
+	return append([][]byte(nil), l.items[l.head+from:l.head+to]...)
+}
+
+// trim keeps only elements between start and stop inclusive.
+func (l *deque) trim(start, stop int) {
+	l.items, l.head, l.bytes = l.slice(start, stop), 0, 0
+	for _, v := range l.items {
+		l.bytes += int64(len(v))
+	}
+}
+
+func (l *deque) size() int64 {
+	return l.bytes
+}
+
+func (l *deque) encode() []byte {
+	return proto.EncodeRawSlice(l.items[l.head:]...)
+}
+
+// listEntry returns a live entry of a key with its value turned into a list and the list itself,
+// nil if the key is missed. It is called with write lock held.
+func (b *bucket) listEntry(key string) (*entry, *deque, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return nil, nil, nil
+	}
+
+	if e.obj == nil {
+		l, err := newDeque(e.val)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.convert(key, e, l)
+	}
+
+	l, ok := e.obj.(*deque)
+	if !ok {
+		return nil, nil, store.ErrNotList
+	}
+
+	return e, l, nil
+}
+
+// readList returns a list of a live entry of a key, nil if the key is missed.
+// A value which is not a list yet is parsed without being stored, as only read lock is held.
+func (b *bucket) readList(key string) (*deque, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return nil, nil
+	}
+
+	if e.obj == nil {
+		return newDeque(e.val)
+	}
+
+	l, ok := e.obj.(*deque)
+	if !ok {
+		return nil, store.ErrNotList
+	}
+
+	return l, nil
+}
+
+// Push implements store.Lister.
+func (m *mStore) Push(key string, front bool, vals ...[]byte) (int, error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.push(b, key, front, vals...)
+}
+
+// push is Push working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) push(b *bucket, key string, front bool, vals ...[]byte) (int, error) {
+	e, l, err := b.listEntry(key)
+	if err != nil {
+		return 0, err
+	}
+
+	push := func() {
+		if front {
+			l.pushFront(vals...)
+		} else {
+			l.pushBack(vals...)
+		}
+	}
+
+	if e == nil {
+		if len(vals) == 0 {
+			return 0, nil
+		}
+
+		l = &deque{}
+		push()
+		b.put(key, m.newObjectEntry(l))
+
+		return l.len(), nil
+	}
+
+	m.modify(b, key, e, push)
+
+	return l.len(), nil
+}
+
+// Pop implements store.Lister.
+func (m *mStore) Pop(key string, front bool) (val []byte, err error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.pop(b, key, front)
+}
+
+// pop is Pop working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) pop(b *bucket, key string, front bool) (val []byte, err error) {
+	e, l, err := b.listEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e == nil || l.len() == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	m.modify(b, key, e, func() {
+		if front {
+			val = l.popFront()
+		} else {
+			val = l.popBack()
+		}
+	})
+
+	if l.len() == 0 {
+		b.delete(key)
+	}
+
+	return val, nil
+}
+
+// Range implements store.Lister.
+func (m *mStore) Range(key string, start, stop int) ([][]byte, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.lrange(b, key, start, stop)
+}
+
+// lrange is Range working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) lrange(b *bucket, key string, start, stop int) ([][]byte, error) {
+	l, err := b.readList(key)
+	if err != nil || l == nil {
+		return nil, err
+	}
+
+	b.touch(key)
+
+	return l.slice(start, stop), nil
+}
+
+// Trim implements store.Lister.
+func (m *mStore) Trim(key string, start, stop int) error {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.trim(b, key, start, stop)
+}
+
+// trim is Trim working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) trim(b *bucket, key string, start, stop int) error {
+	e, l, err := b.listEntry(key)
+	if err != nil || e == nil {
+		return err
+	}
+
+	m.modify(b, key, e, func() { l.trim(start, stop) })
+
+	if l.len() == 0 {
+		b.delete(key)
+	}
+
+	return nil
+}
+
+// Len implements store.Lister.
+func (m *mStore) Len(key string) (int, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.llen(b, key)
+}
+
+// llen is Len working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) llen(b *bucket, key string) (int, error) {
+	l, err := b.readList(key)
+	if err != nil || l == nil {
+		return 0, err
+	}
+
+	return l.len(), nil
+}
@@ -0,0 +1,109 @@
+package mstore
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+func TestList(t *testing.T) {
+	s, _ := New(3, 1)
+	l := s.(store.Lister)
+
+	s.Set("list", []byte("@2\n&2\n$\"three\""), 0)
+
+	for i, tc := range []struct {
+		desc string
+		run  func() (interface{}, error)
+		want interface{}
+		err  error
+	}{
+		{
+			desc: "push front to a stored slice",
+			run:  func() (interface{}, error) { return l.Push("list", true, []byte("&1"), []byte("&0")) },
+			want: 4,
+		}, {
+			desc: "push back",
+			run:  func() (interface{}, error) { return l.Push("list", false, []byte("@1\n&4")) },
+			want: 5,
+		}, {
+			desc: "range all",
+			run:  func() (interface{}, error) { return l.Range("list", 0, -1) },
+			want: [][]byte{[]byte("&0"), []byte("&1"), []byte("&2"), []byte("$\"three\""), []byte("@1\n&4")},
+		}, {
+			desc: "range out of bounds",
+			run:  func() (interface{}, error) { return l.Range("list", 3, 100) },
+			want: [][]byte{[]byte("$\"three\""), []byte("@1\n&4")},
+		}, {
+			desc: "pop front",
+			run:  func() (interface{}, error) { return l.Pop("list", true) },
+			want: []byte("&0"),
+		}, {
+			desc: "pop back",
+			run:  func() (interface{}, error) { return l.Pop("list", false) },
+			want: []byte("@1\n&4"),
+		}, {
+			desc: "trim",
+			run:  func() (interface{}, error) { return nil, l.Trim("list", -2, -1) },
+		}, {
+			desc: "len",
+			run:  func() (interface{}, error) { return l.Len("list") },
+			want: 2,
+		}, {
+			desc: "len of a missed key",
+			run:  func() (interface{}, error) { return l.Len("missed") },
+			want: 0,
+		}, {
+			desc: "pop a missed key",
+			run:  func() (interface{}, error) { return l.Pop("missed", true) },
+			err:  store.ErrNotFound,
+		}, {
+			desc: "push to a string",
+			run: func() (interface{}, error) {
+				s.Set("string", []byte("$\"value\""), 0)
+				return l.Push("string", true, []byte("&1"))
+			},
+			err: store.ErrNotList,
+		},
+	} {
+		got, err := tc.run()
+		if err != tc.err {
+			t.Errorf("[%d] %s: unexpected error: got %v, want %v", i, tc.desc, err, tc.err)
+			continue
+		}
+
+		if tc.err == nil && tc.want != nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("[%d] %s: got %q, want %q", i, tc.desc, got, tc.want)
+		}
+	}
+
+	if got, _ := s.Get("list"); !bytes.Equal(got, []byte("@2\n&2\n$\"three\"")) {
+		t.Errorf("list should be encoded back, got %q", got)
+	}
+
+	l.Pop("list", true)
+	l.Pop("list", true)
+	if _, err := s.Get("list"); err != store.ErrNotFound {
+		t.Errorf("empty list should be removed, got error: %v", err)
+	}
+}
+
+func TestListSize(t *testing.T) {
+	s, _ := New(1, 1)
+	l := s.(store.Lister)
+	b := s.(*mStore).layout().buckets[0]
+
+	for i := 0; i < 100; i++ {
+		l.Push("list", i%2 == 0, []byte("&123"))
+	}
+	for i := 0; i < 60; i++ {
+		l.Pop("list", i%2 == 0)
+	}
+	l.Trim("list", 10, -10)
+
+	if want := int64(len("list") + 21*len("&123")); b.size != want {
+		t.Errorf("bucket size should follow the list, got %d, want %d", b.size, want)
+	}
+}
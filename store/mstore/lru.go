@@ -0,0 +1,71 @@
+package mstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru keeps keys ordered by their last usage, most recent first.
+type lru struct {
+	ll    *list.List
+	items map[string]*list.Element
+
+	mu sync.Mutex
+}
+
+func newLRU() *lru {
+	return &lru{
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// add implements policy.
+func (l *lru) add(key string, _ *entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.MoveToFront(el)
+		return
+	}
+
+	l.items[key] = l.ll.PushFront(key)
+}
+
+// access implements policy.
+func (l *lru) access(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.MoveToFront(el)
+	}
+}
+
+// retime implements policy, ttl doesn't matter to it.
+func (l *lru) retime(string, *entry) {}
+
+// remove implements policy.
+func (l *lru) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.Remove(el)
+		delete(l.items, key)
+	}
+}
+
+// victim implements policy.
+func (l *lru) victim() (key string, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el := l.ll.Back()
+	if el == nil {
+		return "", false
+	}
+
+	return el.Value.(string), true
+}
@@ -1,21 +1,61 @@
 package mstore
 
 import (
+	"container/heap"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aliaksandrb/cachy/glob"
 	"github.com/aliaksandrb/cachy/store"
-	"github.com/spaolacci/murmur3"
 )
 
 const defaultBucketsNum int = 3
 const defaultPurgeInterval int = 10
 
+// purgeBatch limits how many expired keys are purged from a bucket under a single lock.
+const purgeBatch = 1000
+
 var zeroTime = time.Time{}
 
+// Option configures optional store settings.
+type Option func(*mStore)
+
+// WithMaxBytes limits the total size of keys and values kept in the store.
+// Once a bucket exceeds its share of the limit entries are evicted according to eviction policy.
+func WithMaxBytes(n int64) Option {
+	return func(m *mStore) {
+		m.maxBytes = n
+	}
+}
+
+// WithMaxEntries limits the total number of entries kept in the store.
+// Once a bucket exceeds its share of the limit entries are evicted according to eviction policy.
+func WithMaxEntries(n int) Option {
+	return func(m *mStore) {
+		m.maxEntries = n
+	}
+}
+
+// WithMaxLoadFactor makes the store double the number of its buckets once the average number
+// of entries per bucket exceeds f, it is checked along with purging expired keys. Default: 0, never.
+func WithMaxLoadFactor(f float64) Option {
+	return func(m *mStore) {
+		m.maxLoadFactor = f
+	}
+}
+
+// WithEvictionPolicy sets a policy used to evict entries from a bounded store, default: LRU.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(m *mStore) {
+		m.policy = p
+	}
+}
+
 // New returns in-memory store implementation of store.Store.
-func New(bucketsNum int, purgeInterval int) (store.Store, error) {
+func New(bucketsNum int, purgeInterval int, opts ...Option) (store.Store, error) {
 	if bucketsNum < 0 || purgeInterval < 0 {
 		return nil, fmt.Errorf("should be positive: bucketsNum: %v, purgeInterval: %v", bucketsNum, purgeInterval)
 	}
@@ -27,50 +67,318 @@ func New(bucketsNum int, purgeInterval int) (store.Store, error) {
 		purgeInterval = defaultPurgeInterval
 	}
 
-	buckets := make([]*bucket, bucketsNum)
-	for i := range buckets {
-		buckets[i] = newBucket()
-	}
-
 	m := &mStore{
+		// Versions start from the current time, so ones handed out before a restart never match again.
+		version:       uint64(time.Now().UnixNano()),
 		purgeInterval: purgeInterval,
-		buckets:       buckets,
 		purger:        newPurger(),
+		policy:        LRU,
+		events:        newNotifier(),
+	}
+
+	for _, opt := range opts {
+		opt(m)
 	}
 
+	if m.maxBytes < 0 || m.maxEntries < 0 {
+		return nil, fmt.Errorf("should be positive: maxBytes: %v, maxEntries: %v", m.maxBytes, m.maxEntries)
+	}
+
+	buckets, err := m.newBuckets(bucketsNum)
+	if err != nil {
+		return nil, err
+	}
+	m.l.Store(&layout{buckets: buckets})
+
 	go m.startPurger()
 
 	return m, nil
 }
 
+// newBuckets returns n buckets sharing limits of the store.
+func (m *mStore) newBuckets(n int) ([]*bucket, error) {
+	buckets := make([]*bucket, n)
+	for i := range buckets {
+		b, err := newBucket(share(m.maxBytes, n), int(share(int64(m.maxEntries), n)), m.policy)
+		if err != nil {
+			return nil, err
+		}
+		b.events = m.events
+		b.versions = &m.version
+		b.removed = atomic.LoadUint64(&m.version)
+		buckets[i] = b
+	}
+
+	return buckets, nil
+}
+
+// share returns a per-bucket part of a limit, rounded up.
+func share(limit int64, bucketsNum int) int64 {
+	n := int64(bucketsNum)
+	return (limit + n - 1) / n
+}
+
 // mStore implements store.Store.
 type mStore struct {
+	// version is the last version given to an entry, it goes first to be 64-bit aligned for atomic access.
+	version uint64
+	// hits, misses and lazyExpired count reads, they follow version to be 64-bit aligned as well.
+	hits          uint64
+	misses        uint64
+	lazyExpired   uint64
 	purgeInterval int
-	buckets       []*bucket
+	maxBytes      int64
+	maxEntries    int
+	maxLoadFactor float64
+	policy        EvictionPolicy
 	purger        *purger
+	events        *notifier
+	// l is the current layout of buckets, see layout.
+	l atomic.Value
+	// resizeMu is held by migrations of buckets, and for reading by operations visiting
+	// all the buckets, so entries never move under them.
+	resizeMu sync.RWMutex
 }
 
-func (m *mStore) bucketsNum() int {
-	return len(m.buckets)
+// newEntry returns an entry with the next version.
+func (m *mStore) newEntry(val []byte, ttl time.Time) *entry {
+	return &entry{val: val, ttl: ttl, version: atomic.AddUint64(&m.version, 1)}
 }
 
-func (m *mStore) getBucket(key string) *bucket {
-	return m.buckets[m.hash([]byte(key))]
-}
+// newObjectEntry returns an entry without ttl holding a structured value obj.
+func (m *mStore) newObjectEntry(obj object) *entry {
+	e := m.newEntry(nil, zeroTime)
+	e.obj = obj
 
-func (m *mStore) hash(k []byte) uint64 {
-	return murmur3.Sum64(k) % uint64(m.bucketsNum())
+	return e
 }
 
 type bucket struct {
 	s map[string]*entry
+	// keys are the keys of s placed for scans.
+	keys scanTable
+
+	// size is a total size of keys and values in a bucket.
+	size       int64
+	maxBytes   int64
+	maxEntries int
+	// policy picks entries to evict, it is nil for unbounded buckets.
+	policy policy
+	// expiry keeps entries with ttl ordered by expiration time.
+	expiry ttlHeap
+	// events reports changes of keys, it is shared by all buckets of a store.
+	events *notifier
+	// held is a transaction holding the bucket, it keeps the changes until it commits, see notify.
+	held *tx
+	// versions is the version counter of the store, removed is the version taken from it by the last
+	// removal of a key, it stands for versions of missed keys, see keyVersion.
+	versions *uint64
+	removed  uint64
+	// sets, evictions and expired are counters of the bucket, see Stats.
+	sets      uint64
+	evictions uint64
+	expired   uint64
+	// moved is set once entries are migrated to buckets of a resized store, the bucket is not used anymore.
+	moved bool
 
 	mu sync.RWMutex
 }
 
-func newBucket() *bucket {
-	return &bucket{
-		s: make(map[string]*entry),
+func newBucket(maxBytes int64, maxEntries int, p EvictionPolicy) (*bucket, error) {
+	b := &bucket{
+		s:          make(map[string]*entry),
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		versions:   new(uint64),
+	}
+
+	if maxBytes == 0 && maxEntries == 0 {
+		return b, nil
+	}
+
+	var err error
+	if b.policy, err = newPolicy(p, maxEntries); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// put stores entry e under a key and evicts other entries if the bucket is over its limits.
+// It is called with write lock held.
+func (b *bucket) put(key string, e *entry) {
+	op := store.EventSet
+	if old, ok := b.s[key]; ok {
+		b.size -= old.size(key)
+		b.unschedule(old)
+
+		if !old.expired() {
+			op = store.EventUpdate
+		}
+	} else {
+		b.keys.add(key)
+	}
+
+	b.s[key] = e
+	b.size += e.size(key)
+	b.sets++
+	b.schedule(key, e)
+	b.notify(key, op)
+
+	if b.policy == nil {
+		return
+	}
+
+	b.policy.add(key, e)
+	b.evict()
+}
+
+// delete removes a key from a bucket, reporting it as expired if its ttl has passed.
+// It is called with write lock held.
+func (b *bucket) delete(key string) {
+	e, ok := b.s[key]
+	if !ok {
+		return
+	}
+
+	op := store.EventRemove
+	if e.expired() {
+		op = store.EventExpire
+	}
+
+	b.unlink(key, e)
+	b.removed = atomic.AddUint64(b.versions, 1)
+	b.notify(key, op)
+}
+
+// notify reports a change of a key, a transaction holding the bucket keeps it until it commits,
+// so changes rolled back are never reported. It is called with write lock held.
+func (b *bucket) notify(key string, op store.EventOp) {
+	if b.held != nil {
+		b.held.events = append(b.held.events, heldEvent{key: key, op: op})
+		return
+	}
+
+	b.events.notify(key, op)
+}
+
+// link adds a key with its entry e moved from another bucket as it is.
+// It is called with write lock held.
+func (b *bucket) link(key string, e *entry) {
+	b.keys.add(key)
+	b.s[key] = e
+	b.size += e.size(key)
+	b.schedule(key, e)
+
+	if b.policy != nil {
+		b.policy.add(key, e)
+	}
+}
+
+// unlink removes a key with its entry e from a bucket and from the expiry heap and the policy.
+// It is called with write lock held.
+func (b *bucket) unlink(key string, e *entry) {
+	delete(b.s, key)
+	b.keys.remove(key)
+	b.size -= e.size(key)
+	b.unschedule(e)
+
+	if b.policy != nil {
+		b.policy.remove(key)
+	}
+}
+
+// schedule adds an entry e with ttl to the expiry heap.
+func (b *bucket) schedule(key string, e *entry) {
+	if e.ttl == zeroTime {
+		return
+	}
+
+	e.expiry = &ttlItem{key: key, ttl: e.ttl}
+	heap.Push(&b.expiry, e.expiry)
+}
+
+// unschedule removes an entry e from the expiry heap.
+func (b *bucket) unschedule(e *entry) {
+	if e.expiry == nil {
+		return
+	}
+
+	heap.Remove(&b.expiry, e.expiry.index)
+	e.expiry = nil
+}
+
+// modify changes an entry e of a key in place with fn, giving it the next version.
+// It is called with write lock held.
+func (m *mStore) modify(b *bucket, key string, e *entry, fn func()) {
+	before := e.size(key)
+	fn()
+	e.version = atomic.AddUint64(&m.version, 1)
+	b.size += e.size(key) - before
+	b.notify(key, store.EventUpdate)
+
+	if b.policy == nil {
+		return
+	}
+
+	b.policy.access(key)
+	b.evict()
+}
+
+// expire sets a new ttl of an entry e of a key, zero time means it never expires.
+// It is not a use of the key, so callers record one themselves if needed.
+// It is called with write lock held.
+func (b *bucket) expire(key string, e *entry, ttl time.Time) {
+	b.unschedule(e)
+	e.ttl = ttl
+	b.schedule(key, e)
+
+	if b.policy != nil {
+		b.policy.retime(key, e)
+	}
+}
+
+// convert replaces a raw value of an entry e with the same value structured as obj.
+// It is called with write lock held.
+func (b *bucket) convert(key string, e *entry, obj object) {
+	before := e.size(key)
+	e.obj, e.val = obj, nil
+	b.size += e.size(key) - before
+}
+
+// touch records a key usage.
+// It is safe to call it with read lock held.
+func (b *bucket) touch(key string) {
+	if b.policy != nil {
+		b.policy.access(key)
+	}
+}
+
+func (b *bucket) overflowed() bool {
+	return (b.maxBytes > 0 && b.size > b.maxBytes) ||
+		(b.maxEntries > 0 && len(b.s) > b.maxEntries)
+}
+
+// evict removes entries picked by the policy until the bucket fits its limits.
+// A value larger than the whole bucket limit gets evicted as well.
+func (b *bucket) evict() {
+	// A transaction might be rolled back, so it evicts from its buckets once it commits.
+	if b.held != nil {
+		return
+	}
+
+	for b.overflowed() {
+		key, ok := b.policy.victim()
+		if !ok {
+			return
+		}
+
+		if e, ok := b.s[key]; ok {
+			b.unlink(key, e)
+			b.removed = atomic.AddUint64(b.versions, 1)
+			b.evictions++
+			b.notify(key, store.EventEvict)
+		}
 	}
 }
 
@@ -83,30 +391,140 @@ func (m *mStore) startPurger() {
 		case <-m.purger.quit:
 			return
 		case <-ticker.C:
-			for _, b := range m.buckets {
+			for _, b := range m.layout().all() {
 				m.purger.purgeStaleKeys(b)
 			}
+			m.checkLoad()
 		}
 	}
 }
 
 // Get implements store.Store.
+// Keys with sliding ttl are renewed by it, so the write lock is taken for them.
 func (m *mStore) Get(key string) (val []byte, err error) {
-	b := m.getBucket(key)
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	b := m.rlockBucket(key)
+	if e, ok := b.s[key]; !ok || e.sliding == 0 {
+		defer b.mu.RUnlock()
+		return m.get(b, key)
+	}
+	b.mu.RUnlock()
+
+	b = m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.getSliding(b, key)
+}
+
+// getSliding is get renewing ttl of a key with sliding ttl, it is called with write lock held.
+func (m *mStore) getSliding(b *bucket, key string) ([]byte, error) {
+	val, err := m.get(b, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e := b.s[key]; e.sliding != 0 {
+		// The value is not changed, so the version is kept. The read is already recorded by get.
+		b.expire(key, e, time.Now().Add(e.sliding))
+	}
+
+	return val, nil
+}
+
+// get is Get working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) get(b *bucket, key string) (val []byte, err error) {
 	e, ok := b.s[key]
 
 	if !ok {
+		atomic.AddUint64(&m.misses, 1)
 		return nil, store.ErrNotFound
 	}
 
 	if e == nil || e.expired() {
-		go m.Remove(key)
+		atomic.AddUint64(&m.misses, 1)
+		atomic.AddUint64(&m.lazyExpired, 1)
+		go m.removeExpired(key, e)
 		return nil, store.ErrNotFound
 	}
 
-	return append([]byte(nil), e.val...), nil
+	atomic.AddUint64(&m.hits, 1)
+	b.touch(key)
+
+	return append([]byte(nil), e.encoded()...), nil
+}
+
+// removeExpired removes an expired entry e of a key found by a read, which could not do it
+// with read lock held. The key might be set again by then, so it is removed only if it still has e.
+func (m *mStore) removeExpired(key string, e *entry) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	if cur, ok := b.s[key]; ok && cur == e && (e == nil || e.expired()) {
+		b.delete(key)
+	}
+}
+
+// GetWithVersion implements store.Store.
+func (m *mStore) GetWithVersion(key string) (val []byte, version uint64, err error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.getWithVersion(b, key)
+}
+
+// getWithVersion is GetWithVersion working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) getWithVersion(b *bucket, key string) (val []byte, version uint64, err error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		atomic.AddUint64(&m.misses, 1)
+		return nil, 0, store.ErrNotFound
+	}
+
+	atomic.AddUint64(&m.hits, 1)
+	b.touch(key)
+
+	return append([]byte(nil), e.encoded()...), e.version, nil
+}
+
+// Version implements store.Versioner.
+func (m *mStore) Version(key string) uint64 {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.keyVersion(b, key)
+}
+
+// keyVersion is Version working on a bucket b of the key, it is called with read lock held.
+// A missed key has the version of the last removal from its bucket, so a key set and removed again
+// never gets the version it had before. An expired entry is not removed yet, so it gets the complement
+// of its version, which differs from versions of live entries and removals.
+func (m *mStore) keyVersion(b *bucket, key string) uint64 {
+	e, ok := b.s[key]
+	if !ok {
+		return b.removed
+	}
+	if e.expired() {
+		return ^e.version
+	}
+
+	return e.version
+}
+
+// Peek implements store.Peeker.
+func (m *mStore) Peek(key string) (val []byte, ttl, sliding time.Duration, err error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.peek(b, key)
+}
+
+// peek is Peek working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) peek(b *bucket, key string) (val []byte, ttl, sliding time.Duration, err error) {
+	e, ok := b.s[key]
+	if !ok || e == nil || e.expired() {
+		return nil, 0, 0, store.ErrNotFound
+	}
+
+	return append([]byte(nil), e.encoded()...), e.remaining(), e.sliding, nil
 }
 
 func getTTL(t time.Duration) time.Time {
@@ -119,53 +537,295 @@ func getTTL(t time.Duration) time.Time {
 
 // Set implements store.Store.
 func (m *mStore) Set(key string, val []byte, t time.Duration) error {
-	b := m.getBucket(key)
-	b.mu.Lock()
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.set(b, key, val, t)
+}
+
+// set is Set working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) set(b *bucket, key string, val []byte, t time.Duration) error {
+	b.put(key, m.newEntry(val, getTTL(t)))
+
+	return nil
+}
+
+// SetSliding implements store.Slider.
+func (m *mStore) SetSliding(key string, val []byte, t time.Duration) error {
+	b := m.lockBucket(key)
 	defer b.mu.Unlock()
-	b.s[key] = &entry{val: val, ttl: getTTL(t)}
+
+	return m.setSliding(b, key, val, t)
+}
+
+// setSliding is SetSliding working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) setSliding(b *bucket, key string, val []byte, t time.Duration) error {
+	e := m.newEntry(val, getTTL(t))
+	e.sliding = t
+	b.put(key, e)
 
 	return nil
 }
 
+// Sliding implements store.Slider.
+func (m *mStore) Sliding(key string) (time.Duration, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.sliding(b, key)
+}
+
+// sliding is Sliding working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) sliding(b *bucket, key string) (time.Duration, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return 0, store.ErrNotFound
+	}
+
+	return e.sliding, nil
+}
+
+// SetNX implements store.Store.
+func (m *mStore) SetNX(key string, val []byte, t time.Duration) (bool, error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.setNX(b, key, val, t)
+}
+
+// setNX is SetNX working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) setNX(b *bucket, key string, val []byte, t time.Duration) (bool, error) {
+	if e, ok := b.s[key]; ok && !e.expired() {
+		return false, nil
+	}
+
+	b.put(key, m.newEntry(val, getTTL(t)))
+
+	return true, nil
+}
+
+// GetSet implements store.Store.
+func (m *mStore) GetSet(key string, val []byte, t time.Duration) (old []byte, err error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.getSet(b, key, val, t)
+}
+
+// getSet is GetSet working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) getSet(b *bucket, key string, val []byte, t time.Duration) (old []byte, err error) {
+	if e, ok := b.s[key]; ok && !e.expired() {
+		old = append([]byte(nil), e.encoded()...)
+	}
+
+	b.put(key, m.newEntry(val, getTTL(t)))
+
+	return old, nil
+}
+
 // Update implements store.Store.
 func (m *mStore) Update(key string, val []byte, t time.Duration) error {
-	b := m.getBucket(key)
-	b.mu.Lock()
+	b := m.lockBucket(key)
 	defer b.mu.Unlock()
 
-	e, ok := b.s[key]
+	return m.update(b, key, val, t)
+}
+
+// update is Update working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) update(b *bucket, key string, val []byte, t time.Duration) error {
+	old, ok := b.s[key]
 	if !ok {
 		return store.ErrNotFound
 	}
 
-	e.val = val
-	e.ttl = getTTL(t)
-	b.s[key] = e
+	if old.sliding == 0 {
+		b.put(key, m.newEntry(val, getTTL(t)))
+		return nil
+	}
+
+	// A key with sliding ttl stays one, its deadline is renewed unless another ttl is given.
+	if t == 0 {
+		t = old.sliding
+	}
+	e := m.newEntry(val, getTTL(t))
+	e.sliding = old.sliding
+	b.put(key, e)
 
 	return nil
 }
 
 // Remove implements store.Store.
 func (m *mStore) Remove(key string) error {
-	b := m.getBucket(key)
-	b.mu.Lock()
+	b := m.lockBucket(key)
 	defer b.mu.Unlock()
 
+	return m.remove(b, key)
+}
+
+// remove is Remove working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) remove(b *bucket, key string) error {
 	_, ok := b.s[key]
 	if !ok {
 		return store.ErrNotFound
 	}
-	delete(b.s, key)
+	b.delete(key)
+
+	return nil
+}
+
+// CompareAndSwap implements store.Store.
+func (m *mStore) CompareAndSwap(key string, val []byte, version uint64, t time.Duration) error {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.compareAndSwap(b, key, val, version, t)
+}
+
+// compareAndSwap is CompareAndSwap working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) compareAndSwap(b *bucket, key string, val []byte, version uint64, t time.Duration) error {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return store.ErrNotFound
+	}
+
+	if e.version != version {
+		return store.ErrVersionMismatch
+	}
+
+	b.put(key, m.newEntry(val, getTTL(t)))
+
+	return nil
+}
+
+// IncrBy implements store.Counter.
+func (m *mStore) IncrBy(key string, delta int64) (int64, error) {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.incrBy(b, key, delta)
+}
+
+// incrBy is IncrBy working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) incrBy(b *bucket, key string, delta int64) (int64, error) {
+	var val []byte
+	var sliding time.Duration
+	ttl := zeroTime
+
+	if e, ok := b.s[key]; ok && !e.expired() {
+		val, ttl, sliding = e.encoded(), e.ttl, e.sliding
+	}
+
+	n, val, err := store.Incr(val, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	e := m.newEntry(val, ttl)
+	e.sliding = sliding
+	b.put(key, e)
+
+	return n, nil
+}
+
+// TTL implements store.Expirer.
+func (m *mStore) TTL(key string) (time.Duration, error) {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.ttl(b, key)
+}
+
+// ttl is TTL working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) ttl(b *bucket, key string) (time.Duration, error) {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return 0, store.ErrNotFound
+	}
+
+	return e.remaining(), nil
+}
+
+// Expire implements store.Expirer.
+func (m *mStore) Expire(key string, at time.Time) error {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.expire(b, key, at)
+}
+
+// expire is Expire working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) expire(b *bucket, key string, at time.Time) error {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return store.ErrNotFound
+	}
+
+	if !at.After(time.Now()) {
+		b.delete(key)
+		return nil
+	}
+
+	m.modify(b, key, e, func() { b.expire(key, e, at) })
+
+	return nil
+}
+
+// Persist implements store.Expirer.
+func (m *mStore) Persist(key string) error {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	return m.persist(b, key)
+}
+
+// persist is Persist working on a bucket b of the key, it is called with write lock held.
+func (m *mStore) persist(b *bucket, key string) error {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return store.ErrNotFound
+	}
+
+	if e.ttl != zeroTime {
+		m.modify(b, key, e, func() {
+			b.expire(key, e, zeroTime)
+			e.sliding = 0
+		})
+	}
+
+	return nil
+}
+
+// Touch implements store.Expirer.
+func (m *mStore) Touch(key string) error {
+	b := m.rlockBucket(key)
+	defer b.mu.RUnlock()
+
+	return m.touch(b, key)
+}
+
+// touch is Touch working on a bucket b of the key, it is called with read lock held.
+func (m *mStore) touch(b *bucket, key string) error {
+	e, ok := b.s[key]
+	if !ok || e.expired() {
+		return store.ErrNotFound
+	}
+
+	b.touch(key)
 
 	return nil
 }
 
 // Keys implements store.Store.
 func (m *mStore) Keys() (keys []string) {
-	for _, b := range m.buckets {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+
+	for _, b := range m.layout().all() {
 		b.mu.RLock()
-		for k := range b.s {
-			// TODO stale keys
+		for k, e := range b.s {
+			if e == nil || e.expired() {
+				continue
+			}
 			keys = append(keys, k)
 		}
 		b.mu.RUnlock()
@@ -174,28 +834,281 @@ func (m *mStore) Keys() (keys []string) {
 	return
 }
 
+// Flush implements store.Flusher.
+func (m *mStore) Flush() error {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+
+	for _, b := range m.layout().all() {
+		b.mu.Lock()
+		for k := range b.s {
+			b.delete(k)
+		}
+		b.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Stats implements store.Statser.
+// Buckets of the old table go first while the store is resized.
+func (m *mStore) Stats() store.Stats {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+
+	buckets := m.layout().all()
+	s := store.Stats{
+		Hits:        atomic.LoadUint64(&m.hits),
+		Misses:      atomic.LoadUint64(&m.misses),
+		LazyExpired: atomic.LoadUint64(&m.lazyExpired),
+		Buckets:     make([]store.BucketStats, len(buckets)),
+	}
+
+	for i, b := range buckets {
+		b.mu.RLock()
+		s.Sets += b.sets
+		s.Evictions += b.evictions
+		s.Expired += b.expired
+		s.Buckets[i] = store.BucketStats{Entries: len(b.s), Bytes: b.size}
+		b.mu.RUnlock()
+	}
+
+	return s
+}
+
+// Size implements store.Sizer, expired keys are never counted even if they are not purged yet.
+func (m *mStore) Size() (n int) {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+
+	for _, b := range m.layout().all() {
+		b.mu.RLock()
+		for _, e := range b.s {
+			if !e.expired() {
+				n++
+			}
+		}
+		b.mu.RUnlock()
+	}
+
+	return n
+}
+
+const defaultScanCount = 10
+
+// Scan implements store.Scanner.
+// The cursor holds a bucket index in its high 32 bits and a position inside the bucket in low ones.
+// The position is the one of the scan table of the bucket, which stays valid no matter what happens
+// to the bucket between calls, see scanTable.
+// Indexes are the ones of the new table while the store is resized. A store only grows by a multiple
+// of its buckets number, so keys of a bucket go to ones with the same or greater indexes and
+// a cursor taken before a resize never misses them, though some keys might be returned twice.
+func (m *mStore) Scan(cursor uint64, pattern string, count int) (keys []string, next uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+
+	l := m.layout()
+	i, pos := int(cursor>>32), uint32(cursor)
+
+	visited := 0
+	for ; i < len(l.buckets); i, pos = i+1, 0 {
+		b, filter := l.scanBucket(i)
+		found, n, nextPos := b.scan(pos, count-visited, filter)
+		visited += n
+
+		for _, k := range found {
+			if pattern == "" || glob.Match(pattern, k) {
+				keys = append(keys, k)
+			}
+		}
+
+		switch {
+		case nextPos != 0:
+			return keys, uint64(i)<<32 | uint64(nextPos)
+		case visited >= count && i+1 < len(l.buckets):
+			return keys, uint64(i+1) << 32
+		}
+	}
+
+	return keys, 0
+}
+
+// scan returns live keys of a bucket visiting about count of them from the position pos
+// of its scan table, along with the number of keys visited and the position to continue from,
+// 0 once all of them are visited. Only keys passing filter are returned if it is given.
+func (b *bucket) scan(pos uint32, count int, filter func(key string) bool) (keys []string, visited int, next uint32) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	next = b.keys.scan(pos, count, func(k string) {
+		visited++
+		if e := b.s[k]; e.expired() || (filter != nil && !filter(k)) {
+			return
+		}
+		keys = append(keys, k)
+	})
+
+	return keys, visited, next
+}
+
+// Snapshot implements store.Snapshotter.
+// All the buckets are locked while entries are collected, so the snapshot is consistent,
+// the writing itself happens without any locks held.
+func (m *mStore) Snapshot(w io.Writer) error {
+	type item struct {
+		key     string
+		val     []byte
+		ttl     time.Duration
+		sliding time.Duration
+	}
+
+	var items []item
+
+	m.resizeMu.RLock()
+	buckets := m.layout().all()
+	for _, b := range buckets {
+		b.mu.RLock()
+	}
+	for _, b := range buckets {
+		for k, e := range b.s {
+			if e == nil || e.expired() {
+				continue
+			}
+			items = append(items, item{key: k, val: e.encoded(), ttl: e.remaining(), sliding: e.sliding})
+		}
+	}
+	for _, b := range buckets {
+		b.mu.RUnlock()
+	}
+	m.resizeMu.RUnlock()
+
+	sw, err := store.NewSnapshotWriter(w)
+	if err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		if err = sw.Write(it.key, it.val, it.ttl, it.sliding); err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// Restore implements store.Snapshotter.
+func (m *mStore) Restore(r io.Reader) error {
+	return store.ReadSnapshot(r, m.restore)
+}
+
+// restore sets a value of a key read from a snapshot with its remaining and sliding ttl.
+func (m *mStore) restore(key string, val []byte, ttl, sliding time.Duration) error {
+	b := m.lockBucket(key)
+	defer b.mu.Unlock()
+
+	e := m.newEntry(val, getTTL(ttl))
+	e.sliding = sliding
+	b.put(key, e)
+
+	return nil
+}
+
+// Close stops the purger.
+func (m *mStore) Close() error {
+	m.purger.stop()
+	return nil
+}
+
 type purger struct {
 	quit chan struct{}
+	once sync.Once
+}
+
+// stop stops purging, it is safe to call it more than once.
+func (p *purger) stop() {
+	p.once.Do(func() { close(p.quit) })
 }
 
 func newPurger() *purger {
 	return &purger{quit: make(chan struct{})}
 }
 
+// purgeStaleKeys removes expired keys of a bucket taking them from the top of its expiry heap,
+// so only expired keys are visited. The lock is released every purgeBatch keys to let writers in.
 func (p *purger) purgeStaleKeys(b *bucket) {
+	for {
+		if n := p.purgeBatch(b); n < purgeBatch {
+			return
+		}
+	}
+}
+
+func (p *purger) purgeBatch(b *bucket) (n int) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	for k, e := range b.s {
-		if e == nil || e.expired() {
-			delete(b.s, k)
-		}
+	now := time.Now()
+	for ; n < purgeBatch && len(b.expiry) > 0 && now.After(b.expiry[0].ttl); n++ {
+		b.delete(b.expiry[0].key)
+		b.expired++
 	}
+
+	return n
+}
+
+// object is a structured value, like a list or a map, which is changed in place.
+type object interface {
+	// size returns a total size of raw elements.
+	size() int64
+	encode() []byte
 }
 
 type entry struct {
 	val []byte
 	ttl time.Time
+	// sliding is a ttl the entry is renewed with by every Get, 0 if it is not renewed.
+	sliding time.Duration
+	// version changes with every write of a key.
+	version uint64
+	// obj replaces val once the value is changed by a command working with its structure.
+	obj object
+	// expiry is a position of entry in the bucket expiry heap, nil if there is no ttl.
+	expiry *ttlItem
+}
+
+func (e *entry) size(key string) int64 {
+	if e.obj != nil {
+		return int64(len(key)) + e.obj.size()
+	}
+
+	return int64(len(key) + len(e.val))
+}
+
+// encoded returns the raw encoded value, it must not be modified.
+func (e *entry) encoded() []byte {
+	if e.obj != nil {
+		return e.obj.encode()
+	}
+
+	return e.val
+}
+
+// remaining returns the time left before expiration, 0 if there is no ttl.
+func (e *entry) remaining() time.Duration {
+	if e.ttl == zeroTime {
+		return 0
+	}
+
+	if d := time.Until(e.ttl); d > 0 {
+		return d
+	}
+
+	// Expires right now, but 0 would mean no expiration at all.
+	return 1
 }
 
 func (e *entry) expired() bool {
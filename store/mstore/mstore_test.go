@@ -1,9 +1,16 @@
 package mstore
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"reflect"
+	"sort"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
 )
 
 /*
@@ -131,3 +138,665 @@ func BenchmarkConcurrentRemoves(b *testing.B) {
 		}
 	})
 }
+
+func TestEvictionByEntries(t *testing.T) {
+	db, err := New(1, 1, WithMaxEntries(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err = db.Set(k, testVal, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = db.Get("a"); err != nil {
+		t.Fatalf("unable to get a value: %v", err)
+	}
+
+	if err = db.Set("d", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Get("b"); err != store.ErrNotFound {
+		t.Errorf("least recently used key should be evicted, got error: %v", err)
+	}
+
+	keys := db.Keys()
+	sort.Strings(keys)
+	if want := []string{"a", "c", "d"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+}
+
+func TestEvictionByBytes(t *testing.T) {
+	db, err := New(1, 1, WithMaxBytes(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		key  string
+		val  []byte
+		keys []string
+	}{
+		{key: "a", val: []byte("1234"), keys: []string{"a"}},
+		{key: "b", val: []byte("1234"), keys: []string{"a", "b"}},
+		{key: "c", val: []byte("12"), keys: []string{"b", "c"}},
+		{key: "b", val: []byte("12345678"), keys: []string{"b"}},
+		{key: "d", val: []byte("1234567890"), keys: nil},
+	} {
+		if err = db.Set(tc.key, tc.val, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		keys := db.Keys()
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("[%d] unexpected keys: got %q, want %q", i, keys, tc.keys)
+		}
+	}
+
+	if size := db.(*mStore).layout().buckets[0].size; size != 0 {
+		t.Errorf("bucket size should be tracked, got %d, want 0", size)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	src, _ := New(3, 1)
+	for i, tc := range []struct {
+		key string
+		val []byte
+		ttl time.Duration
+	}{
+		{key: "string", val: []byte("$\"value\"")},
+		{key: "slice", val: []byte("@2\n&1\n$\"two\"")},
+		{key: "ttl", val: []byte("&1"), ttl: time.Hour},
+		{key: "expired", val: []byte("&2"), ttl: time.Nanosecond},
+	} {
+		if err := src.Set(tc.key, tc.val, tc.ttl); err != nil {
+			t.Fatalf("[%d] unable to set: %v", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.(store.Snapshotter).Snapshot(&buf); err != nil {
+		t.Fatalf("unable to take a snapshot: %v", err)
+	}
+
+	dst, _ := New(5, 1)
+	if err := dst.(store.Snapshotter).Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unable to restore a snapshot: %v", err)
+	}
+
+	keys := dst.Keys()
+	sort.Strings(keys)
+	if want := []string{"slice", "string", "ttl"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+
+	got, err := dst.Get("slice")
+	if err != nil || !bytes.Equal(got, []byte("@2\n&1\n$\"two\"")) {
+		t.Errorf("raw value should be restored, got %q, error: %v", got, err)
+	}
+
+	e := dst.(*mStore).getBucket("ttl").s["ttl"]
+	if e.ttl == zeroTime || time.Until(e.ttl) > time.Hour {
+		t.Errorf("remaining ttl should be restored, got %v", e.ttl)
+	}
+
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	if err = dst.(store.Snapshotter).Restore(bytes.NewReader(corrupted)); err != store.ErrBadSnapshot {
+		t.Errorf("corrupted snapshot should be rejected, got error: %v", err)
+	}
+
+	for i, length := range [][]byte{
+		{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40},
+		{0xff, 0xff, 0xff, 0x0f},
+	} {
+		truncated := append([]byte("CACHY\x02\x01"), length...)
+		if err = dst.(store.Snapshotter).Restore(bytes.NewReader(truncated)); err != store.ErrBadSnapshot {
+			t.Errorf("[%d] snapshot with a key length past its end should be rejected, got error: %v", i, err)
+		}
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	s, _ := New(3, 1)
+	c := s.(store.Counter)
+
+	for i, tc := range []struct {
+		key   string
+		delta int64
+		want  int64
+		err   error
+	}{
+		{key: "missed", delta: 1, want: 1},
+		{key: "missed", delta: -3, want: -2},
+		{key: "int", delta: 10, want: 15},
+		{key: "string", delta: 1, err: store.ErrNotInteger},
+		{key: "max", delta: 1, err: store.ErrOverflow},
+		{key: "expired", delta: 1, want: 1},
+	} {
+		switch tc.key {
+		case "int":
+			s.Set(tc.key, []byte("&5"), time.Hour)
+		case "string":
+			s.Set(tc.key, []byte("$\"5\""), 0)
+		case "max":
+			s.Set(tc.key, []byte(fmt.Sprintf("&%d", math.MaxInt64)), 0)
+		case "expired":
+			s.Set(tc.key, []byte("&5"), time.Nanosecond)
+			time.Sleep(time.Millisecond)
+		}
+
+		got, err := c.IncrBy(tc.key, tc.delta)
+		if err != tc.err {
+			t.Errorf("[%d] unexpected error: got %v, want %v", i, err, tc.err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("[%d] unexpected result: got %d, want %d", i, got, tc.want)
+		}
+	}
+
+	if ttl, _ := s.(store.Expirer).TTL("int"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl should be kept, got %v", ttl)
+	}
+
+	if got, _ := s.Get("int"); !bytes.Equal(got, []byte("&15")) {
+		t.Errorf("value should be stored encoded, got %q", got)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	s, _ := New(3, 1)
+
+	if err := s.CompareAndSwap("missed", []byte("&1"), 1, 0); err != store.ErrNotFound {
+		t.Errorf("missed key should not be swapped, got error: %v", err)
+	}
+
+	s.Set("key", []byte("&1"), 0)
+	_, v1, err := s.GetWithVersion("key")
+	if err != nil {
+		t.Fatalf("unable to get version: %v", err)
+	}
+
+	if err = s.CompareAndSwap("key", []byte("&2"), v1, 0); err != nil {
+		t.Errorf("matching version should be swapped, got error: %v", err)
+	}
+
+	if err = s.CompareAndSwap("key", []byte("&3"), v1, 0); err != store.ErrVersionMismatch {
+		t.Errorf("stale version should not be swapped, got error: %v", err)
+	}
+
+	val, v2, _ := s.GetWithVersion("key")
+	if v2 <= v1 || !bytes.Equal(val, []byte("&2")) {
+		t.Errorf("version should grow with writes, got %d after %d, value %q", v2, v1, val)
+	}
+
+	// A removed and set again key must not get its old version back.
+	s.Remove("key")
+	s.Set("key", []byte("&2"), 0)
+	_, v3, _ := s.GetWithVersion("key")
+	if v3 <= v2 {
+		t.Errorf("version should grow after removal, got %d after %d", v3, v2)
+	}
+
+	vs := s.(store.Versioner)
+	if v := vs.Version("key"); v != v3 {
+		t.Errorf("version should match, got %d, want %d", v, v3)
+	}
+
+	// A missed key changes its version once it is set and removed again.
+	missed := vs.Version("missed")
+	s.Set("missed", []byte("&1"), 0)
+	s.Remove("missed")
+	if v := vs.Version("missed"); v == missed {
+		t.Errorf("version of a key set and removed should change, got %d", v)
+	}
+
+	s.Set("expired", []byte("&1"), time.Nanosecond)
+	_, v4, _ := s.GetWithVersion("expired")
+	time.Sleep(time.Millisecond)
+	if v := vs.Version("expired"); v == v4 {
+		t.Errorf("expired key should change its version, got %d", v)
+	}
+}
+
+func TestConditionalSet(t *testing.T) {
+	s, _ := New(3, 1)
+
+	for i, tc := range []struct {
+		key  string
+		val  []byte
+		want bool
+	}{
+		{key: "key", val: []byte("&1"), want: true},
+		{key: "key", val: []byte("&2"), want: false},
+		{key: "expired", val: []byte("&3"), want: true},
+	} {
+		if tc.key == "expired" {
+			s.Set(tc.key, []byte("&0"), time.Nanosecond)
+			time.Sleep(time.Millisecond)
+		}
+
+		ok, err := s.SetNX(tc.key, tc.val, 0)
+		if err != nil || ok != tc.want {
+			t.Errorf("[%d] %s: got %v, want %v, error: %v", i, tc.key, ok, tc.want, err)
+		}
+	}
+
+	if got, _ := s.Get("key"); !bytes.Equal(got, []byte("&1")) {
+		t.Errorf("existing value should be kept, got %q", got)
+	}
+
+	old, err := s.GetSet("key", []byte("&4"), 0)
+	if err != nil || !bytes.Equal(old, []byte("&1")) {
+		t.Errorf("previous value should be returned, got %q, error: %v", old, err)
+	}
+
+	old, err = s.GetSet("missed", []byte("&5"), 0)
+	if err != nil || old != nil {
+		t.Errorf("missed key should return nil, got %q, error: %v", old, err)
+	}
+
+	if got, _ := s.Get("missed"); !bytes.Equal(got, []byte("&5")) {
+		t.Errorf("value should be set, got %q", got)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db, _ := New(1, 1)
+	m := db.(*mStore)
+	defer m.Close()
+
+	for i := 0; i < 2*purgeBatch+1; i++ {
+		if err := db.Set(fmt.Sprintf("expired%d", i), testVal, time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("ttl", testVal, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("forever", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Rewritten without ttl, so it is not expired anymore.
+	if err := db.Set("expired0", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if n := m.Size(); n != 3 {
+		t.Errorf("expired keys should not be counted before they are purged, got size %d, want 3", n)
+	}
+	m.purger.purgeStaleKeys(m.layout().buckets[0])
+
+	keys := db.Keys()
+	sort.Strings(keys)
+	if want := []string{"expired0", "forever", "ttl"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+
+	if n := len(m.layout().buckets[0].expiry); n != 1 {
+		t.Errorf("only keys with ttl should be scheduled, got %d, want 1", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, _ := New(1, 1, WithMaxEntries(2))
+	m := db.(*mStore)
+	defer m.Close()
+
+	db.Set("a", testVal, 0)
+	db.Set("b", testVal, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	m.purger.purgeStaleKeys(m.layout().buckets[0])
+
+	db.Get("a")
+	db.Get("missed")
+	// a is the least recently used key by now, so it is evicted.
+	db.Set("c", testVal, 0)
+	db.Set("d", testVal, 0)
+
+	buckets := m.Stats().Buckets
+	if len(buckets) != 1 || buckets[0].Entries != 2 || buckets[0].Bytes != 2*m.layout().buckets[0].s["c"].size("c") {
+		t.Errorf("unexpected bucket stats: %+v", buckets)
+	}
+
+	db.Set("e", testVal, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	db.Get("e")
+
+	got := m.Stats()
+	got.Buckets = nil
+	want := store.Stats{Hits: 1, Misses: 2, Sets: 5, Evictions: 2, Expired: 1, LazyExpired: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db, _ := New(1, 1, WithMaxEntries(2), WithEvictionPolicy(VolatileTTL))
+	m := db.(*mStore)
+	defer m.Close()
+	e := db.(store.Expirer)
+
+	db.Set("soon", testVal, time.Hour)
+	db.Set("later", testVal, 2*time.Hour)
+	v := m.Version("soon")
+
+	if err := e.Expire("missed", time.Now().Add(time.Hour)); err != store.ErrNotFound {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	if err := e.Expire("soon", time.Now().Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := e.TTL("soon"); ttl <= 2*time.Hour || ttl > 3*time.Hour {
+		t.Errorf("ttl should be changed, got %v", ttl)
+	}
+	if m.Version("soon") == v {
+		t.Error("version should be changed along with ttl")
+	}
+
+	// The eviction policy follows the new ttl, so the one expiring soonest now goes first.
+	db.Set("new", testVal, 0)
+	if _, err := db.Get("later"); err != store.ErrNotFound {
+		t.Errorf("key expiring soonest should be evicted, got: %v", err)
+	}
+
+	if err := e.Persist("soon"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := e.TTL("soon"); ttl != 0 {
+		t.Errorf("ttl should be removed, got %v", ttl)
+	}
+	if n := len(m.layout().buckets[0].expiry); n != 0 {
+		t.Errorf("persistent key should not be scheduled, got %d", n)
+	}
+
+	if err := e.Touch("soon"); err != nil {
+		t.Error(err)
+	}
+
+	if err := e.Expire("soon", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Touch("soon"); err != store.ErrNotFound {
+		t.Errorf("key should be removed by ttl in the past, got: %v", err)
+	}
+}
+
+func TestSliding(t *testing.T) {
+	db, _ := New(1, 1)
+	m := db.(*mStore)
+	defer m.Close()
+	sl := db.(store.Slider)
+
+	if err := sl.SetSliding("session", testVal, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	v := m.Version("session")
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(30 * time.Millisecond)
+		if _, err := db.Get("session"); err != nil {
+			t.Fatalf("[%d] key should be renewed by reads, got: %v", i, err)
+		}
+	}
+
+	if m.Version("session") != v {
+		t.Error("renewal should not change the version")
+	}
+	if ttl, _ := sl.Sliding("session"); ttl != 50*time.Millisecond {
+		t.Errorf("unexpected sliding ttl, got %v", ttl)
+	}
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := New(1, 1)
+	defer restored.(*mStore).Close()
+	if err := restored.(store.Snapshotter).Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := restored.(store.Slider).Sliding("session"); ttl != 50*time.Millisecond {
+		t.Errorf("sliding ttl should be restored, got %v", ttl)
+	}
+
+	if err := db.(store.Expirer).Persist("session"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := sl.Sliding("session"); ttl != 0 {
+		t.Errorf("persistent key should not be renewed, got %v", ttl)
+	}
+
+	sl.SetSliding("session", testVal, time.Hour)
+	db.Set("session", testVal, time.Hour)
+	if ttl, _ := sl.Sliding("session"); ttl != 0 {
+		t.Errorf("replaced value should not be renewed, got %v", ttl)
+	}
+
+	sl.SetSliding("session", testVal, time.Hour)
+	if err := db.Update("session", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := sl.Sliding("session"); ttl != time.Hour {
+		t.Errorf("updated value should still be renewed, got %v", ttl)
+	}
+	if ttl, _ := db.(store.Expirer).TTL("session"); ttl <= 0 {
+		t.Errorf("updated value should keep expiring, got ttl %v", ttl)
+	}
+
+	sl.SetSliding("expired", testVal, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := db.Get("expired"); err != store.ErrNotFound {
+		t.Errorf("key not read in time should expire, got: %v", err)
+	}
+}
+
+func TestSlidingEviction(t *testing.T) {
+	db, _ := New(1, 1, WithMaxEntries(2), WithEvictionPolicy(LFU))
+	m := db.(*mStore)
+	defer m.Close()
+
+	db.(store.Slider).SetSliding("a", testVal, time.Hour)
+	db.Get("a")
+	db.Get("a")
+
+	if freq := m.layout().buckets[0].policy.(*lfu).items["a"].freq; freq != 3 {
+		t.Errorf("renewal should not count as another read, got frequency %d", freq)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	db, _ := New(1, 1, WithMaxEntries(2))
+	m := db.(*mStore)
+	defer m.Close()
+
+	m.SetSliding("session", testVal, time.Hour)
+	db.Set("b", testVal, 0)
+	deadline := m.layout().buckets[0].s["session"].ttl
+
+	val, ttl, sliding, err := m.Peek("session")
+	if err != nil || !bytes.Equal(val, testVal) || ttl <= 0 || ttl > time.Hour || sliding != time.Hour {
+		t.Errorf("unexpected peek: %q, ttl %v, sliding %v, err: %v", val, ttl, sliding, err)
+	}
+	if _, _, _, err = m.Peek("missed"); err != store.ErrNotFound {
+		t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+	}
+
+	if got := m.layout().buckets[0].s["session"].ttl; got != deadline {
+		t.Errorf("peek should not renew sliding ttl, got %v, want %v", got, deadline)
+	}
+	if got := m.Stats(); got.Hits != 0 || got.Misses != 0 {
+		t.Errorf("peek should not count reads, got %+v", got)
+	}
+
+	// Peeked session stays the least recently used key, so it is evicted.
+	db.Set("c", testVal, 0)
+	if _, err = db.Get("session"); err != store.ErrNotFound {
+		t.Errorf("peeked key should be evicted first, got: %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	db, _ := New(4, 0, WithMaxEntries(100))
+	m := db.(*mStore)
+	defer m.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), testVal, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := m.Size(); n != 50 {
+		t.Errorf("unexpected size: got %d, want 50", n)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := m.Size(); n != 0 {
+		t.Errorf("should be empty after flush, got %d keys", n)
+	}
+
+	for i, b := range m.layout().buckets {
+		if b.size != 0 || len(b.expiry) != 0 {
+			t.Errorf("[%d] bucket should be reset, got size %d and %d scheduled keys", i, b.size, len(b.expiry))
+		}
+	}
+
+	if err := db.Set("key", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Size(); n != 1 {
+		t.Errorf("should be usable after flush, got %d keys", n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	db, _ := New(1, 1)
+	m := db.(*mStore)
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("closing again should do nothing, got: %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	db, _ := New(4, 1)
+	defer db.(*mStore).Close()
+	scanner := db.(store.Scanner)
+
+	for i := 0; i < 100; i++ {
+		if err := db.Set(fmt.Sprintf("key%d", i), testVal, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("other", testVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("expired", testVal, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	calls := 0
+
+	for {
+		keys, next := scanner.Scan(cursor, "key*", 7)
+		calls++
+		for _, k := range keys {
+			seen[k]++
+		}
+
+		// Mutations between calls should not break the iteration.
+		db.Set(fmt.Sprintf("new%d", calls), testVal, 0)
+		db.Remove(fmt.Sprintf("key%d", 99-calls))
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	for i := 0; i < 100-calls; i++ {
+		if k := fmt.Sprintf("key%d", i); seen[k] != 1 {
+			t.Errorf("key existent during the whole scan should be seen once, %s seen %d times", k, seen[k])
+		}
+	}
+
+	if seen["other"] != 0 || seen["expired"] != 0 {
+		t.Errorf("only live matching keys should be returned, got %v", seen)
+	}
+
+	if calls < 100/7 {
+		t.Errorf("scan should be incremental, got %d calls", calls)
+	}
+}
+
+/*
+$ go test -run none -bench Purge -benchmem ./store/mstore
+BenchmarkPurgeScan 	      50	  23783933 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPurgeHeap 	   19484	     59391 ns/op	       0 B/op	       0 allocs/op
+
+Purging 100 expired keys out of 100k, the scan holds the bucket lock for the whole run.
+*/
+
+func BenchmarkPurgeScan(b *testing.B) {
+	benchmarkPurge(b, func(bk *bucket) {
+		// The purger used before expiry heap, it visits every key.
+		bk.mu.Lock()
+		defer bk.mu.Unlock()
+
+		for k, e := range bk.s {
+			if e.expired() {
+				bk.delete(k)
+			}
+		}
+	})
+}
+
+func BenchmarkPurgeHeap(b *testing.B) {
+	p := newPurger()
+	benchmarkPurge(b, p.purgeStaleKeys)
+}
+
+func benchmarkPurge(b *testing.B, purge func(*bucket)) {
+	bk, _ := newBucket(0, 0, LRU)
+
+	ttl := time.Now().Add(time.Hour)
+	for i := 0; i < 100000; i++ {
+		bk.put(fmt.Sprintf("key%d", i), &entry{val: testVal, ttl: ttl})
+	}
+
+	expired := make([]string, 100)
+	for i := range expired {
+		expired[i] = fmt.Sprintf("expired%d", i)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		past := time.Now().Add(-time.Second)
+		for _, k := range expired {
+			bk.put(k, &entry{val: testVal, ttl: past})
+		}
+		b.StartTimer()
+
+		purge(bk)
+	}
+}
@@ -0,0 +1,66 @@
+package mstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+// notifier passes changes of keys to functions registered with Notify.
+type notifier struct {
+	// n is the number of functions registered, so changes are not even timed when nobody listens.
+	n    int32
+	fns  map[int]func(e store.Event)
+	next int
+
+	mu sync.RWMutex
+}
+
+func newNotifier() *notifier {
+	return &notifier{fns: make(map[int]func(e store.Event))}
+}
+
+// Notify implements store.Notifier.
+func (m *mStore) Notify(fn func(e store.Event)) (cancel func()) {
+	return m.events.add(fn)
+}
+
+func (n *notifier) add(fn func(e store.Event)) (cancel func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	id := n.next
+	n.next++
+	n.fns[id] = fn
+	atomic.AddInt32(&n.n, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			delete(n.fns, id)
+			atomic.AddInt32(&n.n, -1)
+		})
+	}
+}
+
+// notify reports a change of a key, it is called with bucket lock held.
+// It is safe to call it on nil notifier of a bucket which is not a part of a store.
+func (n *notifier) notify(key string, op store.EventOp) {
+	if n == nil || atomic.LoadInt32(&n.n) == 0 {
+		return
+	}
+
+	e := store.Event{Key: key, Op: op, Time: time.Now()}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, fn := range n.fns {
+		fn(e)
+	}
+}
@@ -0,0 +1,86 @@
+package mstore
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+func TestNotify(t *testing.T) {
+	db, _ := New(1, 1, WithMaxEntries(2))
+	m := db.(*mStore)
+	defer m.Close()
+
+	var mu sync.Mutex
+	var got []string
+	cancel := m.Notify(func(e store.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if e.Time.IsZero() {
+			t.Errorf("event should be timed: %+v", e)
+		}
+		got = append(got, e.Key+" "+string(e.Op))
+	})
+
+	db.Set("a", testVal, 0)
+	db.Set("a", testVal, 0)
+	db.Set("b", testVal, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	m.purger.purgeStaleKeys(m.layout().buckets[0])
+	db.Set("c", testVal, 0)
+	db.Set("d", testVal, 0)
+	db.Remove("c")
+
+	cancel()
+	cancel()
+	db.Set("e", testVal, 0)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{"a set", "a update", "b set", "b expire", "c set", "d set", "a evict", "c remove"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events: got %q, want %q", got, want)
+	}
+}
+
+func TestNotifyTransaction(t *testing.T) {
+	db, _ := New(2, 1)
+	m := db.(*mStore)
+	defer m.Close()
+
+	db.Set("a", testVal, 0)
+
+	var got []string
+	defer m.Notify(func(e store.Event) { got = append(got, e.Key+" "+string(e.Op)) })()
+
+	err := m.Transaction([]string{"a", "b"}, func(tx store.Store) error {
+		tx.Set("a", testVal, 0)
+		tx.Remove("a")
+		tx.Set("b", testVal, 0)
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("transaction should be rolled back")
+	}
+	if len(got) != 0 {
+		t.Errorf("rolled back changes should not be reported, got %q", got)
+	}
+
+	err = m.Transaction([]string{"a", "b"}, func(tx store.Store) error {
+		tx.Set("b", testVal, 0)
+		return tx.Remove("a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"b set", "a remove"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events: got %q, want %q", got, want)
+	}
+}
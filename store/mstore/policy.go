@@ -0,0 +1,55 @@
+package mstore
+
+import "fmt"
+
+// EvictionPolicy names a strategy used to pick entries to evict from a bounded store.
+type EvictionPolicy string
+
+// Supported eviction policies.
+const (
+	// LRU evicts least recently used entries.
+	LRU EvictionPolicy = "lru"
+	// LFU evicts least frequently used entries.
+	LFU EvictionPolicy = "lfu"
+	// WTinyLFU keeps recent entries in a small window and admits them into the main space
+	// only if they are used more often than entries they would replace.
+	WTinyLFU EvictionPolicy = "wtinylfu"
+	// Random evicts random entries.
+	Random EvictionPolicy = "random"
+	// VolatileTTL evicts entries which are going to expire soonest, entries without ttl go last.
+	VolatileTTL EvictionPolicy = "volatile-ttl"
+)
+
+// policy decides which entry of a bucket to evict next.
+// Methods are called under bucket lock, access might be called under read lock only,
+// so implementations should be safe for concurrent use.
+type policy interface {
+	// add records a new entry e for a key or replaces an existing one.
+	add(key string, e *entry)
+	// access records a read of a key.
+	access(key string)
+	// retime records a new ttl of an existing entry e for a key, it is not a use of the key.
+	retime(key string, e *entry)
+	// remove forgets a key.
+	remove(key string)
+	// victim returns a key to evict next.
+	victim() (key string, ok bool)
+}
+
+// newPolicy returns a policy for a bucket keeping up to capacity entries, 0 if it is not known.
+func newPolicy(p EvictionPolicy, capacity int) (policy, error) {
+	switch p {
+	case LRU:
+		return newLRU(), nil
+	case LFU:
+		return newLFU(), nil
+	case WTinyLFU:
+		return newWTinyLFU(capacity), nil
+	case Random:
+		return newRandom(), nil
+	case VolatileTTL:
+		return newVolatileTTL(), nil
+	}
+
+	return nil, fmt.Errorf("unknown eviction policy: %q", p)
+}
@@ -0,0 +1,136 @@
+package mstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPolicyVictim(t *testing.T) {
+	soon := &entry{ttl: time.Now().Add(time.Hour)}
+	later := &entry{ttl: time.Now().Add(2 * time.Hour)}
+	never := &entry{}
+
+	for i, tc := range []struct {
+		policy EvictionPolicy
+		run    func(p policy)
+		want   string
+	}{
+		{
+			policy: LRU,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", never)
+				p.add("c", never)
+				p.access("a")
+			},
+			want: "b",
+		}, {
+			policy: LFU,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", never)
+				p.add("c", never)
+				p.access("a")
+				p.access("a")
+				p.access("c")
+			},
+			want: "b",
+		}, {
+			policy: LFU,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", never)
+				p.access("a")
+				p.remove("b")
+			},
+			want: "a",
+		}, {
+			policy: Random,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", never)
+				p.remove("a")
+			},
+			want: "b",
+		}, {
+			policy: VolatileTTL,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", later)
+				p.add("c", soon)
+			},
+			want: "c",
+		}, {
+			policy: VolatileTTL,
+			run: func(p policy) {
+				p.add("a", never)
+				p.add("b", soon)
+				p.add("b", never)
+			},
+			want: "a",
+		},
+	} {
+		p, err := newPolicy(tc.policy, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tc.run(p)
+
+		got, ok := p.victim()
+		if !ok || got != tc.want {
+			t.Errorf("[%d] %s: got victim %q, want %q", i, tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := New(1, 1, WithMaxEntries(1), WithEvictionPolicy("fifo")); err == nil {
+		t.Error("should fail for unknown eviction policy")
+	}
+}
+
+func TestWTinyLFUScanResistance(t *testing.T) {
+	for i, tc := range []struct {
+		policy EvictionPolicy
+		min    int
+		max    int
+	}{
+		{policy: LRU, min: 0, max: 0},
+		{policy: WTinyLFU, min: 45, max: 50},
+	} {
+		db, err := New(1, 1, WithMaxEntries(100), WithEvictionPolicy(tc.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k := 0; k < 50; k++ {
+			key := fmt.Sprintf("hot%d", k)
+			if err = db.Set(key, testVal, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			for n := 0; n < 10; n++ {
+				db.Get(key)
+			}
+		}
+
+		for k := 0; k < 1000; k++ {
+			if err = db.Set(fmt.Sprintf("cold%d", k), testVal, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		hot := 0
+		for k := 0; k < 50; k++ {
+			if _, err = db.Get(fmt.Sprintf("hot%d", k)); err == nil {
+				hot++
+			}
+		}
+
+		if hot < tc.min || hot > tc.max {
+			t.Errorf("[%d] %s: %d hot keys survived a scan, want between %d and %d", i, tc.policy, hot, tc.min, tc.max)
+		}
+	}
+}
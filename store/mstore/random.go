@@ -0,0 +1,68 @@
+package mstore
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// random picks victims uniformly at random.
+type random struct {
+	keys  []string
+	items map[string]int
+
+	mu sync.Mutex
+}
+
+func newRandom() *random {
+	return &random{
+		items: make(map[string]int),
+	}
+}
+
+// add implements policy.
+func (r *random) add(key string, _ *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.items[key]; ok {
+		return
+	}
+
+	r.items[key] = len(r.keys)
+	r.keys = append(r.keys, key)
+}
+
+// access implements policy.
+func (r *random) access(string) {}
+
+// retime implements policy, ttl doesn't matter to it.
+func (r *random) retime(string, *entry) {}
+
+// remove implements policy.
+func (r *random) remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.items[key]
+	if !ok {
+		return
+	}
+
+	last := len(r.keys) - 1
+	r.keys[i] = r.keys[last]
+	r.items[r.keys[i]] = i
+	r.keys = r.keys[:last]
+	delete(r.items, key)
+}
+
+// victim implements policy.
+func (r *random) victim() (key string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.keys) == 0 {
+		return "", false
+	}
+
+	return r.keys[rand.Intn(len(r.keys))], true
+}
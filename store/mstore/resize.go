@@ -0,0 +1,207 @@
+package mstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spaolacci/murmur3"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// ErrResizing returned when a store is asked to resize while it is resizing already.
+var ErrResizing = errors.New("store is being resized")
+
+// layout is an arrangement of buckets keys are spread over. It is never changed,
+// a new one is published instead, so it could be read without any locks.
+type layout struct {
+	buckets []*bucket
+	// old are buckets entries are migrated from to buckets while the store is resized, nil otherwise.
+	old []*bucket
+	// migrated is the number of buckets of old moved already, they are migrated in order.
+	migrated int
+}
+
+func (m *mStore) layout() *layout {
+	return m.l.Load().(*layout)
+}
+
+// bucket returns a bucket of a key, along with the length of its table and its index there.
+func (l *layout) bucket(key string) (b *bucket, tableLen, i int) {
+	h := murmur3.Sum64([]byte(key))
+
+	if n := len(l.old); n > 0 {
+		if i = int(h % uint64(n)); i >= l.migrated {
+			return l.old[i], n, i
+		}
+	}
+
+	n := len(l.buckets)
+	i = int(h % uint64(n))
+
+	return l.buckets[i], n, i
+}
+
+// all returns all the buckets, ones of the old table go first.
+// Buckets migrated already and ones which are not migrated to yet are empty.
+func (l *layout) all() []*bucket {
+	if l.old == nil {
+		return l.buckets
+	}
+
+	return append(append([]*bucket(nil), l.old...), l.buckets...)
+}
+
+// scanBucket returns a bucket holding keys of a bucket i of the new table,
+// along with a filter of those keys if the bucket is not migrated to yet.
+func (l *layout) scanBucket(i int) (*bucket, func(key string) bool) {
+	if n := len(l.old); n > 0 && i%n >= l.migrated {
+		size := uint64(len(l.buckets))
+		return l.old[i%n], func(key string) bool { return murmur3.Sum64([]byte(key))%size == uint64(i) }
+	}
+
+	return l.buckets[i], nil
+}
+
+func (m *mStore) getBucket(key string) *bucket {
+	b, _, _ := m.layout().bucket(key)
+	return b
+}
+
+// lockBucket returns a bucket of a key with write lock held.
+// A bucket could be migrated while the lock is being taken, then the new one is locked instead.
+func (m *mStore) lockBucket(key string) *bucket {
+	for {
+		b := m.getBucket(key)
+		b.mu.Lock()
+		if !b.moved {
+			return b
+		}
+		b.mu.Unlock()
+	}
+}
+
+// rlockBucket returns a bucket of a key with read lock held, see lockBucket.
+func (m *mStore) rlockBucket(key string) *bucket {
+	for {
+		b := m.getBucket(key)
+		b.mu.RLock()
+		if !b.moved {
+			return b
+		}
+		b.mu.RUnlock()
+	}
+}
+
+// Resize implements store.Resizer.
+// Entries are migrated to the new buckets in background one bucket at a time,
+// so only keys of a bucket being migrated wait for it.
+func (m *mStore) Resize(n int) error {
+	m.resizeMu.Lock()
+	defer m.resizeMu.Unlock()
+
+	l := m.layout()
+	if l.old != nil {
+		return ErrResizing
+	}
+
+	if n <= len(l.buckets) || n%len(l.buckets) != 0 {
+		return fmt.Errorf("should be a multiple of %d buckets greater than it, got %d", len(l.buckets), n)
+	}
+
+	buckets, err := m.newBuckets(n)
+	if err != nil {
+		return err
+	}
+
+	m.l.Store(&layout{buckets: buckets, old: l.buckets})
+	go m.rehash()
+
+	return nil
+}
+
+// rehash migrates buckets of the old table one by one until all of them are moved or the store is closed.
+func (m *mStore) rehash() {
+	for {
+		select {
+		case <-m.purger.quit:
+			return
+		default:
+			if !m.migrateNext() {
+				return
+			}
+		}
+	}
+}
+
+// migrateNext migrates the next bucket of the old table and reports whether there are more of them.
+func (m *mStore) migrateNext() bool {
+	m.resizeMu.Lock()
+	defer m.resizeMu.Unlock()
+
+	l := m.layout()
+	if l.old == nil {
+		return false
+	}
+
+	i := l.migrated
+	migrate(l.old[i], i, len(l.old), l.buckets)
+
+	if i+1 == len(l.old) {
+		m.l.Store(&layout{buckets: l.buckets})
+		return false
+	}
+
+	m.l.Store(&layout{buckets: l.buckets, old: l.old, migrated: i + 1})
+	return true
+}
+
+// migrate moves entries of a bucket b with index i in the old table of oldLen buckets to the new buckets,
+// they could go only to ones with the same index modulo oldLen. The bucket is marked as moved,
+// so operations waiting for it look for the new bucket of their key.
+func migrate(b *bucket, i, oldLen int, buckets []*bucket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var targets []*bucket
+	for j := i; j < len(buckets); j += oldLen {
+		targets = append(targets, buckets[j])
+		buckets[j].mu.Lock()
+		defer buckets[j].mu.Unlock()
+	}
+
+	for k, e := range b.s {
+		b.unlink(k, e)
+		buckets[murmur3.Sum64([]byte(k))%uint64(len(buckets))].link(k, e)
+	}
+
+	// Counters stay with the first bucket, so totals are kept.
+	targets[0].sets += b.sets
+	targets[0].evictions += b.evictions
+	targets[0].expired += b.expired
+	b.sets, b.evictions, b.expired = 0, 0, 0
+	b.moved = true
+
+	for _, t := range targets {
+		if t.policy != nil {
+			t.evict()
+		}
+	}
+}
+
+// checkLoad starts doubling the number of buckets once the average number of entries
+// per bucket exceeds the max load factor.
+func (m *mStore) checkLoad() {
+	if m.maxLoadFactor <= 0 {
+		return
+	}
+
+	l := m.layout()
+	if l.old != nil || float64(m.Size()) <= m.maxLoadFactor*float64(len(l.buckets)) {
+		return
+	}
+
+	if err := m.Resize(2 * len(l.buckets)); err != nil && err != ErrResizing {
+		log.Err("unable to resize a store: %v", err)
+	}
+}
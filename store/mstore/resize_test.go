@@ -0,0 +1,160 @@
+package mstore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+func TestResize(t *testing.T) {
+	db, _ := New(2, 1)
+	m := db.(*mStore)
+	defer m.Close()
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key%d", i)
+		db.Set(k, []byte("&0"), time.Hour)
+		want = append(want, k)
+	}
+	sort.Strings(want)
+
+	// The old and the new tables are switched by hand, so keys could be checked between migrations.
+	old := m.layout().buckets
+	buckets, _ := m.newBuckets(6)
+	m.l.Store(&layout{buckets: buckets, old: old})
+
+	if err := m.Resize(12); err != ErrResizing {
+		t.Errorf("should be error: got: %v, want: %v", err, ErrResizing)
+	}
+
+	for step := 0; ; step++ {
+		keys := db.Keys()
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, want) || m.Size() != len(want) {
+			t.Fatalf("[%d] all the keys should be available, got %d keys", step, len(keys))
+		}
+
+		var scanned []string
+		for cursor, first := uint64(0), true; first || cursor != 0; first = false {
+			var found []string
+			found, cursor = m.Scan(cursor, "", 7)
+			scanned = append(scanned, found...)
+		}
+		sort.Strings(scanned)
+		if !reflect.DeepEqual(scanned, want) {
+			t.Fatalf("[%d] scan should return every key once, got %d keys", step, len(scanned))
+		}
+
+		err := m.Transaction(want, func(tx store.Store) error {
+			for _, k := range want {
+				if _, err := tx.(store.Counter).IncrBy(k, 1); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%d] %v", step, err)
+		}
+
+		if !m.migrateNext() {
+			break
+		}
+	}
+
+	l := m.layout()
+	if l.old != nil || len(l.buckets) != 6 {
+		t.Fatalf("resize should be finished, got %d old and %d new buckets", len(l.old), len(l.buckets))
+	}
+
+	for _, b := range old {
+		if !b.moved || len(b.s) != 0 || len(b.expiry) != 0 {
+			t.Errorf("old bucket should be emptied and moved, got %d keys", len(b.s))
+		}
+	}
+
+	for _, k := range want {
+		val, err := db.Get(k)
+		if err != nil || string(val) != "&2" {
+			t.Errorf("%s should be kept with its changes, got %q, err: %v", k, val, err)
+		}
+
+		if ttl, _ := m.TTL(k); ttl <= 0 {
+			t.Errorf("%s should keep its ttl, got %v", k, ttl)
+		}
+	}
+
+	if err := m.Resize(8); err == nil {
+		t.Error("should not resize to a number which is not a multiple of buckets")
+	}
+}
+
+func TestResizeConcurrently(t *testing.T) {
+	db, _ := New(1, 1)
+	m := db.(*mStore)
+	defer m.Close()
+
+	for i := 0; i < 1000; i++ {
+		db.Set(fmt.Sprintf("key%d", i), []byte("&0"), 0)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				if _, err := db.(store.Counter).IncrBy(fmt.Sprintf("key%d", j), 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	if err := m.Resize(16); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for m.layout().old != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	for j := 0; j < 1000; j++ {
+		if val, err := db.Get(fmt.Sprintf("key%d", j)); err != nil || string(val) != "&4" {
+			t.Fatalf("no increment should be lost, got %q, err: %v", val, err)
+		}
+	}
+}
+
+func TestMaxLoadFactor(t *testing.T) {
+	db, _ := New(2, 1, WithMaxLoadFactor(2))
+	m := db.(*mStore)
+	defer m.Close()
+
+	for i := 0; i < 4; i++ {
+		db.Set(fmt.Sprintf("key%d", i), testVal, 0)
+	}
+	m.checkLoad()
+	if l := m.layout(); l.old != nil || len(l.buckets) != 2 {
+		t.Errorf("store should not be resized under the load factor, got %d buckets", len(l.buckets))
+	}
+
+	db.Set("key4", testVal, 0)
+	m.checkLoad()
+	for m.layout().old != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := len(m.layout().buckets); n != 4 {
+		t.Errorf("buckets should be doubled, got %d", n)
+	}
+}
@@ -0,0 +1,105 @@
+package mstore
+
+import (
+	"math/bits"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// minScanSlots is the number of slots a scan table starts with.
+const minScanSlots = 8
+
+// scanTable keeps keys of a bucket in slots by their scan hash, the number of slots is a power of two
+// and follows the number of keys. A scan visits the slots in the reverse binary order of their indexes,
+// as Redis does, so a position stays valid when the table grows or shrinks between calls: keys are never
+// missed, though some might be returned twice once it shrinks. A call does the work proportional
+// to the keys it returns rather than to the keys of the bucket.
+type scanTable struct {
+	slots [][]string
+	n     int
+}
+
+// add adds a key which is not in the table yet.
+func (t *scanTable) add(key string) {
+	if t.n >= len(t.slots) {
+		size := 2 * len(t.slots)
+		if size < minScanSlots {
+			size = minScanSlots
+		}
+		t.resize(size)
+	}
+
+	i := scanHash(key) & t.mask()
+	t.slots[i] = append(t.slots[i], key)
+	t.n++
+}
+
+// remove removes a key if it is in the table.
+func (t *scanTable) remove(key string) {
+	if t.n == 0 {
+		return
+	}
+
+	i := scanHash(key) & t.mask()
+	s := t.slots[i]
+	for j, k := range s {
+		if k == key {
+			s[j] = s[len(s)-1]
+			s[len(s)-1] = ""
+			t.slots[i] = s[:len(s)-1]
+			t.n--
+			break
+		}
+	}
+
+	if len(t.slots) > minScanSlots && t.n < len(t.slots)/4 {
+		t.resize(len(t.slots) / 2)
+	}
+}
+
+func (t *scanTable) resize(size int) {
+	slots := make([][]string, size)
+	mask := uint32(size - 1)
+
+	for _, s := range t.slots {
+		for _, k := range s {
+			i := scanHash(k) & mask
+			slots[i] = append(slots[i], k)
+		}
+	}
+
+	t.slots = slots
+}
+
+func (t *scanTable) mask() uint32 {
+	return uint32(len(t.slots) - 1)
+}
+
+// scan calls fn with keys of whole slots starting from the position pos until about count keys are visited,
+// and returns the position to continue from, 0 once all the slots are visited.
+func (t *scanTable) scan(pos uint32, count int, fn func(key string)) (next uint32) {
+	if t.n == 0 {
+		return 0
+	}
+
+	m := t.mask()
+	for visited := 0; ; {
+		for _, k := range t.slots[pos&m] {
+			fn(k)
+			visited++
+		}
+
+		// The position is incremented in its reversed high bits above the mask, so the slots
+		// of a larger or smaller table with the same low bits are visited next.
+		pos = bits.Reverse32(bits.Reverse32(pos|^m) + 1)
+
+		if pos == 0 || visited >= count {
+			return pos
+		}
+	}
+}
+
+// scanHash places keys inside a bucket for scans, it differs from the one choosing a bucket.
+func scanHash(key string) uint32 {
+	return murmur3.Sum32([]byte(key))
+}
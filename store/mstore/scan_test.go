@@ -0,0 +1,48 @@
+package mstore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanTable(t *testing.T) {
+	var tbl scanTable
+	for i := 0; i < 10000; i++ {
+		tbl.add(fmt.Sprintf("key%d", i))
+	}
+
+	// A call visits about count keys, whatever the size of the table.
+	visited := 0
+	tbl.scan(0, 10, func(string) { visited++ })
+	if visited < 10 || visited > 30 {
+		t.Errorf("scan should visit about 10 keys, got %d", visited)
+	}
+
+	// The table grows and shrinks between calls, still every key there all the time is seen.
+	seen := make(map[string]int)
+	pos, calls := uint32(0), 0
+	for first := true; first || pos != 0; first = false {
+		pos = tbl.scan(pos, 100, func(k string) { seen[k]++ })
+
+		calls++
+		switch {
+		case calls%7 == 3:
+			for i := 0; i < 30000; i++ {
+				tbl.add(fmt.Sprintf("new%d-%d", calls, i))
+			}
+		case calls%7 == 5:
+			for i := 0; i < 30000; i++ {
+				tbl.remove(fmt.Sprintf("new%d-%d", calls-2, i))
+			}
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		if k := fmt.Sprintf("key%d", i); seen[k] == 0 {
+			t.Fatalf("%s should be seen", k)
+		}
+	}
+	if calls < 10000/100 {
+		t.Errorf("scan should be incremental, got %d calls", calls)
+	}
+}
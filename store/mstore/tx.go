@@ -0,0 +1,476 @@
+package mstore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+// Transaction implements store.Transactor.
+// Buckets of the keys are locked in order of their indexes, the same one snapshots use,
+// so transactions never deadlock with each other. Buckets of the old table go first while
+// the store is resized, the locking starts over if any of them is migrated meanwhile.
+func (m *mStore) Transaction(keys []string, fn func(tx store.Store) error) error {
+	t := m.lockTx(keys)
+	defer t.unlock()
+
+	saved := t.save()
+	if err := fn(t); err != nil {
+		t.rollback(saved)
+		t.publish(true)
+		return err
+	}
+
+	t.publish(false)
+
+	// Nothing is evicted while the buckets are held, so a rollback doesn't lose other keys.
+	for _, b := range t.locked {
+		if b.policy != nil {
+			b.evict()
+		}
+	}
+
+	return nil
+}
+
+// lockTx returns a transaction of keys with all their buckets locked.
+func (m *mStore) lockTx(keys []string) *tx {
+	type position struct {
+		b             *bucket
+		tableLen, idx int
+	}
+
+	for {
+		l := m.layout()
+		t := &tx{m: m, buckets: make(map[string]*bucket, len(keys))}
+
+		var positions []position
+		for _, k := range keys {
+			if _, ok := t.buckets[k]; ok {
+				continue
+			}
+
+			b, tableLen, i := l.bucket(k)
+			t.buckets[k] = b
+			positions = append(positions, position{b: b, tableLen: tableLen, idx: i})
+		}
+
+		sort.Slice(positions, func(i, j int) bool {
+			pi, pj := positions[i], positions[j]
+			return pi.tableLen < pj.tableLen || (pi.tableLen == pj.tableLen && pi.idx < pj.idx)
+		})
+
+		moved := false
+		for _, p := range positions {
+			if len(t.locked) > 0 && t.locked[len(t.locked)-1] == p.b {
+				continue
+			}
+
+			p.b.mu.Lock()
+			t.locked = append(t.locked, p.b)
+			moved = moved || p.b.moved
+		}
+
+		if !moved {
+			for _, b := range t.locked {
+				b.held = t
+			}
+			return t
+		}
+		t.unlock()
+	}
+}
+
+func (t *tx) unlock() {
+	for _, b := range t.locked {
+		b.held = nil
+		b.mu.Unlock()
+	}
+}
+
+// publish reports changes kept while the transaction held its buckets, none of them once it is rolled back.
+// It is called with the buckets locked.
+func (t *tx) publish(rolledBack bool) {
+	for _, b := range t.locked {
+		b.held = nil
+	}
+
+	if !rolledBack {
+		for _, e := range t.events {
+			t.m.events.notify(e.key, e.op)
+		}
+	}
+	t.events = nil
+}
+
+// tx is a view of the store used by a transaction, it works only with keys
+// which buckets are locked and it never locks them on its own.
+type tx struct {
+	m *mStore
+	// buckets are locked buckets by keys of the transaction.
+	buckets map[string]*bucket
+	// locked are the buckets in order they are locked.
+	locked []*bucket
+	// events are changes of keys made while the buckets are held, see publish.
+	events []heldEvent
+}
+
+// heldEvent is a change of a key made by a transaction.
+type heldEvent struct {
+	key string
+	op  store.EventOp
+}
+
+// savedEntry is a copy of an entry taken before a transaction starts.
+type savedEntry struct {
+	// e is the entry itself, nil if the key was missed.
+	e *entry
+	// version of the entry, as it is changed in place.
+	version uint64
+	val     []byte
+	ttl     time.Time
+	sliding time.Duration
+}
+
+// save copies entries of all the keys, structured values are changed in place,
+// so they are saved encoded.
+func (t *tx) save() map[string]savedEntry {
+	saved := make(map[string]savedEntry, len(t.buckets))
+	for k, b := range t.buckets {
+		e, ok := b.s[k]
+		if !ok {
+			saved[k] = savedEntry{}
+			continue
+		}
+
+		saved[k] = savedEntry{
+			e:       e,
+			version: e.version,
+			val:     append([]byte(nil), e.encoded()...),
+			ttl:     e.ttl,
+			sliding: e.sliding,
+		}
+	}
+
+	return saved
+}
+
+// rollback restores keys changed since they were saved.
+func (t *tx) rollback(saved map[string]savedEntry) {
+	for k, s := range saved {
+		b := t.buckets[k]
+		e, ok := b.s[k]
+
+		if s.e == nil {
+			if ok {
+				b.delete(k)
+			}
+			continue
+		}
+
+		if !ok || e != s.e || e.version != s.version {
+			b.put(k, &entry{val: s.val, ttl: s.ttl, sliding: s.sliding, version: s.version})
+		}
+	}
+}
+
+func (t *tx) bucket(key string) (*bucket, error) {
+	b, ok := t.buckets[key]
+	if !ok {
+		return nil, store.ErrNotInTransaction
+	}
+
+	return b, nil
+}
+
+// Get implements store.Store.
+func (t *tx) Get(key string) ([]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.getSliding(b, key)
+}
+
+// GetWithVersion implements store.Store.
+func (t *tx) GetWithVersion(key string) ([]byte, uint64, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return t.m.getWithVersion(b, key)
+}
+
+// Set implements store.Store.
+func (t *tx) Set(key string, val []byte, ttl time.Duration) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.set(b, key, val, ttl)
+}
+
+// SetNX implements store.Store.
+func (t *tx) SetNX(key string, val []byte, ttl time.Duration) (bool, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return false, err
+	}
+
+	return t.m.setNX(b, key, val, ttl)
+}
+
+// GetSet implements store.Store.
+func (t *tx) GetSet(key string, val []byte, ttl time.Duration) ([]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.getSet(b, key, val, ttl)
+}
+
+// Update implements store.Store.
+func (t *tx) Update(key string, val []byte, ttl time.Duration) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.update(b, key, val, ttl)
+}
+
+// Remove implements store.Store.
+func (t *tx) Remove(key string) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.remove(b, key)
+}
+
+// CompareAndSwap implements store.Store.
+func (t *tx) CompareAndSwap(key string, val []byte, version uint64, ttl time.Duration) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.compareAndSwap(b, key, val, version, ttl)
+}
+
+// Keys implements store.Store, only keys of the transaction are returned.
+func (t *tx) Keys() (keys []string) {
+	for k, b := range t.buckets {
+		if e, ok := b.s[k]; ok && !e.expired() {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// SetSliding implements store.Slider.
+func (t *tx) SetSliding(key string, val []byte, ttl time.Duration) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.setSliding(b, key, val, ttl)
+}
+
+// Sliding implements store.Slider.
+func (t *tx) Sliding(key string) (time.Duration, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.sliding(b, key)
+}
+
+// Peek implements store.Peeker.
+func (t *tx) Peek(key string) ([]byte, time.Duration, time.Duration, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return t.m.peek(b, key)
+}
+
+// Version implements store.Versioner.
+func (t *tx) Version(key string) uint64 {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0
+	}
+
+	return t.m.keyVersion(b, key)
+}
+
+// IncrBy implements store.Counter.
+func (t *tx) IncrBy(key string, delta int64) (int64, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.incrBy(b, key, delta)
+}
+
+// TTL implements store.Expirer.
+func (t *tx) TTL(key string) (time.Duration, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.ttl(b, key)
+}
+
+// Expire implements store.Expirer.
+func (t *tx) Expire(key string, at time.Time) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.expire(b, key, at)
+}
+
+// Persist implements store.Expirer.
+func (t *tx) Persist(key string) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.persist(b, key)
+}
+
+// Touch implements store.Expirer.
+func (t *tx) Touch(key string) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.touch(b, key)
+}
+
+// Push implements store.Lister.
+func (t *tx) Push(key string, front bool, vals ...[]byte) (int, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.push(b, key, front, vals...)
+}
+
+// Pop implements store.Lister.
+func (t *tx) Pop(key string, front bool) ([]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.pop(b, key, front)
+}
+
+// Range implements store.Lister.
+func (t *tx) Range(key string, start, stop int) ([][]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.lrange(b, key, start, stop)
+}
+
+// Trim implements store.Lister.
+func (t *tx) Trim(key string, start, stop int) error {
+	b, err := t.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	return t.m.trim(b, key, start, stop)
+}
+
+// Len implements store.Lister.
+func (t *tx) Len(key string) (int, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.llen(b, key)
+}
+
+// HGet implements store.Hasher.
+func (t *tx) HGet(key string, field []byte) ([]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.hget(b, key, field)
+}
+
+// HSet implements store.Hasher.
+func (t *tx) HSet(key string, field, val []byte) (bool, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return false, err
+	}
+
+	return t.m.hset(b, key, field, val)
+}
+
+// HDel implements store.Hasher.
+func (t *tx) HDel(key string, fields ...[]byte) (int, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.hdel(b, key, fields...)
+}
+
+// HKeys implements store.Hasher.
+func (t *tx) HKeys(key string) ([][]byte, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.m.hkeys(b, key)
+}
+
+// HLen implements store.Hasher.
+func (t *tx) HLen(key string) (int, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.hlen(b, key)
+}
+
+// HIncrBy implements store.Hasher.
+func (t *tx) HIncrBy(key string, field []byte, delta int64) (int64, error) {
+	b, err := t.bucket(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.m.hincrBy(b, key, field, delta)
+}
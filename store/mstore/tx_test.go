@@ -0,0 +1,201 @@
+package mstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliaksandrb/cachy/store"
+)
+
+func TestTransaction(t *testing.T) {
+	s, _ := New(4, 1)
+	tr := s.(store.Transactor)
+
+	s.Set("kept", []byte("&1"), 0)
+	s.Set("list", []byte("@1\n&1"), 0)
+	_, version, _ := s.GetWithVersion("kept")
+
+	err := tr.Transaction([]string{"kept", "list", "new"}, func(tx store.Store) error {
+		if err := tx.Set("kept", []byte("&2"), 0); err != nil {
+			return err
+		}
+		if _, err := tx.(store.Lister).Push("list", false, []byte("&2")); err != nil {
+			return err
+		}
+		if err := tx.Set("new", []byte("&3"), 0); err != nil {
+			return err
+		}
+
+		return errors.New("rollback")
+	})
+	if err == nil || err.Error() != "rollback" {
+		t.Fatalf("should return the error of fn, got: %v", err)
+	}
+
+	val, v, err := s.GetWithVersion("kept")
+	if err != nil || !bytes.Equal(val, []byte("&1")) || v != version {
+		t.Errorf("should be rolled back with its version, got %q, version %d, err: %v", val, v, err)
+	}
+
+	if val, _ = s.Get("list"); !bytes.Equal(val, []byte("@1\n&1")) {
+		t.Errorf("structured value should be rolled back, got %q", val)
+	}
+
+	if _, err = s.Get("new"); err != store.ErrNotFound {
+		t.Errorf("new key should be removed, got: %v", err)
+	}
+
+	err = tr.Transaction([]string{"kept", "new"}, func(tx store.Store) error {
+		if err := tx.Set("missed", []byte("&1"), 0); err != store.ErrNotInTransaction {
+			t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotInTransaction)
+		}
+
+		if _, err := tx.(store.Counter).IncrBy("kept", 1); err != nil {
+			return err
+		}
+
+		return tx.Set("new", []byte("&3"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _ = s.Get("kept"); !bytes.Equal(val, []byte("&2")) {
+		t.Errorf("should be committed, got %q", val)
+	}
+	if val, _ = s.Get("new"); !bytes.Equal(val, []byte("&3")) {
+		t.Errorf("should be committed, got %q", val)
+	}
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	s, _ := New(8, 1)
+	tr := s.(store.Transactor)
+
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	// Every transaction moves one from a key to the next one, so the sum never changes.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				from, to := keys[(i+j)%len(keys)], keys[(i+j+1)%len(keys)]
+				err := tr.Transaction([]string{to, from}, func(tx store.Store) error {
+					c := tx.(store.Counter)
+					if _, err := c.IncrBy(from, -1); err != nil {
+						return err
+					}
+					_, err := c.IncrBy(to, 1)
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for j := 0; j < 100; j++ {
+		var sum int64
+		err := tr.Transaction(keys, func(tx store.Store) error {
+			for _, k := range keys {
+				n, err := tx.(store.Counter).IncrBy(k, 0)
+				if err != nil {
+					return err
+				}
+				sum += n
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sum != 0 {
+			t.Fatalf("transactions should be isolated, got sum %d", sum)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestTransactionEviction(t *testing.T) {
+	s, _ := New(1, 1, WithMaxEntries(2))
+	m := s.(*mStore)
+	defer m.Close()
+
+	s.Set("a", testVal, 0)
+	s.Set("b", testVal, 0)
+
+	var events []string
+	defer m.Notify(func(e store.Event) { events = append(events, e.Key+" "+string(e.Op)) })()
+
+	err := m.Transaction([]string{"c"}, func(tx store.Store) error {
+		tx.Set("c", testVal, 0)
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatal("transaction should be rolled back")
+	}
+
+	for _, k := range []string{"a", "b"} {
+		if _, err := s.Get(k); err != nil {
+			t.Errorf("%s should not be evicted by a rolled back transaction: %v", k, err)
+		}
+	}
+	if _, err := s.Get("c"); err != store.ErrNotFound {
+		t.Errorf("c should be rolled back, got: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("rolled back transaction should report nothing, got %q", events)
+	}
+
+	err = m.Transaction([]string{"c"}, func(tx store.Store) error {
+		return tx.Set("c", testVal, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Size(); n != 2 {
+		t.Errorf("committed transaction should evict to fit the limit, got %d keys", n)
+	}
+	if len(events) != 2 || events[0] != "c set" {
+		t.Errorf("set and eviction should be reported, got %q", events)
+	}
+}
+
+func TestTransactionLazyExpiry(t *testing.T) {
+	s, _ := New(1, 1)
+	m := s.(*mStore)
+	defer m.Close()
+
+	s.Set("key", testVal, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// The expired entry is removed once the transaction releases the key, which is set again by then.
+	err := m.Transaction([]string{"key"}, func(tx store.Store) error {
+		if _, err := tx.Get("key"); err != store.ErrNotFound {
+			t.Errorf("should be error: got: %v, want: %v", err, store.ErrNotFound)
+		}
+		return tx.Set("key", []byte("&2"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if val, err := s.Get("key"); err != nil || !bytes.Equal(val, []byte("&2")) {
+		t.Errorf("value set after expiry should be kept, got %q, err: %v", val, err)
+	}
+}
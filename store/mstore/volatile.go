@@ -0,0 +1,130 @@
+package mstore
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// volatileTTL picks keys which are going to expire soonest.
+// Keys without ttl are evicted last, in the order they were added.
+type volatileTTL struct {
+	h     ttlHeap
+	items map[string]*ttlItem
+	seq   uint64
+
+	mu sync.Mutex
+}
+
+type ttlItem struct {
+	key   string
+	ttl   time.Time
+	seq   uint64
+	index int
+}
+
+func newVolatileTTL() *volatileTTL {
+	return &volatileTTL{
+		items: make(map[string]*ttlItem),
+	}
+}
+
+// add implements policy.
+func (v *volatileTTL) add(key string, e *entry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.seq++
+
+	if it, ok := v.items[key]; ok {
+		it.ttl = e.ttl
+		it.seq = v.seq
+		heap.Fix(&v.h, it.index)
+		return
+	}
+
+	it := &ttlItem{key: key, ttl: e.ttl, seq: v.seq}
+	v.items[key] = it
+	heap.Push(&v.h, it)
+}
+
+// access implements policy.
+func (v *volatileTTL) access(string) {}
+
+// retime implements policy, the key keeps its place among keys with the same ttl.
+func (v *volatileTTL) retime(key string, e *entry) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if it, ok := v.items[key]; ok {
+		it.ttl = e.ttl
+		heap.Fix(&v.h, it.index)
+	}
+}
+
+// remove implements policy.
+func (v *volatileTTL) remove(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	it, ok := v.items[key]
+	if !ok {
+		return
+	}
+
+	heap.Remove(&v.h, it.index)
+	delete(v.items, key)
+}
+
+// victim implements policy.
+func (v *volatileTTL) victim() (key string, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.h) == 0 {
+		return "", false
+	}
+
+	return v.h[0].key, true
+}
+
+// ttlHeap implements heap.Interface ordering items by ttl.
+type ttlHeap []*ttlItem
+
+func (h ttlHeap) Len() int { return len(h) }
+
+func (h ttlHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+
+	switch {
+	case a.ttl == b.ttl:
+		return a.seq < b.seq
+	case a.ttl == zeroTime:
+		return false
+	case b.ttl == zeroTime:
+		return true
+	}
+
+	return a.ttl.Before(b.ttl)
+}
+
+func (h ttlHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ttlHeap) Push(x interface{}) {
+	it := x.(*ttlItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *ttlHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
@@ -0,0 +1,269 @@
+package mstore
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// Segments of a W-TinyLFU policy.
+const (
+	window = iota
+	probation
+	protected
+)
+
+const (
+	windowRatio    = 100 // window takes 1/windowRatio of the capacity
+	protectedRatio = 0.8 // protected segment takes that part of the main space
+)
+
+// wTinyLFU keeps new keys in a small LRU window, keys leaving the window are admitted
+// into the main segmented LRU only if they are estimated to be used more often than
+// the main's victim. Usage is estimated with a count-min sketch.
+//
+// The capacity of a bucket limited by bytes is not known in advance, so it is adjusted
+// to the number of entries a bucket had when it started to evict.
+type wTinyLFU struct {
+	capacity int
+	segments [3]*list.List
+	items    map[string]*wItem
+	sketch   *sketch
+
+	mu sync.Mutex
+}
+
+type wItem struct {
+	segment int
+	el      *list.Element
+}
+
+func newWTinyLFU(capacity int) *wTinyLFU {
+	return &wTinyLFU{
+		capacity: capacity,
+		items:    make(map[string]*wItem),
+		sketch:   newSketch(capacity),
+		segments: [3]*list.List{
+			list.New(), list.New(), list.New(),
+		},
+	}
+}
+
+func (w *wTinyLFU) windowMax() int {
+	n := w.capacity
+	if n == 0 {
+		n = len(w.items)
+	}
+
+	if n/windowRatio < 1 {
+		return 1
+	}
+
+	return n / windowRatio
+}
+
+// mainMax returns the size of the main space, it is unlimited until the first eviction.
+func (w *wTinyLFU) mainMax() int {
+	if w.capacity == 0 {
+		return len(w.items) + 1
+	}
+
+	return w.capacity - w.windowMax()
+}
+
+func (w *wTinyLFU) protectedMax() int {
+	return int(float64(w.mainMax()) * protectedRatio)
+}
+
+func (w *wTinyLFU) mainLen() int {
+	return w.segments[probation].Len() + w.segments[protected].Len()
+}
+
+// add implements policy.
+func (w *wTinyLFU) add(key string, _ *entry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sketch.increment(key)
+
+	if it, ok := w.items[key]; ok {
+		w.hit(it)
+		return
+	}
+
+	w.items[key] = &wItem{segment: window, el: w.segments[window].PushFront(key)}
+
+	// While the main space has room keys leaving the window are admitted without a contest.
+	for w.segments[window].Len() > w.windowMax() && w.mainLen() < w.mainMax() {
+		w.move(w.segments[window].Back(), probation)
+	}
+}
+
+// access implements policy.
+func (w *wTinyLFU) access(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sketch.increment(key)
+
+	if it, ok := w.items[key]; ok {
+		w.hit(it)
+	}
+}
+
+// retime implements policy, ttl doesn't matter to it.
+func (w *wTinyLFU) retime(string, *entry) {}
+
+// remove implements policy.
+func (w *wTinyLFU) remove(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	it, ok := w.items[key]
+	if !ok {
+		return
+	}
+
+	w.segments[it.segment].Remove(it.el)
+	delete(w.items, key)
+}
+
+// victim implements policy.
+func (w *wTinyLFU) victim() (key string, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.items) == 0 {
+		return "", false
+	}
+
+	// The bucket went over its limit, so it is able to keep one entry less.
+	if w.capacity = len(w.items) - 1; w.capacity < 1 {
+		w.capacity = 1
+	}
+
+	candidate := w.segments[window].Back()
+	mainVictim := w.segments[probation].Back()
+	if mainVictim == nil {
+		mainVictim = w.segments[protected].Back()
+	}
+
+	switch {
+	case candidate == nil:
+		return mainVictim.Value.(string), true
+	case mainVictim == nil:
+		return candidate.Value.(string), true
+	case w.segments[window].Len() <= w.windowMax():
+		return mainVictim.Value.(string), true
+	}
+
+	if w.sketch.estimate(candidate.Value.(string)) > w.sketch.estimate(mainVictim.Value.(string)) {
+		w.move(candidate, probation)
+		return mainVictim.Value.(string), true
+	}
+
+	return candidate.Value.(string), true
+}
+
+// hit moves a key to the front of its segment, keys hit in probation get protected.
+func (w *wTinyLFU) hit(it *wItem) {
+	switch it.segment {
+	case window, protected:
+		w.segments[it.segment].MoveToFront(it.el)
+	case probation:
+		w.move(it.el, protected)
+
+		for w.segments[protected].Len() > w.protectedMax() {
+			w.move(w.segments[protected].Back(), probation)
+		}
+	}
+}
+
+// move moves an element el to the front of the segment.
+func (w *wTinyLFU) move(el *list.Element, segment int) {
+	key := el.Value.(string)
+	it := w.items[key]
+
+	w.segments[it.segment].Remove(el)
+	it.segment = segment
+	it.el = w.segments[segment].PushFront(key)
+}
+
+const (
+	sketchDepth    = 4
+	sketchMaxFreq  = 15
+	sketchMinWidth = 1024
+)
+
+// sketch is a count-min sketch estimating how often keys are used.
+// Counters are halved periodically, so the estimation reflects recent usage.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newSketch(capacity int) *sketch {
+	width := sketchMinWidth
+	for width < capacity {
+		width <<= 1
+	}
+
+	s := &sketch{
+		mask:    uint32(width - 1),
+		resetAt: width * 10,
+	}
+
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+func (s *sketch) indexes(key string) (idx [sketchDepth]uint32) {
+	h := murmur3.Sum64([]byte(key))
+	h1, h2 := uint32(h), uint32(h>>32)
+
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+
+	return
+}
+
+func (s *sketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < sketchMaxFreq {
+			s.rows[i][j]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(sketchMaxFreq)
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < min {
+			min = s.rows[i][j]
+		}
+	}
+
+	return min
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+
+	s.additions /= 2
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Snapshotter is implemented by stores able to dump and load the whole keyspace.
+type Snapshotter interface {
+	// Snapshot writes all the live entries into w in a snapshot format.
+	Snapshot(w io.Writer) error
+	// Restore loads entries from a snapshot in r, existing keys are overwritten.
+	Restore(r io.Reader) error
+}
+
+// ErrBadSnapshot returned when a snapshot is malformed or corrupted.
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+/*
+Snapshot format:
+
+	header: "CACHY" version(1 byte)
+	entry:  1 uvarint(len(key)) key uvarint(len(val)) val varint(remaining ttl in ns, 0 for none)
+	        varint(sliding ttl in ns, 0 for none)
+	footer: 0 crc32(4 bytes, big endian, of everything before)
+
+Values are stored as raw protocol-encoded bytes. Entries of version 1 have no sliding ttl.
+*/
+const (
+	snapshotMagic   = "CACHY"
+	snapshotVersion = 2
+
+	markEntry byte = 1
+	markEnd   byte = 0
+
+	// maxSnapshotBytes limits the length of a key or a value read, so a corrupted length
+	// is rejected before anything is allocated for it.
+	maxSnapshotBytes = 1 << 30
+)
+
+// SnapshotWriter writes entries in a snapshot format.
+type SnapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewSnapshotWriter returns a writer of a snapshot into w and writes its header.
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	crc := crc32.NewIEEE()
+	s := &SnapshotWriter{
+		w:   bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc: crc,
+	}
+
+	if _, err := s.w.WriteString(snapshotMagic); err != nil {
+		return nil, err
+	}
+
+	if err := s.w.WriteByte(snapshotVersion); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write writes a single entry with remaining ttl and sliding ttl it is renewed with, 0 means none.
+func (s *SnapshotWriter) Write(key string, val []byte, ttl, sliding time.Duration) error {
+	s.w.WriteByte(markEntry)
+	s.w.Write(s.buf[:binary.PutUvarint(s.buf[:], uint64(len(key)))])
+	s.w.WriteString(key)
+	s.w.Write(s.buf[:binary.PutUvarint(s.buf[:], uint64(len(val)))])
+	s.w.Write(val)
+	s.w.Write(s.buf[:binary.PutVarint(s.buf[:], int64(ttl))])
+	_, err := s.w.Write(s.buf[:binary.PutVarint(s.buf[:], int64(sliding))])
+
+	return err
+}
+
+// Close writes a snapshot footer and flushes the data, it doesn't close underlying writer.
+func (s *SnapshotWriter) Close() error {
+	if err := s.w.WriteByte(markEnd); err != nil {
+		return err
+	}
+
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(s.buf[:4], s.crc.Sum32())
+	if _, err := s.w.Write(s.buf[:4]); err != nil {
+		return err
+	}
+
+	return s.w.Flush()
+}
+
+// ReadSnapshot reads a snapshot from r calling fn for every entry.
+// Entries are passed to fn before the checksum is verified, so a caller should be ready
+// to discard them if ErrBadSnapshot is returned.
+func ReadSnapshot(r io.Reader, fn func(key string, val []byte, ttl, sliding time.Duration) error) error {
+	cr := &crcReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	head := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(cr, head); err != nil {
+		return ErrBadSnapshot
+	}
+
+	version := head[len(snapshotMagic)]
+	if string(head[:len(snapshotMagic)]) != snapshotMagic || version < 1 || version > snapshotVersion {
+		return ErrBadSnapshot
+	}
+
+	for {
+		mark, err := cr.ReadByte()
+		if err != nil {
+			return ErrBadSnapshot
+		}
+
+		if mark == markEnd {
+			break
+		}
+
+		if mark != markEntry {
+			return ErrBadSnapshot
+		}
+
+		key, err := readBytes(cr)
+		if err != nil {
+			return err
+		}
+
+		val, err := readBytes(cr)
+		if err != nil {
+			return err
+		}
+
+		ttl, err := binary.ReadVarint(cr)
+		if err != nil || ttl < 0 {
+			return ErrBadSnapshot
+		}
+
+		var sliding int64
+		if version > 1 {
+			if sliding, err = binary.ReadVarint(cr); err != nil || sliding < 0 {
+				return ErrBadSnapshot
+			}
+		}
+
+		if err = fn(string(key), val, time.Duration(ttl), time.Duration(sliding)); err != nil {
+			return err
+		}
+	}
+
+	sum := cr.crc.Sum32()
+
+	footer := make([]byte, 4)
+	if _, err := io.ReadFull(cr.r, footer); err != nil {
+		return ErrBadSnapshot
+	}
+
+	if binary.BigEndian.Uint32(footer) != sum {
+		return ErrBadSnapshot
+	}
+
+	return nil
+}
+
+// readBytes reads a length followed by as many bytes. They are read as they come rather than
+// allocated upfront, so a truncated snapshot fails without allocating the length it claims.
+func readBytes(r *crcReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > maxSnapshotBytes {
+		return nil, ErrBadSnapshot
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil || uint64(len(b)) != n {
+		return nil, ErrBadSnapshot
+	}
+
+	return b, nil
+}
+
+// crcReader computes a checksum of bytes being read.
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+func (c *crcReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
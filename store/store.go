@@ -9,20 +9,218 @@ import (
 type Store interface {
 	// Get is to get a value by key. ErrNotFound if the key is missed.
 	Get(key string) (val []byte, err error)
+	// GetWithVersion is to get a value by key along with its version. ErrNotFound if the key is missed.
+	// Versions grow with every write, so a changed value never gets a version it had before.
+	GetWithVersion(key string) (val []byte, version uint64, err error)
 	// Set is to set a value for a key with ttl provided.
 	Set(key string, val []byte, ttl time.Duration) error
+	// SetNX is to set a value for a key only if the key is missed, ok reports whether it was set.
+	SetNX(key string, val []byte, ttl time.Duration) (ok bool, err error)
+	// GetSet is to set a value for a key and return the previous one, nil if the key was missed.
+	GetSet(key string, val []byte, ttl time.Duration) (old []byte, err error)
 	// Update is to update a value for a key provided. ErrNotFound if the key is missed.
 	Update(key string, val []byte, ttl time.Duration) error
 	// Remove removes a value by key.ErrNotFound if the key is missed.
 	Remove(key string) error
+	// CompareAndSwap is to set a value for a key only if its version still matches the one provided.
+	// ErrNotFound if the key is missed, ErrVersionMismatch if it was changed since.
+	CompareAndSwap(key string, val []byte, version uint64, ttl time.Duration) error
 	// Keys returns a slice of string keys existent in underlying store.
-	// Currently it might return a staled keys too.
 	Keys() []string
 }
 
+// Scanner is implemented by stores able to iterate keys incrementally.
+type Scanner interface {
+	// Scan visits about count keys starting from cursor and returns ones matching pattern
+	// along with a cursor to continue from. Iteration starts and ends with 0 cursor.
+	// Keys existent during the whole iteration are returned at least once.
+	Scan(cursor uint64, pattern string, count int) (keys []string, next uint64)
+}
+
+// Counter is implemented by stores able to change integer values atomically.
+type Counter interface {
+	// IncrBy adds delta to an integer value of a key and returns the result.
+	// A missed key is considered to be 0, the ttl of an existing one is kept.
+	// ErrNotInteger if the value is not an integer.
+	IncrBy(key string, delta int64) (int64, error)
+}
+
+// Lister is implemented by stores able to change slice values in place, like lists.
+// Elements are raw encoded values. Negative indexes count from the end of a list, -1 is the last element.
+type Lister interface {
+	// Push adds vals to the head or the tail of a list one by one and returns its new length.
+	// A missed key is considered to be an empty list. ErrNotList if the value is not a slice.
+	Push(key string, front bool, vals ...[]byte) (int, error)
+	// Pop removes and returns the first or the last element of a list, the key is removed with the last one.
+	// ErrNotFound if the key is missed.
+	Pop(key string, front bool) ([]byte, error)
+	// Range returns elements between start and stop inclusive, none if the key is missed.
+	Range(key string, start, stop int) ([][]byte, error)
+	// Trim keeps only elements between start and stop inclusive, the key is removed if none left.
+	Trim(key string, start, stop int) error
+	// Len returns the length of a list, 0 if the key is missed.
+	Len(key string) (int, error)
+}
+
+// Hasher is implemented by stores able to change map values field by field, like hashes.
+// Fields and values are raw encoded.
+type Hasher interface {
+	// HGet returns a value of a field. ErrNotFound if the key or the field is missed.
+	HGet(key string, field []byte) ([]byte, error)
+	// HSet sets a value of a field and reports whether the field is new.
+	// A missed key is considered to be an empty map. ErrNotMap if the value is not a map.
+	HSet(key string, field, val []byte) (bool, error)
+	// HDel removes fields and returns the number of ones removed, the key is removed with the last field.
+	HDel(key string, fields ...[]byte) (int, error)
+	// HKeys returns fields of a map, none if the key is missed.
+	HKeys(key string) ([][]byte, error)
+	// HLen returns the number of fields of a map, 0 if the key is missed.
+	HLen(key string) (int, error)
+	// HIncrBy adds delta to an integer value of a field and returns the result.
+	// A missed field is considered to be 0. ErrNotInteger if the value is not an integer.
+	HIncrBy(key string, field []byte, delta int64) (int64, error)
+}
+
+// Flusher is implemented by stores able to remove all the keys at once.
+type Flusher interface {
+	// Flush removes all the keys.
+	Flush() error
+}
+
+// Sizer is implemented by stores able to count keys without listing them.
+type Sizer interface {
+	// Size returns the number of keys, expired ones which are not purged yet might be counted.
+	Size() int
+}
+
+// Resizer is implemented by stores able to change the number of their buckets without stopping.
+type Resizer interface {
+	// Resize starts moving keys to n buckets and returns right away, keys are available all the time.
+	Resize(n int) error
+}
+
+// Statser is implemented by stores able to report their metrics.
+type Statser interface {
+	Stats() Stats
+}
+
+// Stats are metrics of a store, counters are taken since the store is created.
+type Stats struct {
+	// Hits and Misses are the numbers of reads of present and missed keys.
+	Hits   uint64
+	Misses uint64
+	// Sets is the number of values stored.
+	Sets      uint64
+	Evictions uint64
+	// Expired is the number of expired keys reclaimed in background.
+	Expired uint64
+	// LazyExpired is the number of expired keys removed once they are read.
+	LazyExpired uint64
+	Buckets     []BucketStats
+}
+
+// BucketStats are metrics of a single bucket of a store.
+type BucketStats struct {
+	Entries int
+	// Bytes is a total size of keys and values.
+	Bytes int64
+}
+
+// Transactor is implemented by stores able to apply a batch of operations atomically.
+type Transactor interface {
+	// Transaction calls fn with a store holding keys exclusively until fn returns,
+	// so others never see a part of its changes. The store passed implements the same
+	// optional interfaces as the transactor. Everything done through it is rolled back
+	// if fn returns an error. ErrNotInTransaction for keys which are not listed.
+	// Changes are reported to Notifier once fn returns, and none of them if they are rolled back.
+	Transaction(keys []string, fn func(tx Store) error) error
+}
+
+// Versioner is implemented by stores able to tell versions of keys without reading their values.
+type Versioner interface {
+	// Version returns the version of a key as GetWithVersion does. A missed key has a version as well,
+	// which changes once the key is set and removed again, so watchers never miss such writes.
+	Version(key string) uint64
+}
+
+// Slider is implemented by stores able to renew ttl of keys every time they are read, like sessions.
+// Expire of such a key changes its current deadline only, Update keeps it renewed with the same ttl
+// and renews its deadline unless another ttl is given, while Persist or any other write replacing
+// the value makes it a regular key.
+type Slider interface {
+	// SetSliding sets a value for a key with ttl which is renewed by every Get of the key.
+	SetSliding(key string, val []byte, ttl time.Duration) error
+	// Sliding returns the ttl a key is renewed with, 0 if it is not renewed. ErrNotFound if the key is missed.
+	Sliding(key string) (time.Duration, error)
+}
+
+// Peeker is implemented by stores able to read a key without it counting as a use of the key.
+type Peeker interface {
+	// Peek returns a value of a key along with the time left before it expires and the ttl it is renewed with,
+	// 0 for none. Unlike Get it counts no hits or misses, renews no sliding ttl and doesn't change the order
+	// of eviction. ErrNotFound if the key is missed.
+	Peek(key string) (val []byte, ttl, sliding time.Duration, err error)
+}
+
+// Notifier is implemented by stores able to report changes of keys as they happen.
+type Notifier interface {
+	// Notify calls fn with every change of a key until cancel is called. fn is called while the key
+	// is locked, so it should return fast and must not use the store.
+	Notify(fn func(e Event)) (cancel func())
+}
+
+// EventOp is a kind of a change of a key.
+type EventOp string
+
+// Supported kinds of changes.
+const (
+	// EventSet reported when a missed key gets a value.
+	EventSet EventOp = "set"
+	// EventUpdate reported when a value or ttl of an existing key is changed.
+	EventUpdate EventOp = "update"
+	// EventRemove reported when a key is removed.
+	EventRemove EventOp = "remove"
+	// EventExpire reported when an expired key is removed.
+	EventExpire EventOp = "expire"
+	// EventEvict reported when a key is evicted to fit store limits.
+	EventEvict EventOp = "evict"
+)
+
+// Event describes a change of a key.
+type Event struct {
+	Key  string
+	Op   EventOp
+	Time time.Time
+}
+
+// Expirer is implemented by stores able to inspect and change ttl of keys without rewriting their values.
+type Expirer interface {
+	// TTL returns the time left before a key expires, 0 if it never does. ErrNotFound if the key is missed.
+	TTL(key string) (time.Duration, error)
+	// Expire sets a key to expire at a time, the key is removed right away if the time is not in the future.
+	// ErrNotFound if the key is missed.
+	Expire(key string, at time.Time) error
+	// Persist removes ttl of a key, so it never expires. ErrNotFound if the key is missed.
+	Persist(key string) error
+	// Touch records a usage of a key, like a read does, without reading it. ErrNotFound if the key is missed.
+	Touch(key string) error
+}
+
 var (
 	// ErrNotFound returned when there is not value for a key or it is expired.
 	ErrNotFound = errors.New("not found")
+	// ErrVersionMismatch returned when a value was changed since its version was taken.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrNotList returned when a list operation is applied to a value of another type.
+	ErrNotList = errors.New("value is not a list")
+	// ErrNotMap returned when a hash operation is applied to a value of another type.
+	ErrNotMap = errors.New("value is not a map")
+	// ErrNotInteger returned when an integer operation is applied to a value of another type.
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrNotInTransaction returned when a transaction accesses a key it does not hold.
+	ErrNotInTransaction = errors.New("key is not a part of the transaction")
+	// ErrOverflow returned when an integer operation overflows.
+	ErrOverflow = errors.New("integer overflow")
 	// ErrUnsuportedStoreType returned when store initialized with an unsuported type.
 	ErrUnsuportedStoreType = errors.New("unsuported store type")
 )
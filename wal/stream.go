@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Encoder encodes records into a stream in the log format, a record is preceded by selecting
+// its database if it differs from the database of the previous one.
+type Encoder struct {
+	db int
+}
+
+// NewEncoder returns an encoder of a stream which has database db selected already, -1 if none.
+func NewEncoder(db int) *Encoder {
+	return &Encoder{db: db}
+}
+
+// Encode returns records encoded one after another.
+func (e *Encoder) Encode(recs ...*Record) []byte {
+	var b []byte
+	for _, r := range recs {
+		if r.DB != e.db {
+			b = append(b, selectRecord(r.DB).Encode()...)
+			e.db = r.DB
+		}
+		b = append(b, r.Encode()...)
+	}
+
+	return b
+}
+
+// DB returns the database selected by the stream at its end.
+func (e *Encoder) DB() int {
+	return e.db
+}
+
+// Decoder reads records of a stream encoded by Encoder.
+type Decoder struct {
+	r  io.Reader
+	db int
+}
+
+// NewDecoder returns a decoder of a stream which has database db selected already.
+func NewDecoder(r io.Reader, db int) *Decoder {
+	return &Decoder{r: r, db: db}
+}
+
+// Decode returns the next record of the stream along with the number of bytes it takes,
+// including selecting its database. io.EOF is returned only at the end of a record.
+func (d *Decoder) Decode() (rec *Record, n int, err error) {
+	db := d.db
+	for {
+		r, size, err := ReadRecord(d.r)
+		if err != nil {
+			return nil, 0, err
+		}
+		n += size
+
+		if r.Op != opSelect {
+			r.DB, d.db = db, db
+			return r, n, nil
+		}
+
+		if db, err = strconv.Atoi(r.Key); err != nil {
+			return nil, 0, fmt.Errorf("%v: bad database %q", ErrBadRecord, r.Key)
+		}
+	}
+}
+
+// DB returns the database selected by the stream as of the last record decoded.
+func (d *Decoder) DB() int {
+	return d.db
+}
@@ -0,0 +1,445 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/aliaksandrb/cachy/logger"
+)
+
+// SyncPolicy defines how often the log is flushed to disk.
+type SyncPolicy string
+
+// Supported sync policies.
+const (
+	// SyncAlways syncs the log after every append.
+	SyncAlways SyncPolicy = "always"
+	// SyncEverySec syncs the log once a second, so at most a second of writes could be lost.
+	SyncEverySec SyncPolicy = "everysec"
+	// SyncNo leaves syncing up to the operating system.
+	SyncNo SyncPolicy = "no"
+)
+
+// Op is a type of operation recorded in the log.
+type Op byte
+
+// Supported operations.
+const (
+	OpSet Op = iota + 1
+	OpRemove
+	// OpFlush removes all the keys of a database.
+	OpFlush
+	// opSelect switches the database following records apply to, it is written by the log itself.
+	opSelect
+)
+
+var zeroTime = time.Time{}
+
+// Record is a single mutation in the log.
+type Record struct {
+	Op Op
+	// DB is an index of the database the record applies to.
+	DB  int
+	Key string
+	Val []byte
+	// TTL is an absolute expiration time, zero if a value never expires.
+	TTL time.Time
+	// Sliding is a ttl the value is renewed with every time it is read, zero if it is not renewed.
+	Sliding time.Duration
+}
+
+var (
+	// ErrRewriteInProgress returned when a rewrite is requested while another one is running.
+	ErrRewriteInProgress = errors.New("rewrite in progress")
+	// ErrBadRecord returned when a record is malformed or corrupted.
+	ErrBadRecord = errors.New("bad record")
+	// ErrLogFailed returned by appends to a log which could not be truncated after a failed append,
+	// until it is rewritten.
+	ErrLogFailed = errors.New("write-ahead log failed")
+)
+
+// Open opens or creates a log at path, replaying all the records in it via fn.
+// A torn or corrupted tail left after a crash is truncated.
+func Open(path string, policy SyncPolicy, fn func(r *Record) error) (*Log, error) {
+	switch policy {
+	case SyncAlways, SyncEverySec, SyncNo:
+	default:
+		return nil, fmt.Errorf("unknown sync policy: %q", policy)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := replay(f, fn)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &Log{
+		path:     path,
+		policy:   policy,
+		f:        f,
+		size:     size,
+		baseSize: size,
+		enc:      NewEncoder(-1),
+		quit:     make(chan struct{}),
+	}
+
+	if policy == SyncEverySec {
+		go l.syncer()
+	}
+
+	return l, nil
+}
+
+// Log is an append-only log of mutations.
+type Log struct {
+	path   string
+	policy SyncPolicy
+	f      *os.File
+	size   int64
+	// baseSize is the size of the log right after the last rewrite.
+	baseSize int64
+	dirty    bool
+	// failed is set once a failed append leaves a part of its records in the log, see Append.
+	failed bool
+
+	rewriting bool
+	// rewriteBuf keeps records appended while a rewrite is running.
+	rewriteBuf []byte
+	// enc encodes appended records, a new one selects the database of the next record.
+	enc *Encoder
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+func replay(f *os.File, fn func(r *Record) error) (int64, error) {
+	d := NewDecoder(bufio.NewReader(f), 0)
+
+	var off int64
+	for {
+		rec, n, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Err("corrupted log tail at %d, truncating: %v", off, err)
+			if err = f.Truncate(off); err != nil {
+				return 0, err
+			}
+			break
+		}
+
+		off += int64(n)
+
+		if err = fn(rec); err != nil {
+			return 0, err
+		}
+	}
+
+	return off, nil
+}
+
+// Append appends records to the log with a single write and syncs it according to the sync policy.
+// If the write or the sync fails, the log is truncated back, so none of the records are replayed
+// and the next ones are not appended after a torn record. ErrLogFailed if it could not be truncated.
+func (l *Log) Append(recs ...*Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failed {
+		return ErrLogFailed
+	}
+
+	db := l.enc.DB()
+	b := l.enc.Encode(recs...)
+	_, err := l.f.Write(b)
+	if err == nil && l.policy == SyncAlways {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.enc = NewEncoder(db)
+		l.truncate()
+		return err
+	}
+
+	l.size += int64(len(b))
+	if l.rewriting {
+		l.rewriteBuf = append(l.rewriteBuf, b...)
+	}
+
+	if l.policy != SyncAlways {
+		l.dirty = true
+	}
+	return nil
+}
+
+// truncate cuts off whatever a failed append left after the last record, or marks the log failed.
+// It is called with mu held.
+func (l *Log) truncate() {
+	err := l.f.Truncate(l.size)
+	if err == nil {
+		_, err = l.f.Seek(l.size, io.SeekStart)
+	}
+	if err != nil {
+		log.Err("unable to truncate log after a failed append: %v", err)
+		l.failed = true
+	}
+}
+
+// Size returns the size of the log in bytes.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+// NeedsRewrite reports if the log is larger than minSize and doubled since the last rewrite.
+func (l *Log) NeedsRewrite(minSize int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return !l.rewriting && l.size > minSize && l.size > 2*l.baseSize
+}
+
+// Rewrite replaces the log with records produced by dump, which is expected to add a record
+// for every live value. Records appended while dump runs are carried over into the new log,
+// so they must be safe to be applied on top of dumped values again.
+func (l *Log) Rewrite(dump func(add func(r *Record) error) error) error {
+	l.mu.Lock()
+	if l.rewriting {
+		l.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	l.rewriting = true
+	// Records carried over start with selecting their database, as the new log might end with any.
+	l.enc = NewEncoder(-1)
+	l.mu.Unlock()
+
+	tmpPath := l.path + ".rewrite"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		l.stopRewrite()
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := NewEncoder(0)
+	var size int64
+
+	err = dump(func(r *Record) error {
+		n, err := w.Write(enc.Encode(r))
+		size += int64(n)
+		return err
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err == nil {
+		n, _ := w.Write(l.rewriteBuf)
+		size += int64(n)
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+
+	l.rewriting = false
+	l.rewriteBuf = nil
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	l.f.Close()
+	l.f = tmp
+	l.size = size
+	l.baseSize = size
+	l.dirty = false
+	l.failed = false
+
+	return nil
+}
+
+func (l *Log) stopRewrite() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rewriting = false
+	l.rewriteBuf = nil
+}
+
+func (l *Log) syncer() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.quit:
+			return
+		case <-ticker.C:
+			if err := l.sync(); err != nil {
+				log.Err("unable to sync log: %v", err)
+			}
+		}
+	}
+}
+
+func (l *Log) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+
+	l.dirty = false
+	return l.f.Sync()
+}
+
+// Close syncs and closes the log, calls after the first one do nothing.
+func (l *Log) Close() (err error) {
+	l.closeOnce.Do(func() { err = l.close() })
+	return err
+}
+
+func (l *Log) close() error {
+	close(l.quit)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	return l.f.Close()
+}
+
+func selectRecord(db int) *Record {
+	return &Record{Op: opSelect, Key: strconv.Itoa(db)}
+}
+
+/*
+Record format, shared by the log and the disk store:
+
+	crc32(4 bytes, of everything after it) op(1 byte) ttl(8 bytes, unix ns, 0 for none)
+	len(key)(4 bytes) len(val)(4 bytes) [sliding ttl(8 bytes, ns)] key val
+
+Numbers are big endian. The sliding ttl is there only if slidingFlag is set in the op byte.
+*/
+
+// HeaderSize is a size of the fixed part of the record: crc, op, ttl, key and value lengths.
+const HeaderSize = 4 + 1 + 8 + 4 + 4
+
+// slidingFlag is set in the op byte of records with sliding ttl, which follows the header in 8 bytes,
+// so records without it keep the same format.
+const slidingFlag = 0x80
+
+// Encode returns the record in the record format, its database is not a part of it.
+func (r *Record) Encode() []byte {
+	var ext int
+	if r.Sliding != 0 {
+		ext = 8
+	}
+	b := make([]byte, HeaderSize+ext+len(r.Key)+len(r.Val))
+
+	b[4] = byte(r.Op)
+	if r.TTL != zeroTime {
+		binary.BigEndian.PutUint64(b[5:], uint64(r.TTL.UnixNano()))
+	}
+	binary.BigEndian.PutUint32(b[13:], uint32(len(r.Key)))
+	binary.BigEndian.PutUint32(b[17:], uint32(len(r.Val)))
+	if ext != 0 {
+		b[4] |= slidingFlag
+		binary.BigEndian.PutUint64(b[HeaderSize:], uint64(r.Sliding))
+	}
+	copy(b[HeaderSize+ext:], r.Key)
+	copy(b[HeaderSize+ext+len(r.Key):], r.Val)
+
+	binary.BigEndian.PutUint32(b, crc32.ChecksumIEEE(b[4:]))
+
+	return b
+}
+
+// ReadRecord reads a single record from r and returns it along with its encoded size.
+// io.EOF is returned only if there is nothing left, ErrBadRecord if the record is torn or corrupted.
+func ReadRecord(r io.Reader) (rec *Record, n int, err error) {
+	head := make([]byte, HeaderSize)
+	if _, err = io.ReadFull(r, head); err != nil {
+		if err == io.EOF {
+			return nil, 0, err
+		}
+		return nil, 0, ErrBadRecord
+	}
+
+	op := Op(head[4] &^ slidingFlag)
+	if op < OpSet || op > opSelect {
+		return nil, 0, fmt.Errorf("%v: unknown op %d", ErrBadRecord, op)
+	}
+
+	keyLen := binary.BigEndian.Uint32(head[13:])
+	valLen := binary.BigEndian.Uint32(head[17:])
+
+	var ext int
+	if head[4]&slidingFlag != 0 {
+		ext = 8
+	}
+
+	// The body is read as it comes, so a corrupted length doesn't allocate more than there is to read.
+	size := int64(ext) + int64(keyLen) + int64(valLen)
+	body, err := ioutil.ReadAll(io.LimitReader(r, size))
+	if err != nil || int64(len(body)) != size {
+		return nil, 0, ErrBadRecord
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(head[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(head) {
+		return nil, 0, fmt.Errorf("%v: checksum mismatch", ErrBadRecord)
+	}
+
+	rec = &Record{
+		Op:  op,
+		Key: string(body[ext : ext+int(keyLen)]),
+		Val: body[ext+int(keyLen):],
+	}
+
+	if ext != 0 {
+		rec.Sliding = time.Duration(binary.BigEndian.Uint64(body))
+	}
+
+	if ttl := binary.BigEndian.Uint64(head[5:]); ttl != 0 {
+		rec.TTL = time.Unix(0, int64(ttl))
+	}
+
+	return rec, HeaderSize + len(body), nil
+}
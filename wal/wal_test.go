@@ -0,0 +1,250 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(tempDir(t), "test.wal")
+	ttl := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+
+	want := []*Record{
+		{Op: OpSet, Key: "a", Val: []byte("&1")},
+		{Op: OpSet, Key: "b", Val: []byte("$\"b\""), TTL: ttl},
+		{Op: OpSet, Key: "c", Val: []byte("&3"), TTL: ttl, Sliding: time.Hour},
+		{Op: OpRemove, Key: "a", Val: []byte{}},
+	}
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncEverySec, SyncNo} {
+		os.Remove(path)
+
+		l, err := Open(path, policy, func(*Record) error { return nil })
+		checkErr(t, err)
+
+		for _, r := range want {
+			checkErr(t, l.Append(r))
+		}
+		checkErr(t, l.Close())
+		// Closed already, so it does nothing.
+		checkErr(t, l.Close())
+
+		got := replayAll(t, path)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", policy, got, want)
+		}
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(tempDir(t), "test.wal")
+
+	l, err := Open(path, SyncNo, func(*Record) error { return nil })
+	checkErr(t, err)
+	checkErr(t, l.Append(&Record{Op: OpSet, Key: "a", Val: []byte("&1")}))
+	checkErr(t, l.Append(&Record{Op: OpSet, Key: "b", Val: []byte("&2")}))
+	checkErr(t, l.Close())
+
+	info, err := os.Stat(path)
+	checkErr(t, err)
+	checkErr(t, os.Truncate(path, info.Size()-3))
+
+	got := replayAll(t, path)
+	if len(got) != 1 || got[0].Key != "a" {
+		t.Errorf("only intact records should be replayed, got %+v", got)
+	}
+}
+
+func TestFailedAppend(t *testing.T) {
+	path := filepath.Join(tempDir(t), "test.wal")
+
+	l, err := Open(path, SyncAlways, func(*Record) error { return nil })
+	checkErr(t, err)
+	checkErr(t, l.Append(&Record{Op: OpSet, Key: "a", Val: []byte("&1")}))
+
+	// A read-only file fails both the append and the truncation after it.
+	f, size := l.f, l.Size()
+	l.f, err = os.Open(path)
+	checkErr(t, err)
+
+	if err = l.Append(&Record{Op: OpSet, Key: "b", Val: []byte("&2")}); err == nil {
+		t.Fatal("append to a read-only file should fail")
+	}
+	if err = l.Append(&Record{Op: OpSet, Key: "c", Val: []byte("&3")}); err != ErrLogFailed {
+		t.Errorf("should be error: got: %v, want: %v", err, ErrLogFailed)
+	}
+	if l.Size() != size {
+		t.Errorf("failed append should not count, got size %d, want %d", l.Size(), size)
+	}
+
+	l.f.Close()
+	l.f = f
+	checkErr(t, l.Close())
+
+	got := replayAll(t, path)
+	if len(got) != 1 || got[0].Key != "a" {
+		t.Errorf("only appended records should be replayed, got %+v", got)
+	}
+}
+
+func TestStream(t *testing.T) {
+	want := []*Record{
+		{Op: OpSet, DB: 1, Key: "a", Val: []byte("&1")},
+		{Op: OpSet, DB: 1, Key: "b", Val: []byte("&2")},
+		{Op: OpRemove, DB: 0, Key: "a", Val: []byte{}},
+	}
+
+	enc := NewEncoder(0)
+	b := enc.Encode(want[:2]...)
+	b = append(b, enc.Encode(want[2])...)
+
+	// The stream is resumed right after the first record with its database.
+	first := len(selectRecord(1).Encode()) + len(want[0].Encode())
+	d := NewDecoder(bytes.NewReader(b[first:]), 1)
+
+	var (
+		got []*Record
+		n   int
+	)
+	for {
+		r, size, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		checkErr(t, err)
+
+		got = append(got, r)
+		n += size
+	}
+
+	if !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("got %+v, want %+v", got, want[1:])
+	}
+	if n != len(b)-first || d.DB() != 0 {
+		t.Errorf("should read %d bytes and end with database 0, got %d and %d", len(b)-first, n, d.DB())
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(tempDir(t), "test.wal")
+
+	l, err := Open(path, SyncNo, func(*Record) error { return nil })
+	checkErr(t, err)
+
+	for i := 0; i < 10; i++ {
+		checkErr(t, l.Append(&Record{Op: OpSet, Key: "a", Val: []byte("&1")}))
+	}
+
+	if !l.NeedsRewrite(0) {
+		t.Error("log should need a rewrite")
+	}
+
+	err = l.Rewrite(func(add func(r *Record) error) error {
+		// Records appended during a rewrite are carried over.
+		checkErr(t, l.Append(&Record{Op: OpSet, Key: "b", Val: []byte("&2")}))
+
+		if err := l.Rewrite(nil); err != ErrRewriteInProgress {
+			t.Errorf("concurrent rewrite should fail, got %v", err)
+		}
+
+		return add(&Record{Op: OpSet, Key: "a", Val: []byte("&1")})
+	})
+	checkErr(t, err)
+	checkErr(t, l.Append(&Record{Op: OpRemove, Key: "a"}))
+
+	if l.NeedsRewrite(0) {
+		t.Error("log should not need a rewrite right after it")
+	}
+	checkErr(t, l.Close())
+
+	got := replayAll(t, path)
+	var keys []string
+	for _, r := range got {
+		keys = append(keys, r.Key)
+	}
+
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected records after rewrite: got %q, want %q", keys, want)
+	}
+}
+
+func TestDatabases(t *testing.T) {
+	path := filepath.Join(tempDir(t), "test.wal")
+
+	l, err := Open(path, SyncNo, func(*Record) error { return nil })
+	checkErr(t, err)
+
+	for _, r := range []*Record{
+		{Op: OpSet, DB: 2, Key: "a"},
+		{Op: OpSet, DB: 2, Key: "b"},
+		{Op: OpFlush, DB: 1},
+	} {
+		checkErr(t, l.Append(r))
+	}
+
+	err = l.Rewrite(func(add func(r *Record) error) error {
+		checkErr(t, l.Append(&Record{Op: OpRemove, DB: 3, Key: "c"}))
+		checkErr(t, add(&Record{Op: OpSet, DB: 2, Key: "a"}))
+		return add(&Record{Op: OpSet, DB: 3, Key: "c"})
+	})
+	checkErr(t, err)
+	checkErr(t, l.Append(&Record{Op: OpSet, Key: "d"}))
+	checkErr(t, l.Close())
+
+	// The log ends with database 0, so a reopened one has to select database 3 again.
+	l, err = Open(path, SyncNo, func(*Record) error { return nil })
+	checkErr(t, err)
+	checkErr(t, l.Append(&Record{Op: OpSet, DB: 3, Key: "e"}))
+	checkErr(t, l.Close())
+
+	type dbKey struct {
+		db  int
+		key string
+	}
+
+	var got []dbKey
+	for _, r := range replayAll(t, path) {
+		got = append(got, dbKey{r.DB, r.Key})
+	}
+
+	want := []dbKey{{2, "a"}, {3, "c"}, {3, "c"}, {0, "d"}, {3, "e"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected records: got %+v, want %+v", got, want)
+	}
+}
+
+func replayAll(t *testing.T, path string) (got []*Record) {
+	t.Helper()
+
+	l, err := Open(path, SyncNo, func(r *Record) error {
+		got = append(got, r)
+		return nil
+	})
+	checkErr(t, err)
+	checkErr(t, l.Close())
+
+	return got
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "wal")
+	checkErr(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}